@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/is386/Go8080/i8080/devices"
+	"github.com/is386/Go8080/i8080Altair"
+)
+
+func main() {
+	org := flag.String("org", "0", "address to load the image at and start from")
+	switches := flag.String("switches", "0", "front panel switches, whose upper byte is read as the sense switches")
+	disks := flag.String("disks", "", "comma separated .dsk images for drives 0, 1 and so on")
+	tape := flag.String("tape", "", "paper tape to read on ports 6 and 7, or a Kansas City Standard cassette if it ends in .wav")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: altair [-org addr] [-switches val] [-disks a.dsk,b.dsk] [-tape file] image")
+		os.Exit(2)
+	}
+	addr, err := strconv.ParseUint(*org, 0, 16)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -org:", err)
+		os.Exit(2)
+	}
+	sw, err := strconv.ParseUint(*switches, 0, 16)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -switches:", err)
+		os.Exit(2)
+	}
+
+	m := i8080Altair.NewAltairMachine(os.Stdin, os.Stdout)
+	if err := m.LoadFile(flag.Arg(0), uint16(addr)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *disks != "" {
+		for i, image := range strings.Split(*disks, ",") {
+			if err := m.GetDisks().Mount(i, image); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *tape != "" {
+		status := devices.Status{Port: 6, Ready: 0x01, ActiveLow: true}
+		var reader *devices.TapeReader
+		if strings.HasSuffix(strings.ToLower(*tape), ".wav") {
+			reader, err = devices.LoadCassette(*tape, status, 7)
+		} else {
+			reader, err = devices.OpenTapeReader(*tape, status, 7)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		m.GetPorts().Attach(reader, reader.Ports()...)
+	}
+	defer m.Close()
+	m.SetSwitches(uint16(sw))
+	m.Examine(uint16(addr))
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		m.Stop()
+	}()
+	m.Run()
+	if err := m.GetDisks().Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	p := m.GetPanel()
+	fmt.Fprintf(os.Stderr, "\nstopped at %04X %02X\n", p.Address, p.Data)
+}
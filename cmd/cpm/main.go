@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/is386/Go8080/i8080CPM"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "host directory that holds the CP/M files")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: cpm [-dir dir] program [args...]")
+		os.Exit(2)
+	}
+
+	m := i8080CPM.NewCPMMachine(*dir, os.Stdin, os.Stdout)
+	// A terminal echoes the input itself.
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		m.SetEcho(false)
+	}
+	program, err := m.ResolveProgram(flag.Arg(0))
+	if err == nil {
+		err = m.LoadFile(program, flag.Args()[1:])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	m.Run()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/is386/Go8080/i8080CPM"
+)
+
+func main() {
+	ccp := flag.String("ccp", fmt.Sprintf("0x%04X", i8080CPM.CCP), "address of the CCP of the system on drive A")
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > i8080CPM.DRIVES {
+		fmt.Fprintf(os.Stderr, "usage: cpmboot [-ccp addr] a.dsk [b.dsk ...] (up to %d drives)\n", i8080CPM.DRIVES)
+		os.Exit(2)
+	}
+	addr, err := strconv.ParseUint(*ccp, 0, 16)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -ccp:", err)
+		os.Exit(2)
+	}
+
+	m := i8080CPM.NewDiskMachine(os.Stdin, os.Stdout)
+	defer m.Close()
+	for i, image := range flag.Args() {
+		if err := m.Mount(i, image); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if err := m.Boot(uint16(addr)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	restore := noEcho()
+	defer restore()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		restore()
+		os.Exit(1)
+	}()
+	m.Run()
+}
+
+// noEcho turns off the terminal's echo, since CP/M echoes its input itself,
+// and returns a function that turns it back on. Without stty, as on
+// Windows, the input is echoed twice.
+func noEcho() func() {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return func() {}
+	}
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-echo"); err != nil {
+		return func() {}
+	}
+	return func() {
+		stty(strings.TrimSpace(string(saved)))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/is386/Go8080/i8080/asm"
+)
+
+func main() {
+	out := flag.String("o", "", "output file (default: source name with .bin or .hex)")
+	hex := flag.Bool("hex", false, "write Intel HEX instead of a binary image")
+	listing := flag.String("l", "", "write a listing to this file")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: i8080asm [-hex] [-o file] [-l listing] source.asm")
+		os.Exit(2)
+	}
+
+	src := flag.Arg(0)
+	prog, err := asm.AssembleFile(src)
+	if err != nil {
+		if list, ok := err.(asm.ErrorList); ok {
+			for _, e := range list {
+				fmt.Fprintln(os.Stderr, e)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		ext := ".bin"
+		if *hex {
+			ext = ".hex"
+		}
+		*out = strings.TrimSuffix(src, filepath.Ext(src)) + ext
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *hex {
+		err = prog.WriteHex(f)
+	} else {
+		_, image := prog.Binary()
+		_, err = f.Write(image)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *listing != "" {
+		f, err := os.Create(*listing)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		if err := prog.WriteListing(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
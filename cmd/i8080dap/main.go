@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/is386/Go8080/i8080/dap"
+	"github.com/is386/Go8080/i8080/debugger"
+	"github.com/is386/Go8080/i8080Test"
+)
+
+func main() {
+	listen := flag.String("listen", "", "serve on a TCP address such as localhost:4711 instead of stdio")
+	attach := flag.String("attach", "", "test ROM to run for attach requests (needs -listen)")
+	flag.Parse()
+
+	var d *debugger.Debugger
+	if *attach != "" {
+		if *listen == "" {
+			fmt.Fprintln(os.Stderr, "-attach needs -listen, the ROM writes its output to stdout")
+			os.Exit(2)
+		}
+		d = i8080Test.NewTestMachine(*attach, false).Debugger()
+	}
+
+	var err error
+	if *listen != "" {
+		err = dap.ListenAndServe(*listen, d)
+	} else {
+		err = dap.NewSession(os.Stdin, os.Stdout, d).Run()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/is386/Go8080/i8080/gdbstub"
+	"github.com/is386/Go8080/i8080Test"
+)
+
+func main() {
+	gdb := flag.String("gdb", "", "wait for GDB on a TCP address such as localhost:1234")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: i8080dbg [-gdb addr] rom")
+		os.Exit(2)
+	}
+
+	d := i8080Test.NewTestMachine(flag.Arg(0), false).Debugger()
+	if *gdb != "" {
+		if err := gdbstub.New(d).ListenAndServe(*gdb); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			d.Pause()
+		}
+	}()
+	if err := d.REPL(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/disasm"
+)
+
+func main() {
+	org := flag.Uint("org", 0x100, "address the file is loaded at")
+	zilog := flag.Bool("zilog", false, "use Zilog mnemonics")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: i8080disasm [-org addr] [-zilog] file")
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(data) == 0 {
+		return
+	}
+	mem := i8080.NewFlatMemory(0, 64*1024)
+	mem.Load(uint16(*org), data)
+
+	for _, inst := range disasm.Range(mem, uint16(*org), uint16(int(*org)+len(data)-1)) {
+		raw := make([]string, len(inst.Bytes))
+		for i, b := range inst.Bytes {
+			raw[i] = fmt.Sprintf("%02X", b)
+		}
+		text := inst.Intel()
+		if *zilog {
+			text = inst.Zilog()
+		}
+		fmt.Printf("%04X  %-9s %s\n", inst.Addr, strings.Join(raw, " "), text)
+	}
+}
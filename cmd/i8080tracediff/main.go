@@ -0,0 +1,48 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/is386/Go8080/i8080/trace"
+	"github.com/is386/Go8080/i8080Test"
+)
+
+func main() {
+	context := flag.Int("context", 5, "instructions to show before and after the divergence")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: i8080tracediff [-context n] rom reference")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	var ref io.Reader = f
+	if strings.HasSuffix(flag.Arg(1), ".gz") {
+		if ref, err = gzip.NewReader(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// The ROM's output goes to stderr so that it is not mixed into the report.
+	tm := i8080Test.NewTestMachine(flag.Arg(0), false)
+	tm.SetOutput(os.Stderr)
+	diverged, err := trace.NewDiffer(tm.GetCPU(), tm.Step, *context).Run(ref, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if diverged {
+		os.Exit(1)
+	}
+}
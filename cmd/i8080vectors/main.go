@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/is386/Go8080/i8080Test"
+)
+
+func main() {
+	n := flag.Int("n", 10, "vectors per opcode")
+	seed := flag.Int64("seed", 8080, "random seed")
+	out := flag.String("o", "i8080Test/vectors", "output directory")
+	only := flag.String("opcode", "", "only generate this opcode, in hex")
+	flag.Parse()
+
+	// Every opcode is generated so that one can be regenerated with the same
+	// random numbers as a full run.
+	rng := rand.New(rand.NewSource(*seed))
+	for opcode := 0; opcode < 256; opcode++ {
+		vectors := i8080Test.GenerateVectors(uint8(opcode), *n, rng)
+		if *only != "" {
+			if op, err := strconv.ParseUint(*only, 16, 8); err != nil {
+				fmt.Fprintln(os.Stderr, "invalid opcode", *only)
+				os.Exit(2)
+			} else if int(op) != opcode {
+				continue
+			}
+		}
+		name := filepath.Join(*out, fmt.Sprintf("%02x.json", opcode))
+		if err := i8080Test.SaveVectors(name, vectors); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
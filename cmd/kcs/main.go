@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+func main() {
+	rate := flag.Int("rate", devices.KCS_RATE, "sample rate of encoded WAV files")
+	flag.Parse()
+	if flag.NArg() != 3 || (flag.Arg(0) != "encode" && flag.Arg(0) != "decode") {
+		fmt.Fprintln(os.Stderr, "usage: kcs [-rate n] encode file.bin tape.wav")
+		fmt.Fprintln(os.Stderr, "       kcs decode tape.wav file.bin")
+		os.Exit(2)
+	}
+
+	in, err := os.Open(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer in.Close()
+	out, err := os.Create(flag.Arg(2))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if flag.Arg(0) == "encode" {
+		var data []uint8
+		if data, err = ioutil.ReadAll(in); err == nil {
+			err = devices.EncodeKCS(out, data, *rate)
+		}
+	} else {
+		var data []uint8
+		if data, err = devices.DecodeKCS(in); err == nil {
+			_, err = out.Write(data)
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
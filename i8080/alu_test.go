@@ -0,0 +1,319 @@
+package i8080
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var (
+	SEED = flag.Int64("seed", 8080, "random seed of the property tests")
+	RUNS = flag.Int("runs", 20000, "random inputs per property")
+)
+
+// The reference model below follows the 8080 datasheet one step at a time,
+// and shares no code with the CPU.
+
+const CODE = 0x1200
+
+func model(a uint8, cy bool, ac bool) uint8 {
+	f := uint8(1 << 1)
+	if a&0x80 != 0 {
+		f |= 1 << 7
+	}
+	if a == 0 {
+		f |= 1 << 6
+	}
+	if ac {
+		f |= 1 << 4
+	}
+	ones := 0
+	for i := 0; i < 8; i++ {
+		if a&(1<<i) != 0 {
+			ones++
+		}
+	}
+	if ones%2 == 0 {
+		f |= 1 << 2
+	}
+	if cy {
+		f |= 1 << 0
+	}
+	return f
+}
+
+// modelALU returns the result that sets the flags, which CMP does not keep
+// in A.
+func modelALU(op uint8, a uint8, v uint8, cy bool) (uint8, bool, bool) {
+	c := 0
+	if cy && (op == 1 || op == 3) {
+		c = 1
+	}
+	switch op {
+	case 0, 1: // ADD, ADC
+		sum := int(a) + int(v) + c
+		return uint8(sum), sum > 0xFF, int(a&0xF)+int(v&0xF)+c > 0xF
+	case 2, 3, 7: // SUB, SBB, CMP add the complement with the borrow inverted
+		diff := int(a) - int(v) - c
+		ac := int(a&0xF)+int(^v&0xF)+(1-c) > 0xF
+		return uint8(diff), diff < 0, ac
+	case 4: // ANA
+		return a & v, false, (a|v)&0x08 != 0
+	case 5: // XRA
+		return a ^ v, false, false
+	default: // ORA
+		return a | v, false, false
+	}
+}
+
+// kept is A after the operation op of a group of eight.
+func kept(op uint8, a uint8, res uint8) uint8 {
+	if op&7 == 7 {
+		return a
+	}
+	return res
+}
+
+func modelDAA(a uint8, cy bool, ac bool) (uint8, bool, bool) {
+	res := int(a)
+	newAC := false
+	if a&0xF > 9 || ac {
+		newAC = int(a&0xF)+6 > 0xF
+		res += 6
+	}
+	if (res>>4)&0x1F > 9 || cy {
+		res += 0x60
+		cy = true
+	}
+	return uint8(res), cy, newAC
+}
+
+// setup puts A and the flags in AF, x in every other register and at M, and
+// the instruction at CODE.
+func setup(a uint8, f uint8, x uint8, program ...uint8) *CPU {
+	c := NewCPU(CODE, nil, nil)
+	c.Load(CODE, program)
+	c.SetAF(uint16(a)<<8 | uint16(f))
+	xx := uint16(x)<<8 | uint16(x)
+	c.SetBC(xx)
+	c.SetDE(xx)
+	c.SetHL(xx)
+	c.Write(xx, x)
+	return c
+}
+
+func register(c *CPU, r uint8) uint8 {
+	reg := c.GetRegisters()
+	return [8]uint8{reg.B, reg.C, reg.D, reg.E, reg.H, reg.L, c.Read(c.GetHL()), reg.A}[r]
+}
+
+func flagsOf(c *CPU) uint8 {
+	return uint8(c.GetAF())
+}
+
+func cyOf(f uint8) bool {
+	return f&0x01 != 0
+}
+
+func acOf(f uint8) bool {
+	return f&0x10 != 0
+}
+
+// property is checked with random inputs. It returns the actual and expected
+// results, which must be equal.
+type property func(in []uint8) (string, string)
+
+// check runs p on random inputs and reports the first failure after
+// shrinking it to a minimal input.
+func check(t *testing.T, name string, inputs int, p property) {
+	rng := rand.New(rand.NewSource(*SEED))
+	in := make([]uint8, inputs)
+	for i := 0; i < *RUNS; i++ {
+		for j := range in {
+			in[j] = uint8(rng.Intn(256))
+		}
+		if got, want := p(in); got != want {
+			in = shrink(in, p)
+			got, want = p(in)
+			t.Errorf("[%s] minimal failing input % X: expected: %s, actual: %s", name, in, want, got)
+			return
+		}
+	}
+}
+
+// shrink lowers the inputs one at a time while p still fails, trying 0,
+// halving and clearing single bits, until nothing smaller fails.
+func shrink(in []uint8, p property) []uint8 {
+	in = append([]uint8(nil), in...)
+	fails := func() bool {
+		got, want := p(in)
+		return got != want
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, v := range in {
+			candidates := []uint8{0, v / 2, v - 1}
+			for bit := uint(0); bit < 8; bit++ {
+				candidates = append(candidates, v&^(1<<bit))
+			}
+			for _, smaller := range candidates {
+				if smaller >= v {
+					continue
+				}
+				in[i] = smaller
+				if fails() {
+					v, changed = smaller, true
+				} else {
+					in[i] = v
+				}
+			}
+		}
+	}
+	return in
+}
+
+func state(a uint8, f uint8) string {
+	return fmt.Sprintf("A=%02X F=%02X", a, f)
+}
+
+func TestALURegister(t *testing.T) {
+	// opcode 80-BF, A, F, x
+	check(t, "ADD..CMP r", 4, func(in []uint8) (string, string) {
+		opcode := 0x80 | in[0]&0x3F
+		c := setup(in[1], in[2], in[3], opcode)
+		v, cyIn := register(c, opcode&7), cyOf(flagsOf(c))
+		c.Execute()
+		res, cy, ac := modelALU((opcode>>3)&7, in[1], v, cyIn)
+		return state(uint8(c.GetAF()>>8), flagsOf(c)), state(kept(opcode>>3, in[1], res), model(res, cy, ac))
+	})
+}
+
+func TestALUImmediate(t *testing.T) {
+	// op, A, F, immediate
+	check(t, "ADI..CPI", 4, func(in []uint8) (string, string) {
+		op := in[0] & 7
+		c := setup(in[1], in[2], 0, 0xC6|op<<3, in[3])
+		cyIn := cyOf(flagsOf(c))
+		c.Execute()
+		res, cy, ac := modelALU(op, in[1], in[3], cyIn)
+		return state(uint8(c.GetAF()>>8), flagsOf(c)), state(kept(op, in[1], res), model(res, cy, ac))
+	})
+}
+
+func TestINRDCR(t *testing.T) {
+	// r and INR or DCR, A, F, x
+	check(t, "INR/DCR r", 4, func(in []uint8) (string, string) {
+		r, dcr := (in[0]>>1)&7, in[0]&1
+		c := setup(in[1], in[2], in[3], 0x04|r<<3|dcr)
+		v, cyIn := register(c, r), cyOf(flagsOf(c))
+		c.Execute()
+		var res uint8
+		var ac bool
+		if dcr == 0 {
+			res, ac = v+1, int(v&0xF)+1 > 0xF
+		} else {
+			// DCR adds FFH, whose low nibble F carries unless v's is 0.
+			res, ac = v-1, int(v&0xF)+0xF > 0xF
+		}
+		return fmt.Sprintf("%02X %02X", register(c, r), flagsOf(c)), fmt.Sprintf("%02X %02X", res, model(res, cyIn, ac))
+	})
+}
+
+func TestDAD(t *testing.T) {
+	// rp, F, H, L, rp high, rp low
+	check(t, "DAD rp", 6, func(in []uint8) (string, string) {
+		rp := in[0] & 3
+		c := setup(0, in[1], 0, 0x09|rp<<4)
+		hl := uint16(in[2])<<8 | uint16(in[3])
+		v := uint16(in[4])<<8 | uint16(in[5])
+		c.SetHL(hl)
+		switch rp {
+		case 0:
+			c.SetBC(v)
+		case 1:
+			c.SetDE(v)
+		case 2:
+			v = hl
+		case 3:
+			c.SetSP(v)
+		}
+		f := flagsOf(c)
+		c.Execute()
+		sum := uint32(hl) + uint32(v)
+		want := f &^ 0x01
+		if sum > 0xFFFF {
+			want |= 0x01
+		}
+		return fmt.Sprintf("%04X %02X", c.GetHL(), flagsOf(c)), fmt.Sprintf("%04X %02X", uint16(sum), want)
+	})
+}
+
+func TestDAA(t *testing.T) {
+	// A, F
+	check(t, "DAA", 2, func(in []uint8) (string, string) {
+		c := setup(in[0], in[1], 0, 0x27)
+		f := flagsOf(c)
+		c.Execute()
+		a, cy, ac := modelDAA(in[0], cyOf(f), acOf(f))
+		return state(uint8(c.GetAF()>>8), flagsOf(c)), state(a, model(a, cy, ac))
+	})
+}
+
+func TestRotates(t *testing.T) {
+	// RLC, RRC, RAL, RAR, CMA, STC, CMC, A, F
+	check(t, "RLC..CMC", 3, func(in []uint8) (string, string) {
+		op := in[0] % 7
+		c := setup(in[1], in[2], 0, []uint8{0x07, 0x0F, 0x17, 0x1F, 0x2F, 0x37, 0x3F}[op])
+		f := flagsOf(c)
+		c.Execute()
+		a, cy := in[1], cyOf(f)
+		switch op {
+		case 0:
+			cy = a&0x80 != 0
+			a = a<<1 | a>>7
+		case 1:
+			cy = a&0x01 != 0
+			a = a>>1 | a<<7
+		case 2:
+			old := cy
+			cy = a&0x80 != 0
+			a <<= 1
+			if old {
+				a |= 0x01
+			}
+		case 3:
+			old := cy
+			cy = a&0x01 != 0
+			a >>= 1
+			if old {
+				a |= 0x80
+			}
+		case 4:
+			a = ^a
+		case 5:
+			cy = true
+		case 6:
+			cy = !cy
+		}
+		want := f &^ 0x01
+		if cy {
+			want |= 0x01
+		}
+		return state(uint8(c.GetAF()>>8), flagsOf(c)), state(a, want)
+	})
+}
+
+func TestShrink(t *testing.T) {
+	// Fails whenever the first input is at least 10 and the second is odd.
+	p := func(in []uint8) (string, string) {
+		if in[0] >= 10 && in[1]%2 == 1 {
+			return "fail", "pass"
+		}
+		return "pass", "pass"
+	}
+	in := shrink([]uint8{0xC8, 0xFF}, p)
+	if in[0] != 10 || in[1] != 1 {
+		t.Errorf("[shrink] expected: 0A 01, actual: % X", in)
+	}
+}
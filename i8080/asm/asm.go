@@ -0,0 +1,546 @@
+// Package asm is a two-pass assembler for Intel 8080 source in the Intel and
+// Digital Research ASM syntax.
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	symLabel = iota
+	symEqu
+	symSet
+)
+
+var (
+	MAX_INCLUDE_DEPTH   = 16
+	MAX_EXPANSION_DEPTH = 64
+	MAX_PASSES          = 8
+
+	DIRECTIVES = map[string]bool{
+		"ORG": true, "EQU": true, "SET": true, "DB": true, "DW": true, "DS": true,
+		"END": true, "INCLUDE": true, "MACLIB": true, "TITLE": true, "PAGE": true, "EJECT": true,
+		"MACRO": true, "ENDM": true, "EXITM": true, "LOCAL": true, "REPT": true, "IRP": true, "IRPC": true,
+		"IF": true, "ELSE": true, "ENDIF": true,
+	}
+)
+
+type symbol struct {
+	value int
+	kind  int
+	gen   int
+}
+
+type source struct {
+	file      string
+	lines     []string
+	pos       int
+	line      int
+	expansion bool
+	conds     int
+}
+
+type Error struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+type Assembler struct {
+	ReadFile func(filename string) ([]uint8, error)
+
+	symbols   map[string]*symbol
+	macros    map[string]*macro
+	rec       *recording
+	conds     []cond
+	locals    int
+	pass      int
+	gen       int
+	changed   bool
+	pc        uint16
+	stack     []*source
+	file      string
+	line      int
+	cur       *Line
+	undefined bool
+	ended     bool
+	entry     int
+	errors    ErrorList
+	prog      *Program
+}
+
+func New() *Assembler {
+	return &Assembler{ReadFile: ioutil.ReadFile}
+}
+
+func AssembleFile(filename string) (*Program, error) {
+	return New().AssembleFile(filename)
+}
+
+func Assemble(name string, src []uint8) (*Program, error) {
+	return New().Assemble(name, src)
+}
+
+func (a *Assembler) AssembleFile(filename string) (*Program, error) {
+	src, err := a.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return a.Assemble(filename, src)
+}
+
+func (a *Assembler) Assemble(name string, src []uint8) (*Program, error) {
+	a.symbols = map[string]*symbol{}
+	a.errors = nil
+	a.pass = 1
+	// The first pass repeats until every symbol has settled, so that EQUs
+	// built from forward references are known before code is emitted.
+	for i := 0; i < MAX_PASSES; i++ {
+		a.changed = false
+		a.run(name, src)
+		if len(a.errors) > 0 {
+			return nil, a.errors
+		}
+		if !a.changed {
+			break
+		}
+	}
+	a.pass = 2
+	a.run(name, src)
+	if len(a.errors) > 0 {
+		return nil, a.errors
+	}
+	for name, sym := range a.symbols {
+		if _, reserved := REGISTERS[name]; !reserved {
+			a.prog.Symbols[name] = uint16(sym.value)
+		}
+	}
+	if a.entry >= 0 {
+		a.prog.Entry = uint16(a.entry)
+	} else if len(a.prog.Segments) > 0 {
+		a.prog.Entry = a.prog.Segments[0].Addr
+	}
+	return a.prog, nil
+}
+
+func (a *Assembler) run(name string, src []uint8) {
+	a.gen++
+	a.prog = &Program{Symbols: map[string]uint16{}}
+	a.pc = 0
+	a.entry = -1
+	a.ended = false
+	a.stack = nil
+	a.macros = map[string]*macro{}
+	a.rec = nil
+	a.conds = nil
+	a.locals = 0
+	for reg, val := range REGISTERS {
+		a.symbols[reg] = &symbol{value: val, kind: symEqu}
+	}
+	a.push(name, src)
+	for len(a.stack) > 0 && !a.ended {
+		src := a.stack[len(a.stack)-1]
+		if src.pos >= len(src.lines) {
+			a.stack = a.stack[:len(a.stack)-1]
+			if src.expansion && len(a.conds) > src.conds {
+				a.conds = a.conds[:src.conds]
+			}
+			continue
+		}
+		text := src.lines[src.pos]
+		src.pos++
+		a.file, a.line = src.file, src.pos
+		if src.expansion {
+			a.line = src.line
+		}
+		a.processLine(text, src.expansion)
+	}
+	if a.rec != nil {
+		a.errorf(true, "%s without ENDM", a.rec.kind)
+	}
+	if len(a.conds) > 0 {
+		a.errorf(true, "IF without ENDIF")
+	}
+}
+
+func (a *Assembler) push(name string, src []uint8) {
+	text := strings.Replace(string(src), "\r\n", "\n", -1)
+	// CP/M text files end at the first ^Z
+	if i := strings.IndexByte(text, '\x1a'); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimSuffix(text, "\n")
+	a.stack = append(a.stack, &source{file: name, lines: strings.Split(text, "\n")})
+}
+
+// errorf records an error. Most errors are only reported in the second
+// pass, once every symbol is known, unless early is set.
+func (a *Assembler) errorf(early bool, format string, args ...interface{}) {
+	if a.pass == 2 || early {
+		a.errors = append(a.errors, &Error{File: a.file, Line: a.line, Msg: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (a *Assembler) processLine(text string, expansion bool) {
+	a.cur = &Line{File: a.file, Line: a.line, Addr: a.pc, Text: text, Macro: expansion}
+	if a.rec != nil {
+		a.record(text)
+	} else {
+		for _, stmt := range splitStatements(stripComment(text)) {
+			if err := a.statement(stmt); err != nil {
+				a.errorf(false, "%v", err)
+			}
+			if a.rec != nil {
+				break
+			}
+		}
+	}
+	if a.pass == 2 {
+		a.prog.Listing = append(a.prog.Listing, *a.cur)
+	}
+}
+
+func stripComment(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "*") {
+		return ""
+	}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"':
+			if _, n, err := scanString(text[i:]); err == nil {
+				i += n - 1
+			}
+		case ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// splitStatements splits a line on the Digital Research '!' separator.
+func splitStatements(text string) []string {
+	var stmts []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"':
+			if _, n, err := scanString(text[i:]); err == nil {
+				i += n - 1
+			}
+		case '!':
+			stmts = append(stmts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(stmts, text[start:])
+}
+
+func word(text string) (string, string) {
+	text = strings.TrimLeft(text, " \t")
+	if text == "" || !isIdentStart(text[0]) {
+		return "", text
+	}
+	i := 1
+	for i < len(text) && isIdentChar(text[i]) {
+		i++
+	}
+	return text[:i], text[i:]
+}
+
+func (a *Assembler) isKeyword(name string) bool {
+	_, op := OPCODES[name]
+	_, mac := a.macros[name]
+	return op || mac || DIRECTIVES[name]
+}
+
+// fields splits a statement into its label, operation and operand field.
+// A label either ends in a colon or is any leading name that is not an
+// operation.
+func (a *Assembler) fields(stmt string) (string, string, string, error) {
+	first, rest := word(stmt)
+	if first == "" {
+		if strings.TrimSpace(rest) != "" {
+			return "", "", "", fmt.Errorf("syntax error")
+		}
+		return "", "", "", nil
+	}
+	label, op := "", strings.ToUpper(first)
+	second, _ := word(rest)
+	if strings.HasPrefix(rest, ":") {
+		label, rest = op, rest[1:]
+		first, rest = word(rest)
+		op = strings.ToUpper(first)
+	} else if !a.isKeyword(op) || strings.ToUpper(second) == "MACRO" {
+		label = op
+		first, rest = word(rest)
+		op = strings.ToUpper(first)
+	}
+	label = strings.Replace(label, "$", "", -1)
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", "", "", fmt.Errorf("syntax error")
+	}
+	return label, op, strings.TrimSpace(rest), nil
+}
+
+func (a *Assembler) statement(stmt string) error {
+	label, op, operand, err := a.fields(stmt)
+	if err != nil {
+		if !a.active() {
+			return nil
+		}
+		return err
+	}
+
+	switch op {
+	case "IF", "ELSE", "ENDIF":
+		return a.conditional(op, operand)
+	}
+	if !a.active() {
+		return nil
+	}
+
+	switch op {
+	case "MACRO":
+		return a.startBlock(op, label, operand)
+	case "EQU", "SET":
+		if label == "" {
+			return fmt.Errorf("%s needs a label", op)
+		}
+		val, err := a.eval(operand)
+		kind := symEqu
+		if op == "SET" {
+			kind = symSet
+		}
+		a.define(label, val, kind)
+		a.cur.Value, a.cur.HasValue = uint16(val), true
+		return err
+	case "ORG":
+		val, err := a.evalEarly(operand)
+		if err != nil {
+			return err
+		}
+		a.pc = uint16(val)
+		a.cur.Addr = a.pc
+		if label != "" {
+			a.define(label, val, symLabel)
+		}
+		return nil
+	}
+
+	if label != "" {
+		a.define(label, int(a.pc), symLabel)
+	}
+
+	switch op {
+	case "":
+		return nil
+	case "DB":
+		return a.db(operand)
+	case "DW":
+		return a.dw(operand)
+	case "DS":
+		val, err := a.evalEarly(operand)
+		if err != nil {
+			return err
+		}
+		a.cur.Value, a.cur.HasValue = a.pc, true
+		a.pc += uint16(val)
+		return nil
+	case "END":
+		a.ended = true
+		if operand != "" {
+			val, err := a.eval(operand)
+			a.entry = val & 0xFFFF
+			return err
+		}
+		return nil
+	case "INCLUDE":
+		return a.include(operand)
+	case "MACLIB":
+		return a.include(strings.TrimSpace(operand) + ".LIB")
+	case "REPT", "IRP", "IRPC":
+		return a.startBlock(op, "", operand)
+	case "EXITM":
+		return a.exitm()
+	case "ENDM":
+		return fmt.Errorf("ENDM without MACRO")
+	case "LOCAL":
+		return fmt.Errorf("LOCAL outside of a macro")
+	case "TITLE", "PAGE", "EJECT":
+		return nil
+	}
+
+	if m, ok := a.macros[op]; ok {
+		return a.invoke(m, operand)
+	}
+	if opc, ok := OPCODES[op]; ok {
+		return a.instruction(op, opc, splitOperands(operand))
+	}
+	return fmt.Errorf("unknown operation %s", op)
+}
+
+func (a *Assembler) include(operand string) error {
+	name := strings.TrimSpace(operand)
+	if name != "" && (name[0] == '\'' || name[0] == '"') {
+		str, _, err := scanString(name)
+		if err != nil {
+			return err
+		}
+		name = str
+	}
+	if name == "" {
+		return fmt.Errorf("INCLUDE needs a file name")
+	}
+	if len(a.stack) >= MAX_INCLUDE_DEPTH {
+		a.errorf(true, "includes nested too deeply")
+		return nil
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(a.file), name)
+	}
+	src, err := a.ReadFile(name)
+	if err != nil {
+		a.errorf(true, "%v", err)
+		return nil
+	}
+	a.push(name, src)
+	return nil
+}
+
+func (a *Assembler) db(operand string) error {
+	var firstErr error
+	for _, op := range splitOperands(operand) {
+		if len(op) > 2 && (op[0] == '\'' || op[0] == '"') {
+			if str, n, err := scanString(op); err == nil && n == len(op) {
+				a.emit([]uint8(str)...)
+				continue
+			}
+		}
+		val, err := a.byteValue(op)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		a.emit(val)
+	}
+	return firstErr
+}
+
+func (a *Assembler) dw(operand string) error {
+	var firstErr error
+	for _, op := range splitOperands(operand) {
+		val, err := a.wordValue(op)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		a.emit(uint8(val), uint8(val>>8))
+	}
+	return firstErr
+}
+
+func (a *Assembler) define(name string, val int, kind int) {
+	val &= 0xFFFF
+	if _, reserved := REGISTERS[name]; reserved || a.isKeyword(name) {
+		a.errorf(true, "%s is a reserved word", name)
+		return
+	}
+	sym, ok := a.symbols[name]
+	if !ok {
+		a.symbols[name] = &symbol{value: val, kind: kind, gen: a.gen}
+		a.changed = true
+		return
+	}
+	if sym.gen == a.gen && (kind != symSet || sym.kind != symSet) {
+		a.errorf(true, "%s is already defined", name)
+		return
+	}
+	if sym.value != val {
+		if a.pass == 2 && kind == symLabel {
+			a.errorf(false, "phase error at %s", name)
+		}
+		a.changed = a.changed || kind != symSet
+	}
+	sym.value, sym.kind, sym.gen = val, kind, a.gen
+}
+
+func (a *Assembler) lookup(name string) (int, error) {
+	sym, ok := a.symbols[name]
+	if ok && (sym.gen == a.gen || sym.kind != symSet) {
+		return sym.value, nil
+	}
+	if a.pass == 1 {
+		a.undefined = true
+		return 0, nil
+	}
+	return 0, fmt.Errorf("undefined symbol %s", name)
+}
+
+func (a *Assembler) eval(expr string) (int, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(toks) == 0 {
+		return 0, fmt.Errorf("missing operand")
+	}
+	p := &parser{toks: toks, a: a}
+	val, err := p.expr()
+	if err == nil && p.pos < len(toks) {
+		err = fmt.Errorf("unexpected %s", toks[p.pos].text)
+	}
+	return val & 0xFFFF, err
+}
+
+// evalEarly evaluates an expression whose value must already be known in
+// the first pass, such as the operand of ORG or DS.
+func (a *Assembler) evalEarly(expr string) (int, error) {
+	a.undefined = false
+	val, err := a.eval(expr)
+	if a.undefined {
+		a.errorf(true, "%s contains a forward reference", expr)
+	}
+	return val, err
+}
+
+func (a *Assembler) byteValue(expr string) (uint8, error) {
+	val, err := a.eval(expr)
+	if err == nil && val > 0xFF && val < 0xFF00 {
+		err = fmt.Errorf("value %s does not fit in a byte", expr)
+	}
+	return uint8(val), err
+}
+
+func (a *Assembler) wordValue(expr string) (uint16, error) {
+	val, err := a.eval(expr)
+	return uint16(val), err
+}
+
+func (a *Assembler) emit(data ...uint8) {
+	if a.pass == 2 {
+		a.prog.emit(a.pc, data)
+		if len(a.cur.Bytes) == 0 {
+			a.cur.Addr = a.pc
+		}
+		a.cur.Bytes = append(a.cur.Bytes, data...)
+	}
+	a.pc += uint16(len(data))
+}
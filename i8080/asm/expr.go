@@ -0,0 +1,383 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tEOF = iota
+	tNum
+	tIdent
+	tStr
+	tOp
+)
+
+type token struct {
+	kind int
+	text string
+	val  int
+}
+
+func isIdentStart(ch byte) bool {
+	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || ch == '_' || ch == '?' || ch == '@' || ch == '.'
+}
+
+func isIdentChar(ch byte) bool {
+	return isIdentStart(ch) || (ch >= '0' && ch <= '9') || ch == '$'
+}
+
+func tokenize(text string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(text); {
+		ch := text[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(text) && isIdentChar(text[j]) {
+				j++
+			}
+			val, err := parseNumber(text[i:j])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tNum, text: text[i:j], val: val})
+			i = j
+		case isIdentStart(ch):
+			j := i
+			for j < len(text) && isIdentChar(text[j]) {
+				j++
+			}
+			name := strings.ToUpper(strings.Replace(text[i:j], "$", "", -1))
+			toks = append(toks, token{kind: tIdent, text: name})
+			i = j
+		case ch == '\'' || ch == '"':
+			str, n, err := scanString(text[i:])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: tStr, text: str})
+			i += n
+		case ch == '<' || ch == '>' || ch == '=':
+			j := i + 1
+			if j < len(text) && (text[j] == '=' || text[j] == '>' || text[j] == '<') {
+				j++
+			}
+			toks = append(toks, token{kind: tOp, text: text[i:j]})
+			i = j
+		case strings.IndexByte("+-*/(),$", ch) >= 0:
+			toks = append(toks, token{kind: tOp, text: string(ch)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", ch)
+		}
+	}
+	return toks, nil
+}
+
+// scanString reads a quoted string at the start of text, where a doubled
+// quote stands for the quote character itself.
+func scanString(text string) (string, int, error) {
+	quote := text[0]
+	var sb strings.Builder
+	for i := 1; i < len(text); i++ {
+		if text[i] == quote {
+			if i+1 < len(text) && text[i+1] == quote {
+				sb.WriteByte(quote)
+				i++
+				continue
+			}
+			return sb.String(), i + 1, nil
+		}
+		sb.WriteByte(text[i])
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func parseNumber(text string) (int, error) {
+	s := strings.ToUpper(strings.Replace(text, "$", "", -1))
+	base := 10
+	switch s[len(s)-1] {
+	case 'H':
+		base, s = 16, s[:len(s)-1]
+	case 'O', 'Q':
+		base, s = 8, s[:len(s)-1]
+	case 'B':
+		base, s = 2, s[:len(s)-1]
+	case 'D':
+		base, s = 10, s[:len(s)-1]
+	}
+	val, err := strconv.ParseUint(s, base, 32)
+	if err != nil || val > 0xFFFF {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return int(val), nil
+}
+
+// parser evaluates Intel/Digital Research style expressions. From lowest to
+// highest precedence the operators are OR XOR, AND, NOT, the relations
+// EQ NE LT LE GT GE, binary + -, * / MOD SHL SHR, and unary + - HIGH LOW.
+type parser struct {
+	toks []token
+	pos  int
+	a    *Assembler
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return token{kind: tEOF}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(names ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tOp && t.kind != tIdent {
+		return "", false
+	}
+	for _, name := range names {
+		if t.text == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expr() (int, error) {
+	left, err := p.and()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.isOp("OR", "XOR")
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return 0, err
+		}
+		if op == "OR" {
+			left |= right
+		} else {
+			left ^= right
+		}
+	}
+}
+
+func (p *parser) and() (int, error) {
+	left, err := p.not()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, ok := p.isOp("AND"); !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return 0, err
+		}
+		left &= right
+	}
+}
+
+func (p *parser) not() (int, error) {
+	if _, ok := p.isOp("NOT"); ok {
+		p.next()
+		val, err := p.not()
+		return ^val & 0xFFFF, err
+	}
+	return p.relation()
+}
+
+func (p *parser) relation() (int, error) {
+	left, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	op, ok := p.isOp("EQ", "NE", "LT", "LE", "GT", "GE", "=", "<>", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	p.next()
+	right, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	left, right = left&0xFFFF, right&0xFFFF
+	var res bool
+	switch op {
+	case "EQ", "=":
+		res = left == right
+	case "NE", "<>":
+		res = left != right
+	case "LT", "<":
+		res = left < right
+	case "LE", "<=":
+		res = left <= right
+	case "GT", ">":
+		res = left > right
+	case "GE", ">=":
+		res = left >= right
+	}
+	if res {
+		return 0xFFFF, nil
+	}
+	return 0, nil
+}
+
+func (p *parser) sum() (int, error) {
+	left, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.isOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+		if op == "+" {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *parser) product() (int, error) {
+	left, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.isOp("*", "/", "MOD", "SHL", "SHR")
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		left, right = left&0xFFFF, right&0xFFFF
+		switch op {
+		case "*":
+			left *= right
+		case "/", "MOD":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		case "SHL":
+			left <<= uint(right)
+		case "SHR":
+			left >>= uint(right)
+		}
+	}
+}
+
+func (p *parser) unary() (int, error) {
+	if op, ok := p.isOp("+", "-", "HIGH", "LOW"); ok {
+		p.next()
+		val, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -val, nil
+		case "HIGH":
+			return (val >> 8) & 0xFF, nil
+		case "LOW":
+			return val & 0xFF, nil
+		}
+		return val, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (int, error) {
+	t := p.next()
+	switch t.kind {
+	case tNum:
+		return t.val, nil
+	case tStr:
+		switch len(t.text) {
+		case 1:
+			return int(t.text[0]), nil
+		case 2:
+			return int(t.text[0])<<8 | int(t.text[1]), nil
+		}
+		return 0, fmt.Errorf("string '%s' used as a number", t.text)
+	case tIdent:
+		return p.a.lookup(t.text)
+	case tOp:
+		switch t.text {
+		case "$":
+			return int(p.a.pc), nil
+		case "(":
+			val, err := p.expr()
+			if err != nil {
+				return 0, err
+			}
+			if p.next().text != ")" {
+				return 0, fmt.Errorf("missing )")
+			}
+			return val, nil
+		}
+	case tEOF:
+		return 0, fmt.Errorf("missing operand")
+	}
+	return 0, fmt.Errorf("unexpected %s", t.text)
+}
+
+// splitOperands splits an operand field on commas that are not inside a
+// string or parentheses.
+func splitOperands(text string) []string {
+	var ops []string
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"':
+			if _, n, err := scanString(text[i:]); err == nil {
+				i += n - 1
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				ops = append(ops, strings.TrimSpace(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" || len(ops) > 0 {
+		ops = append(ops, rest)
+	}
+	return ops
+}
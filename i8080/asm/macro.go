@@ -0,0 +1,351 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Macros follow Digital Research MAC: parameters are replaced wherever they
+// appear as names, '&' joins a parameter to surrounding text and is the only
+// way to substitute inside a string, LOCAL names become unique ??nnnn labels
+// for each expansion, and ';;' comments are dropped from macro bodies.
+
+type macro struct {
+	params []string
+	body   []string
+}
+
+type recording struct {
+	kind   string
+	name   string
+	params []string
+	args   []string
+	count  int
+	body   []string
+	depth  int
+}
+
+type cond struct {
+	parent, taken, active, seenElse bool
+}
+
+var BLOCKS = map[string]bool{"MACRO": true, "REPT": true, "IRP": true, "IRPC": true}
+
+func (a *Assembler) active() bool {
+	return len(a.conds) == 0 || a.conds[len(a.conds)-1].active
+}
+
+func (a *Assembler) conditional(op string, operand string) error {
+	switch op {
+	case "IF":
+		c := cond{parent: a.active()}
+		if c.parent {
+			val, err := a.evalEarly(operand)
+			if err != nil {
+				a.conds = append(a.conds, c)
+				return err
+			}
+			c.taken = val != 0
+			c.active = c.taken
+		}
+		a.conds = append(a.conds, c)
+	case "ELSE":
+		if len(a.conds) == 0 {
+			return fmt.Errorf("ELSE without IF")
+		}
+		c := &a.conds[len(a.conds)-1]
+		if c.seenElse {
+			return fmt.Errorf("ELSE after ELSE")
+		}
+		c.seenElse = true
+		c.active = c.parent && !c.taken
+	case "ENDIF":
+		if len(a.conds) == 0 {
+			return fmt.Errorf("ENDIF without IF")
+		}
+		a.conds = a.conds[:len(a.conds)-1]
+	}
+	return nil
+}
+
+// opOf returns the operation of the first statement on a line, which is
+// all that is needed while a macro body is being recorded.
+func (a *Assembler) opOf(text string) (string, string) {
+	_, op, operand, err := a.fields(splitStatements(stripComment(text))[0])
+	if err != nil {
+		return "", ""
+	}
+	return op, operand
+}
+
+func (a *Assembler) startBlock(kind string, label string, operand string) error {
+	rec := &recording{kind: kind, name: label, depth: 1}
+	ops := splitArgs(operand)
+	switch kind {
+	case "MACRO":
+		if label == "" {
+			return fmt.Errorf("MACRO needs a name")
+		}
+		for _, p := range ops {
+			rec.params = append(rec.params, strings.ToUpper(p))
+		}
+	case "REPT":
+		val, err := a.evalEarly(operand)
+		if err != nil {
+			return err
+		}
+		rec.count = val
+	case "IRP", "IRPC":
+		if len(ops) == 0 || ops[0] == "" {
+			return fmt.Errorf("%s needs a parameter", kind)
+		}
+		rec.params = []string{strings.ToUpper(ops[0])}
+		text := strings.Join(ops[1:], ",")
+		if kind == "IRP" {
+			rec.args = splitArgs(text)
+		} else {
+			for i := 0; i < len(text); i++ {
+				rec.args = append(rec.args, text[i:i+1])
+			}
+		}
+	}
+	a.rec = rec
+	return nil
+}
+
+func (a *Assembler) record(text string) {
+	op, _ := a.opOf(text)
+	if BLOCKS[op] {
+		a.rec.depth++
+	} else if op == "ENDM" {
+		a.rec.depth--
+	}
+	if a.rec.depth > 0 {
+		if i := strings.Index(text, ";;"); i >= 0 && !strings.ContainsAny(text[:i], "'\"") {
+			text = strings.TrimRight(text[:i], " \t")
+		}
+		a.rec.body = append(a.rec.body, text)
+		return
+	}
+
+	rec := a.rec
+	a.rec = nil
+	var lines []string
+	switch rec.kind {
+	case "MACRO":
+		a.macros[rec.name] = &macro{params: rec.params, body: rec.body}
+		return
+	case "REPT":
+		for i := 0; i < rec.count; i++ {
+			lines = append(lines, a.expand(rec.body, nil, nil)...)
+		}
+	case "IRP", "IRPC":
+		for _, arg := range rec.args {
+			lines = append(lines, a.expand(rec.body, rec.params, []string{arg})...)
+		}
+	}
+	a.pushExpansion(lines)
+}
+
+func (a *Assembler) invoke(m *macro, operand string) error {
+	args := splitArgs(operand)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "%") {
+			val, err := a.eval(arg[1:])
+			if err != nil {
+				return err
+			}
+			args[i] = strconv.Itoa(val)
+		}
+	}
+	a.pushExpansion(a.expand(m.body, m.params, args))
+	return nil
+}
+
+func (a *Assembler) pushExpansion(lines []string) {
+	if len(a.stack) >= MAX_EXPANSION_DEPTH {
+		a.errorf(true, "macro expansion nested too deeply")
+		return
+	}
+	a.stack = append(a.stack, &source{file: a.file, lines: lines, line: a.line,
+		expansion: true, conds: len(a.conds)})
+}
+
+// exitm abandons the innermost macro expansion.
+func (a *Assembler) exitm() error {
+	for i := len(a.stack) - 1; i >= 0; i-- {
+		if a.stack[i].expansion {
+			a.stack[i].pos = len(a.stack[i].lines)
+			a.conds = a.conds[:a.stack[i].conds]
+			a.stack = a.stack[:i+1]
+			return nil
+		}
+	}
+	return fmt.Errorf("EXITM outside of a macro")
+}
+
+func (a *Assembler) expand(body []string, params []string, args []string) []string {
+	params = append([]string(nil), params...)
+	args = append([]string(nil), args...)
+	for len(args) < len(params) {
+		args = append(args, "")
+	}
+	var lines []string
+	depth := 0
+	for _, text := range body {
+		op, operand := a.opOf(text)
+		if op == "LOCAL" && depth == 0 {
+			for _, name := range splitArgs(operand) {
+				a.locals++
+				params = append(params, strings.ToUpper(name))
+				args = append(args, fmt.Sprintf("??%04d", a.locals))
+			}
+			continue
+		}
+		if BLOCKS[op] {
+			depth++
+		} else if op == "ENDM" {
+			depth--
+		}
+		lines = append(lines, substitute(text, params, args))
+	}
+	return lines
+}
+
+func lookupParam(name string, params []string, args []string) (string, bool) {
+	name = strings.ToUpper(name)
+	for i, p := range params {
+		if p == name {
+			return args[i], true
+		}
+	}
+	return "", false
+}
+
+func substitute(text string, params []string, args []string) string {
+	if len(params) == 0 {
+		return text
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		ch := text[i]
+		switch {
+		case ch == ';':
+			sb.WriteString(text[i:])
+			return sb.String()
+		case ch == '\'' || ch == '"':
+			j := i + 1
+			for j < len(text) {
+				if text[j] == ch {
+					if j+1 < len(text) && text[j+1] == ch {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			end := j
+			if end > len(text) {
+				end = len(text)
+			}
+			sb.WriteByte(ch)
+			sb.WriteString(substituteString(text[i+1:end], params, args))
+			if j < len(text) {
+				sb.WriteByte(ch)
+			}
+			i = j + 1
+		case isIdentStart(ch):
+			j := i + 1
+			for j < len(text) && isIdentChar(text[j]) {
+				j++
+			}
+			if arg, ok := lookupParam(text[i:j], params, args); ok {
+				sb.WriteString(arg)
+			} else {
+				sb.WriteString(text[i:j])
+			}
+			i = j
+		case ch == '&':
+			i++
+		default:
+			sb.WriteByte(ch)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// substituteString replaces parameters inside a string only where they are
+// joined to the text with '&'.
+func substituteString(text string, params []string, args []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		if !isIdentStart(text[i]) && !(text[i] == '&' && i+1 < len(text) && isIdentStart(text[i+1])) {
+			sb.WriteByte(text[i])
+			i++
+			continue
+		}
+		amp := text[i] == '&'
+		start := i
+		if amp {
+			start++
+		}
+		j := start + 1
+		for j < len(text) && isIdentChar(text[j]) {
+			j++
+		}
+		trailing := j < len(text) && text[j] == '&'
+		arg, ok := lookupParam(text[start:j], params, args)
+		switch {
+		case ok && (amp || trailing):
+			sb.WriteString(arg)
+			if trailing {
+				j++
+			}
+		default:
+			sb.WriteString(text[i:j])
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+// splitArgs splits macro arguments on commas, keeping <...> groups and
+// strings together and removing the outer angle brackets.
+func splitArgs(text string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'', '"':
+			if _, n, err := scanString(text[i:]); err == nil {
+				i += n - 1
+			}
+		case '<':
+			depth++
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, unbracket(text[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(text[start:]); rest != "" || len(args) > 0 {
+		args = append(args, unbracket(rest))
+	}
+	return args
+}
+
+func unbracket(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && arg[0] == '<' && arg[len(arg)-1] == '>' {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
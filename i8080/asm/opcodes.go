@@ -0,0 +1,163 @@
+package asm
+
+import (
+	"fmt"
+)
+
+const (
+	kNone = iota
+	kReg
+	kRegHi
+	kMov
+	kMvi
+	kPair
+	kPairBD
+	kLxi
+	kImm8
+	kImm16
+	kRst
+)
+
+type opcode struct {
+	base uint8
+	kind int
+}
+
+var (
+	OPCODES = map[string]opcode{
+		"NOP": {0x00, kNone}, "HLT": {0x76, kNone},
+		"RLC": {0x07, kNone}, "RRC": {0x0F, kNone}, "RAL": {0x17, kNone}, "RAR": {0x1F, kNone},
+		"DAA": {0x27, kNone}, "CMA": {0x2F, kNone}, "STC": {0x37, kNone}, "CMC": {0x3F, kNone},
+		"RET": {0xC9, kNone}, "XCHG": {0xEB, kNone}, "XTHL": {0xE3, kNone},
+		"SPHL": {0xF9, kNone}, "PCHL": {0xE9, kNone}, "EI": {0xFB, kNone}, "DI": {0xF3, kNone},
+		"RNZ": {0xC0, kNone}, "RZ": {0xC8, kNone}, "RNC": {0xD0, kNone}, "RC": {0xD8, kNone},
+		"RPO": {0xE0, kNone}, "RPE": {0xE8, kNone}, "RP": {0xF0, kNone}, "RM": {0xF8, kNone},
+
+		"INR": {0x04, kRegHi}, "DCR": {0x05, kRegHi},
+		"ADD": {0x80, kReg}, "ADC": {0x88, kReg}, "SUB": {0x90, kReg}, "SBB": {0x98, kReg},
+		"ANA": {0xA0, kReg}, "XRA": {0xA8, kReg}, "ORA": {0xB0, kReg}, "CMP": {0xB8, kReg},
+		"MOV": {0x40, kMov}, "MVI": {0x06, kMvi},
+
+		"LXI": {0x01, kLxi}, "DAD": {0x09, kPair}, "INX": {0x03, kPair}, "DCX": {0x0B, kPair},
+		"PUSH": {0xC5, kPair}, "POP": {0xC1, kPair},
+		"STAX": {0x02, kPairBD}, "LDAX": {0x0A, kPairBD},
+
+		"ADI": {0xC6, kImm8}, "ACI": {0xCE, kImm8}, "SUI": {0xD6, kImm8}, "SBI": {0xDE, kImm8},
+		"ANI": {0xE6, kImm8}, "XRI": {0xEE, kImm8}, "ORI": {0xF6, kImm8}, "CPI": {0xFE, kImm8},
+		"IN": {0xDB, kImm8}, "OUT": {0xD3, kImm8},
+
+		"LDA": {0x3A, kImm16}, "STA": {0x32, kImm16}, "LHLD": {0x2A, kImm16}, "SHLD": {0x22, kImm16},
+		"JMP": {0xC3, kImm16}, "CALL": {0xCD, kImm16},
+		"JNZ": {0xC2, kImm16}, "JZ": {0xCA, kImm16}, "JNC": {0xD2, kImm16}, "JC": {0xDA, kImm16},
+		"JPO": {0xE2, kImm16}, "JPE": {0xEA, kImm16}, "JP": {0xF2, kImm16}, "JM": {0xFA, kImm16},
+		"CNZ": {0xC4, kImm16}, "CZ": {0xCC, kImm16}, "CNC": {0xD4, kImm16}, "CC": {0xDC, kImm16},
+		"CPO": {0xE4, kImm16}, "CPE": {0xEC, kImm16}, "CP": {0xF4, kImm16}, "CM": {0xFC, kImm16},
+
+		"RST": {0xC7, kRst},
+	}
+
+	OPERANDS = [...]int{
+		kNone: 0, kReg: 1, kRegHi: 1, kMov: 2, kMvi: 2, kPair: 1,
+		kPairBD: 1, kLxi: 2, kImm8: 1, kImm16: 1, kRst: 1,
+	}
+
+	REGISTERS = map[string]int{
+		"B": 0, "C": 1, "D": 2, "E": 3, "H": 4, "L": 5, "M": 6, "A": 7, "SP": 6, "PSW": 6,
+	}
+)
+
+func (a *Assembler) reg(expr string) (uint8, error) {
+	val, err := a.eval(expr)
+	if err != nil {
+		return 0, err
+	}
+	if val < 0 || val > 7 {
+		return 0, fmt.Errorf("invalid register %s", expr)
+	}
+	return uint8(val), nil
+}
+
+func (a *Assembler) pair(expr string, allowed ...int) (uint8, error) {
+	val, err := a.eval(expr)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range allowed {
+		if val == p {
+			return uint8(val/2) << 4, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid register pair %s", expr)
+}
+
+func (a *Assembler) instruction(name string, op opcode, operands []string) error {
+	if len(operands) != OPERANDS[op.kind] {
+		return fmt.Errorf("%s expects %d operand(s)", name, OPERANDS[op.kind])
+	}
+	switch op.kind {
+	case kNone:
+		a.emit(op.base)
+	case kReg:
+		r, err := a.reg(operands[0])
+		a.emit(op.base | r)
+		return err
+	case kRegHi:
+		r, err := a.reg(operands[0])
+		a.emit(op.base | r<<3)
+		return err
+	case kMov:
+		d, err := a.reg(operands[0])
+		if err != nil {
+			a.emit(op.base)
+			return err
+		}
+		s, err := a.reg(operands[1])
+		if err == nil && d == 6 && s == 6 {
+			err = fmt.Errorf("MOV M,M is not an instruction")
+		}
+		a.emit(op.base | d<<3 | s)
+		return err
+	case kMvi:
+		r, err := a.reg(operands[0])
+		if err != nil {
+			a.emit(op.base, 0)
+			return err
+		}
+		val, err := a.byteValue(operands[1])
+		a.emit(op.base|r<<3, val)
+		return err
+	case kPair:
+		p, err := a.pair(operands[0], 0, 2, 4, 6)
+		a.emit(op.base | p)
+		return err
+	case kPairBD:
+		p, err := a.pair(operands[0], 0, 2)
+		a.emit(op.base | p)
+		return err
+	case kLxi:
+		p, err := a.pair(operands[0], 0, 2, 4, 6)
+		if err != nil {
+			a.emit(op.base, 0, 0)
+			return err
+		}
+		val, err := a.wordValue(operands[1])
+		a.emit(op.base|p, uint8(val), uint8(val>>8))
+		return err
+	case kImm8:
+		val, err := a.byteValue(operands[0])
+		a.emit(op.base, val)
+		return err
+	case kImm16:
+		val, err := a.wordValue(operands[0])
+		a.emit(op.base, uint8(val), uint8(val>>8))
+		return err
+	case kRst:
+		n, err := a.eval(operands[0])
+		if err == nil && (n < 0 || n > 7) {
+			err = fmt.Errorf("invalid restart %d", n)
+		}
+		a.emit(op.base | uint8(n&7)<<3)
+		return err
+	}
+	return nil
+}
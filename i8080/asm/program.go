@@ -0,0 +1,145 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+type Segment struct {
+	Addr uint16
+	Data []uint8
+}
+
+// Line is one source line of the listing, with the address and bytes it
+// assembled to. Value holds the result of EQU, SET and DS lines. Lines of a
+// macro expansion carry the line number of the invocation.
+type Line struct {
+	File     string
+	Line     int
+	Addr     uint16
+	Bytes    []uint8
+	Value    uint16
+	HasValue bool
+	Macro    bool
+	Text     string
+}
+
+type Program struct {
+	Segments []Segment
+	Symbols  map[string]uint16
+	Listing  []Line
+	Entry    uint16
+}
+
+func (p *Program) emit(addr uint16, data []uint8) {
+	if n := len(p.Segments); n > 0 {
+		last := &p.Segments[n-1]
+		if uint32(last.Addr)+uint32(len(last.Data)) == uint32(addr) {
+			last.Data = append(last.Data, data...)
+			return
+		}
+	}
+	p.Segments = append(p.Segments, Segment{Addr: addr, Data: append([]uint8(nil), data...)})
+}
+
+// Binary returns the image spanning every segment, starting at the lowest
+// assembled address, with gaps between segments filled with zeros.
+func (p *Program) Binary() (uint16, []uint8) {
+	if len(p.Segments) == 0 {
+		return 0, nil
+	}
+	lo, hi := uint32(0xFFFF), uint32(0)
+	for _, seg := range p.Segments {
+		if uint32(seg.Addr) < lo {
+			lo = uint32(seg.Addr)
+		}
+		if end := uint32(seg.Addr) + uint32(len(seg.Data)); end > hi {
+			hi = end
+		}
+	}
+	image := make([]uint8, hi-lo)
+	for _, seg := range p.Segments {
+		copy(image[uint32(seg.Addr)-lo:], seg.Data)
+	}
+	return uint16(lo), image
+}
+
+func (p *Program) LoadInto(c *i8080.CPU) {
+	for _, seg := range p.Segments {
+		c.Load(seg.Addr, seg.Data)
+	}
+}
+
+func (p *Program) WriteHex(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, seg := range p.Segments {
+		for i := 0; i < len(seg.Data); i += 16 {
+			end := i + 16
+			if end > len(seg.Data) {
+				end = len(seg.Data)
+			}
+			writeHexRecord(bw, seg.Addr+uint16(i), 0x00, seg.Data[i:end])
+		}
+	}
+	writeHexRecord(bw, p.Entry, 0x01, nil)
+	return bw.Flush()
+}
+
+func writeHexRecord(w io.Writer, addr uint16, kind uint8, data []uint8) {
+	sum := uint8(len(data)) + uint8(addr>>8) + uint8(addr) + kind
+	fmt.Fprintf(w, ":%02X%04X%02X", len(data), addr, kind)
+	for _, b := range data {
+		fmt.Fprintf(w, "%02X", b)
+		sum += b
+	}
+	fmt.Fprintf(w, "%02X\r\n", uint8(-sum))
+}
+
+func (p *Program) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range p.Listing {
+		addr := "    "
+		if len(line.Bytes) > 0 {
+			addr = fmt.Sprintf("%04X", line.Addr)
+		}
+		for i := 0; i == 0 || i < len(line.Bytes); i += 4 {
+			end := i + 4
+			if end > len(line.Bytes) {
+				end = len(line.Bytes)
+			}
+			raw := make([]string, 0, 4)
+			for _, b := range line.Bytes[i:end] {
+				raw = append(raw, fmt.Sprintf("%02X", b))
+			}
+			code := strings.Join(raw, " ")
+			if line.HasValue && i == 0 {
+				code = fmt.Sprintf("= %04X", line.Value)
+			}
+			mark := " "
+			if line.Macro {
+				mark = "+"
+			}
+			if i == 0 {
+				fmt.Fprintf(bw, "%s  %-11s %5d%s %s\n", addr, code, line.Line, mark, line.Text)
+			} else {
+				fmt.Fprintf(bw, "%04X  %s\n", line.Addr+uint16(i), code)
+			}
+		}
+	}
+
+	fmt.Fprintln(bw)
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(bw, "%04X  %s\n", p.Symbols[name], name)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,34 @@
+; Conditional assembly, nesting and SET counters.
+DEBUG	EQU	0
+SIZE	EQU	4
+
+	ORG	0
+	IF	DEBUG
+	DB	0DEH
+	ELSE
+	DB	0AAH
+	ENDIF
+
+	IF	SIZE EQ 4
+	 IF	NOT DEBUG
+	DB	1
+	 ELSE
+	DB	2
+	 ENDIF
+	ENDIF
+
+	IF	DEBUG
+	 IF	SIZE
+	DB	3
+	 ELSE
+	DB	4
+	 ENDIF
+	ENDIF
+
+COUNT	SET	0
+	REPT	SIZE
+COUNT	SET	COUNT+1
+	DB	COUNT
+	ENDM
+
+	END
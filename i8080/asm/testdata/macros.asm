@@ -0,0 +1,43 @@
+; Macro definitions, parameters, LOCAL labels, concatenation and EXITM.
+	ORG	100H
+
+SAVE	MACRO	R1,R2
+	PUSH	R1
+	PUSH	R2
+	ENDM
+
+WAIT	MACRO	N
+	LOCAL	LOOP
+	MVI	A,N
+LOOP:	DCR	A		;; dropped from the body
+	JNZ	LOOP
+	ENDM
+
+MSG	MACRO	NAME,TEXT
+NAME&MSG:	DB	'&TEXT',0
+	ENDM
+
+SMALL	MACRO	N
+	IF	N GT 255
+	EXITM
+	ENDIF
+	DB	N
+	ENDM
+
+START:	SAVE	B,D
+	WAIT	10
+	WAIT	%2*3
+	SMALL	7
+	SMALL	300
+	LXI	H,HELLOMSG
+	REPT	3
+	NOP
+	ENDM
+	IRP	R,<B,C,D>
+	INR	R
+	ENDM
+	IRPC	C,XY
+	DB	'&C'
+	ENDM
+	MSG	HELLO,<Hi, there>
+	END	START
@@ -0,0 +1,268 @@
+package i8080
+
+const MAX_BLOCK_LENGTH = 32
+
+var (
+	LENGTHS = [256]int{
+		1, 3, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+		1, 3, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
+		1, 3, 3, 1, 1, 1, 2, 1, 1, 1, 3, 1, 1, 1, 2, 1,
+		1, 3, 3, 1, 1, 1, 2, 1, 1, 1, 3, 1, 1, 1, 2, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 3, 3, 3, 1, 2, 1, 1, 1, 3, 3, 3, 3, 2, 1,
+		1, 1, 3, 2, 3, 1, 2, 1, 1, 1, 3, 2, 3, 3, 2, 1,
+		1, 1, 3, 1, 3, 1, 2, 1, 1, 1, 3, 1, 3, 3, 2, 1,
+		1, 1, 3, 1, 3, 1, 2, 1, 1, 1, 3, 1, 3, 3, 2, 1}
+
+	// BLOCK_ENDS are the opcodes that end a basic block: jumps, calls,
+	// returns, RST, PCHL, HLT, and IN, OUT and EI, after which the machine
+	// may want to step in.
+	BLOCK_ENDS = map[uint8]bool{
+		0xC2: true, 0xC3: true, 0xCA: true, 0xCB: true, 0xD2: true, 0xDA: true, 0xE2: true, 0xEA: true, 0xF2: true, 0xFA: true,
+		0xC4: true, 0xCC: true, 0xCD: true, 0xD4: true, 0xDC: true, 0xDD: true, 0xE4: true, 0xEC: true, 0xED: true, 0xF4: true, 0xFC: true, 0xFD: true,
+		0xC0: true, 0xC8: true, 0xC9: true, 0xD0: true, 0xD8: true, 0xD9: true, 0xE0: true, 0xE8: true, 0xF0: true, 0xF8: true,
+		0xC7: true, 0xCF: true, 0xD7: true, 0xDF: true, 0xE7: true, 0xEF: true, 0xF7: true, 0xFF: true,
+		0xE9: true, 0x76: true, 0xDB: true, 0xD3: true, 0xFB: true,
+	}
+)
+
+// op is a decoded instruction. Its operands are fed to the instruction
+// the way Interrupt feeds them, so it never reads them from memory.
+// CONDITIONS are the flag tests of NZ, Z, NC, C, PO, PE, P and M.
+var CONDITIONS = [8]struct {
+	flag Flags
+	set  bool
+}{{FLAG_Z, false}, {FLAG_Z, true}, {FLAG_CY, false}, {FLAG_CY, true},
+	{FLAG_P, false}, {FLAG_P, true}, {FLAG_S, false}, {FLAG_S, true}}
+
+type op struct {
+	addr     uint16
+	next     uint16
+	cycles   int
+	operands []uint8
+	fn       func(*CPU)
+}
+
+type block struct {
+	start  uint16
+	length uint16
+	ops    []op
+	stale  bool
+}
+
+func (b *block) covers(addr uint16) bool {
+	return addr-b.start < b.length
+}
+
+// BlockCache is an execution engine that decodes each basic block once and
+// then runs its cached instructions without fetching and decoding opcodes.
+// It behaves exactly like CPU.Execute, including cycles and the tracer.
+//
+// Blocks are invalidated when the CPU writes to them, at every address
+// that mirrors the byte written when the memory is an Aliaser such as a
+// Bus. Code must therefore only change through the memory's Write or Load,
+// and the memory must not be replaced with SetMemory afterwards.
+type BlockCache struct {
+	cpu     *CPU
+	blocks  [64 * 1024]*block
+	pages   [256][]*block
+	aliases Aliaser
+	mirrors []uint16
+	cur     *block
+	next    int
+	stop    bool
+}
+
+func NewBlockCache(c *CPU) *BlockCache {
+	bc := &BlockCache{cpu: c}
+	bc.aliases, _ = c.GetMemory().(Aliaser)
+	c.SetMemory(&blockMemory{c.GetMemory(), bc})
+	return bc
+}
+
+// Execute runs a single instruction, like CPU.Execute.
+func (bc *BlockCache) Execute() {
+	c := bc.cpu
+	if c.halted {
+		c.cyc += 4
+		return
+	}
+	b := bc.cur
+	if b == nil || b.stale || bc.next >= len(b.ops) || b.ops[bc.next].addr != c.pc {
+		b, bc.next = bc.lookup(c.pc), 0
+		bc.cur = b
+	}
+	bc.next++
+	bc.exec(&b.ops[bc.next-1])
+}
+
+// Run executes whole blocks until at least cycles cycles have passed, the
+// CPU halts or Stop is called. It stops after exactly the instruction that a
+// loop of Execute checking the same conditions would have stopped at.
+func (bc *BlockCache) Run(cycles int) {
+	c := bc.cpu
+	target := c.cyc + cycles
+	bc.cur, bc.stop = nil, false
+	for c.cyc < target && !c.halted && !bc.stop {
+		b := bc.lookup(c.pc)
+		if c.tracer != nil {
+			for i := range b.ops {
+				bc.exec(&b.ops[i])
+				if b.stale || c.cyc >= target || bc.stop {
+					break
+				}
+			}
+			continue
+		}
+		// Only EI sets intDelay, and it ends the block.
+		c.intDelay = false
+		for i := range b.ops {
+			o := &b.ops[i]
+			c.pc, c.inject = o.next, o.operands
+			c.cyc += o.cycles
+			o.fn(c)
+			if b.stale || c.cyc >= target || bc.stop {
+				break
+			}
+		}
+	}
+}
+
+// Stop makes Run return after the current instruction. It is meant to be
+// called from the machine's I/O handlers.
+func (bc *BlockCache) Stop() {
+	bc.stop = true
+}
+
+func (bc *BlockCache) exec(o *op) {
+	c := bc.cpu
+	c.intDelay = false
+	if c.tracer != nil {
+		c.tracer(c)
+	}
+	c.pc, c.inject = o.next, o.operands
+	c.cyc += o.cycles
+	o.fn(c)
+}
+
+func (bc *BlockCache) lookup(addr uint16) *block {
+	if b := bc.blocks[addr]; b != nil {
+		return b
+	}
+	b := &block{start: addr}
+	mem := bc.cpu.mem
+	for len(b.ops) < MAX_BLOCK_LENGTH {
+		opcode := mem.Read(addr)
+		o := op{addr: addr, next: addr + uint16(LENGTHS[opcode]), cycles: CYCLES[opcode], fn: INSTRUCTIONS[opcode]}
+		for a := addr + 1; a != o.next; a++ {
+			o.operands = append(o.operands, mem.Read(a))
+		}
+		if fn := specialize(opcode, o.operands); fn != nil {
+			o.fn, o.operands = fn, nil
+		}
+		b.ops = append(b.ops, o)
+		addr = o.next
+		if BLOCK_ENDS[opcode] {
+			break
+		}
+	}
+	b.length = addr - b.start
+	bc.blocks[b.start] = b
+	for p := uint32(b.start) >> 8; p <= (uint32(b.start)+uint32(b.length)-1)>>8; p++ {
+		bc.pages[p&0xFF] = append(bc.pages[p&0xFF], b)
+	}
+	return b
+}
+
+// specialize returns an instruction with its operands built in, for the
+// jumps that dominate tight loops, or nil.
+func specialize(opcode uint8, operands []uint8) func(*CPU) {
+	if opcode != 0xC3 && opcode != 0xCB && opcode&0xC7 != 0xC2 {
+		return nil
+	}
+	target := uint16(operands[1])<<8 | uint16(operands[0])
+	if opcode == 0xC3 || opcode == 0xCB {
+		return func(c *CPU) {
+			c.pc = target
+		}
+	}
+	cond := CONDITIONS[(opcode>>3)&7]
+	return func(c *CPU) {
+		if (c.flags&cond.flag != 0) == cond.set {
+			c.pc = target
+		}
+	}
+}
+
+// written invalidates the blocks that read the byte at addr through any of
+// its mirrors.
+func (bc *BlockCache) written(addr uint16) {
+	if bc.aliases != nil {
+		bc.mirrors = bc.aliases.Aliases(addr, bc.mirrors[:0])
+		if len(bc.mirrors) > 0 {
+			for _, a := range bc.mirrors {
+				if len(bc.pages[a>>8]) > 0 {
+					bc.invalidate(a)
+				}
+			}
+			return
+		}
+	}
+	if len(bc.pages[addr>>8]) > 0 {
+		bc.invalidate(addr)
+	}
+}
+
+// invalidate drops the blocks containing addr.
+func (bc *BlockCache) invalidate(addr uint16) {
+	var stale []*block
+	for _, b := range bc.pages[addr>>8] {
+		if b.covers(addr) {
+			stale = append(stale, b)
+		}
+	}
+	for _, b := range stale {
+		b.stale = true
+		bc.blocks[b.start] = nil
+		for p := uint32(b.start) >> 8; p <= (uint32(b.start)+uint32(b.length)-1)>>8; p++ {
+			page := bc.pages[p&0xFF]
+			for i := range page {
+				if page[i] == b {
+					bc.pages[p&0xFF] = append(page[:i], page[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+}
+
+// blockMemory invalidates cached blocks on writes.
+type blockMemory struct {
+	Memory
+	bc *BlockCache
+}
+
+func (m *blockMemory) Write(addr uint16, val uint8) {
+	m.Memory.Write(addr, val)
+	m.bc.written(addr)
+}
+
+func (m *blockMemory) Load(addr uint16, data []uint8) {
+	if l, ok := m.Memory.(Loader); ok {
+		l.Load(addr, data)
+	} else {
+		for i, b := range data {
+			m.Memory.Write(addr+uint16(i), b)
+		}
+	}
+	for i := range data {
+		m.bc.written(addr + uint16(i))
+	}
+}
@@ -0,0 +1,108 @@
+package i8080
+
+import (
+	"testing"
+	"time"
+)
+
+func sameState(a *CPU, b *CPU) bool {
+	return a.GetAF() == b.GetAF() && a.GetBC() == b.GetBC() && a.GetDE() == b.GetDE() &&
+		a.GetHL() == b.GetHL() && a.GetSP() == b.GetSP() && a.GetPC() == b.GetPC() &&
+		a.GetCycles() == b.GetCycles() && a.IsHalted() == b.IsHalted()
+}
+
+func TestBlockCacheExecute(t *testing.T) {
+	c := newTestCPU(BENCHMARK...)
+	b := newTestCPU(BENCHMARK...)
+	bc := NewBlockCache(b)
+	for i := 0; i < 10000; i++ {
+		c.Execute()
+		bc.Execute()
+		if !sameState(c, b) {
+			t.Fatalf("[instruction %d] expected: PC %04X AF %04X CYC %d, actual: PC %04X AF %04X CYC %d",
+				i, c.GetPC(), c.GetAF(), c.GetCycles(), b.GetPC(), b.GetAF(), b.GetCycles())
+		}
+	}
+}
+
+func TestBlockCacheRun(t *testing.T) {
+	for _, cycles := range []int{1, 7, 100, 12345} {
+		c := newTestCPU(BENCHMARK...)
+		b := newTestCPU(BENCHMARK...)
+		for c.GetCycles() < cycles {
+			c.Execute()
+		}
+		NewBlockCache(b).Run(cycles)
+		if !sameState(c, b) {
+			t.Errorf("[%d cycles] expected: PC %04X CYC %d, actual: PC %04X CYC %d",
+				cycles, c.GetPC(), c.GetCycles(), b.GetPC(), b.GetCycles())
+		}
+	}
+}
+
+func TestBlockCacheSelfModifying(t *testing.T) {
+	// MVI A,3CH; STA 0007H; NOP; NOP; NOP; HLT, where the STA turns the
+	// last NOP of the same block into INR A.
+	program := []uint8{0x3e, 0x3c, 0x32, 0x07, 0x00, 0x00, 0x00, 0x00, 0x76}
+	c := newTestCPU(program...)
+	bc := NewBlockCache(c)
+	bc.Run(1000)
+	if c.GetAF()>>8 != 0x3d {
+		t.Errorf("[run] expected: %02X, actual: %02X", 0x3d, c.GetAF()>>8)
+	}
+
+	c.Reset(0)
+	c.Write(0x7, 0x00)
+	for !c.IsHalted() {
+		bc.Execute()
+	}
+	if c.GetAF()>>8 != 0x3d {
+		t.Errorf("[execute] expected: %02X, actual: %02X", 0x3d, c.GetAF()>>8)
+	}
+}
+
+func TestBlockCacheMirror(t *testing.T) {
+	// The same program as above, run from RAM that is mirrored at 1000H
+	// and, wrapping around, at 2000H and 2100H. The STA writes the INR A
+	// through one of the mirrors.
+	tests := []struct {
+		name string
+		addr uint16
+	}{{"mirror", 0x1007}, {"wrap", 0x2107}}
+	for _, tt := range tests {
+		ram := NewRAM(0x100)
+		bus := NewBus()
+		bus.Map(0x0000, 0x100, ram)
+		bus.Map(0x1000, 0x100, ram)
+		bus.Map(0x2000, 0x200, ram)
+		c := NewCPU(0, bus, nil)
+		c.Load(0, []uint8{0x3e, 0x3c, 0x32, uint8(tt.addr), uint8(tt.addr >> 8), 0x00, 0x00, 0x00, 0x76})
+		bc := NewBlockCache(c)
+		bc.Run(1000)
+		if c.GetAF()>>8 != 0x3d {
+			t.Errorf("[%s] expected: %02X, actual: %02X", tt.name, 0x3d, c.GetAF()>>8)
+		}
+	}
+}
+
+func BenchmarkBlockCache(b *testing.B) {
+	c := newTestCPU(BENCHMARK...)
+	bc := NewBlockCache(c)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		bc.Execute()
+	}
+	b.ReportMetric(float64(c.GetCycles())/time.Since(start).Seconds()/1e6, "MHz")
+}
+
+func BenchmarkBlockCacheRun(b *testing.B) {
+	c := newTestCPU(BENCHMARK...)
+	bc := NewBlockCache(c)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		bc.Run(1000)
+	}
+	b.ReportMetric(float64(c.GetCycles())/time.Since(start).Seconds()/1e6, "MHz")
+}
@@ -2,7 +2,6 @@ package i8080
 
 import (
 	"io/ioutil"
-	"os"
 )
 
 var (
@@ -23,27 +22,78 @@ var (
 		05, 10, 10, 10, 11, 11, 07, 11, 05, 10, 10, 10, 11, 17, 07, 11,
 		05, 10, 10, 18, 11, 11, 07, 11, 05, 05, 10, 04, 11, 17, 07, 11,
 		05, 10, 10, 04, 11, 11, 07, 11, 05, 05, 10, 04, 11, 17, 07, 11}
+
+	// PARITY is the P flag for each byte: 1 when it has an even number of
+	// set bits.
+	PARITY = [256]uint8{
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		0, 1, 1, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 1, 1, 0,
+		1, 0, 0, 1, 0, 1, 1, 0, 0, 1, 1, 0, 1, 0, 0, 1}
 )
 
+// ZSP holds the Z, S and P flags for each byte.
+var ZSP [256]Flags
+
+func init() {
+	for i := range ZSP {
+		ZSP[i] = Flags(i)&FLAG_S | Flags(PARITY[i])<<2
+	}
+	ZSP[0] |= FLAG_Z
+}
+
 type CPU struct {
-	mem             [64 * 1024]uint8
-	reg             *Registers
-	flags           *Flags
-	pc, sp          uint16
-	romMax, ramMax  uint32
-	cyc             int
-	intEnabled      bool
-	portIn, portOut func(uint8)
+	mem        Memory
+	reg        *Registers
+	flags      Flags
+	pc, sp     uint16
+	cyc        int
+	intEnabled bool
+	intDelay   bool
+	halted     bool
+	io         IODevice
+	inject     []uint8
+	bus        []uint8
+	tracer     func(*CPU)
+}
+
+func NewCPU(pc uint16, mem Memory, io IODevice) *CPU {
+	if mem == nil {
+		mem = NewFlatMemory(0, 64*1024)
+	}
+	if io == nil {
+		io = NewPorts()
+	}
+	return &CPU{mem: mem, reg: &Registers{}, flags: FLAG_FIXED,
+		pc: pc, io: io}
+}
+
+func (c *CPU) GetMemory() Memory {
+	return c.mem
 }
 
-func NewCPU(pc uint16, romMax uint32, ramMax uint32, portIn func(uint8), portOut func(uint8)) *CPU {
-	return &CPU{reg: &Registers{}, flags: &Flags{},
-		ramMax: ramMax, romMax: romMax,
-		pc: pc, portIn: portIn, portOut: portOut}
+func (c *CPU) SetMemory(mem Memory) {
+	c.mem = mem
 }
 
-func (c *CPU) GetMemory() []uint8 {
-	return c.mem[:]
+func (c *CPU) GetIO() IODevice {
+	return c.io
+}
+
+func (c *CPU) SetIO(io IODevice) {
+	c.io = io
 }
 
 func (c *CPU) GetRegisters() *Registers {
@@ -58,6 +108,37 @@ func (c *CPU) GetSP() uint16 {
 	return c.sp
 }
 
+// GetBus returns the instruction an interrupting device placed on the data
+// bus while Interrupt calls the tracer, and nil otherwise.
+func (c *CPU) GetBus() []uint8 {
+	return c.bus
+}
+
+// SetTracer installs a function that Execute and Interrupt call before each
+// instruction, or removes it when nil.
+func (c *CPU) SetTracer(tracer func(*CPU)) {
+	c.tracer = tracer
+}
+
+// Reset puts the CPU in its power-on state with execution at pc. Memory,
+// the I/O device and the tracer are kept.
+func (c *CPU) Reset(pc uint16) {
+	*c.reg = Registers{}
+	c.flags = FLAG_FIXED
+	c.pc, c.sp = pc, 0
+	c.cyc = 0
+	c.intEnabled, c.intDelay, c.halted = false, false, false
+	c.inject = nil
+}
+
+func (c *CPU) SetPC(pc uint16) {
+	c.pc = pc
+}
+
+func (c *CPU) SetSP(sp uint16) {
+	c.sp = sp
+}
+
 func (c *CPU) GetCycles() int {
 	return c.cyc
 }
@@ -67,18 +148,15 @@ func (c *CPU) SubtractCycles(cyc int) {
 }
 
 func (c *CPU) IsInterrupted() bool {
-	return c.intEnabled
+	return c.intEnabled && !c.intDelay
+}
+
+func (c *CPU) IsHalted() bool {
+	return c.halted
 }
 
 func (c *CPU) GetAF() uint16 {
-	f := uint8(0)
-	f |= c.flags.S << 7
-	f |= c.flags.Z << 6
-	f |= c.flags.AC << 4
-	f |= c.flags.P << 2
-	f |= 1 << 1
-	f |= c.flags.CY << 0
-	return (uint16(c.reg.A) << 8) | uint16(f)
+	return (uint16(c.reg.A) << 8) | uint16(c.flags)
 }
 
 func (c *CPU) GetBC() uint16 {
@@ -93,17 +171,23 @@ func (c *CPU) GetHL() uint16 {
 	return (uint16(c.reg.H) << 8) | uint16(c.reg.L)
 }
 
-func (c *CPU) setBC(val uint16) {
+// SetAF sets A and the flags, forcing the fixed bits of the flags byte.
+func (c *CPU) SetAF(val uint16) {
+	c.reg.A = uint8(val >> 8)
+	c.flags = Flags(val)&FLAG_MASK | FLAG_FIXED
+}
+
+func (c *CPU) SetBC(val uint16) {
 	c.reg.B = uint8(val >> 8)
 	c.reg.C = uint8(val & 0xff)
 }
 
-func (c *CPU) setDE(val uint16) {
+func (c *CPU) SetDE(val uint16) {
 	c.reg.D = uint8(val >> 8)
 	c.reg.E = uint8(val & 0xff)
 }
 
-func (c *CPU) setHL(val uint16) {
+func (c *CPU) SetHL(val uint16) {
 	c.reg.H = uint8(val >> 8)
 	c.reg.L = uint8(val & 0xff)
 }
@@ -113,23 +197,34 @@ func (c *CPU) LoadRom(filename string) {
 	if err != nil {
 		panic(err)
 	}
-	for i := 0; i < len(rom); i++ {
-		c.mem[c.pc+uint16(i)] = rom[i]
+	c.Load(c.pc, rom)
+}
+
+func (c *CPU) Load(addr uint16, data []uint8) {
+	if l, ok := c.mem.(Loader); ok {
+		l.Load(addr, data)
+		return
+	}
+	for i := 0; i < len(data); i++ {
+		c.mem.Write(addr+uint16(i), data[i])
 	}
 }
 
 func (c *CPU) Write(addr uint16, val uint8) {
-	if uint32(addr) >= c.romMax && uint32(addr) < c.ramMax {
-		c.mem[addr] = val
-	}
+	c.mem.Write(addr, val)
 }
 
-func (c *CPU) read(addr uint16) uint8 {
-	return c.mem[addr]
+func (c *CPU) Read(addr uint16) uint8 {
+	return c.mem.Read(addr)
 }
 
 func (c *CPU) getNextByte() uint8 {
-	b := c.read(c.pc)
+	if len(c.inject) > 0 {
+		b := c.inject[0]
+		c.inject = c.inject[1:]
+		return b
+	}
+	b := c.Read(c.pc)
 	c.pc += 1
 	return b
 }
@@ -149,57 +244,55 @@ func (c *CPU) decode(opcode uint8) func(*CPU) {
 }
 
 func (c *CPU) Execute() {
+	if c.halted {
+		c.cyc += 4
+		return
+	}
+	c.intDelay = false
+	if c.tracer != nil {
+		c.tracer(c)
+	}
 	opcode := c.fetch()
 	c.cyc += CYCLES[opcode]
 	instr := c.decode(opcode)
 	instr(c)
 }
 
-func (c *CPU) Interrupt(vector uint16) {
-	c.push(c.pc)
+// Interrupt acknowledges an interrupt request if interrupts are enabled.
+// The bytes are the instruction the interrupting device places on the data
+// bus, usually a single RST, and are executed without advancing PC.
+func (c *CPU) Interrupt(data ...uint8) bool {
+	if !c.IsInterrupted() || len(data) == 0 {
+		return false
+	}
 	c.intEnabled = false
-	c.pc = vector
-}
-
-func (c *CPU) setZSP(val uint8) {
-	c.setZero(uint16(val))
-	c.setSign(uint16(val))
-	c.setParity(uint16(val))
-}
-
-func (c *CPU) setZero(val uint16) {
-	if (val & 0xff) == 0 {
-		c.flags.Z = 1
-	} else {
-		c.flags.Z = 0
+	c.halted = false
+	c.inject = data[1:]
+	if c.tracer != nil {
+		c.bus = data
+		c.tracer(c)
+		c.bus = nil
 	}
+	c.cyc += CYCLES[data[0]]
+	instr := c.decode(data[0])
+	instr(c)
+	c.inject = nil
+	return true
 }
 
-func (c *CPU) setSign(val uint16) {
-	if (val & 0x80) != 0 {
-		c.flags.S = 1
-	} else {
-		c.flags.S = 0
-	}
+func RST(n uint8) uint8 {
+	return 0xC7 | ((n & 7) << 3)
 }
 
-func (c *CPU) setParity(val uint16) {
-	ones := uint16(0)
-	for i := 0; i < 8; i++ {
-		ones += ((val >> i) & 1)
-	}
-	if (ones % 2) == 0 {
-		c.flags.P = 1
-	} else {
-		c.flags.P = 0
-	}
+func (c *CPU) setZSP(val uint8) {
+	c.flags = c.flags&^(FLAG_Z|FLAG_S|FLAG_P) | ZSP[val]
 }
 
 func (c *CPU) setCarry(val uint16) {
 	if val > 0xff {
-		c.flags.CY = 1
+		c.flags.set(FLAG_CY, 1)
 	} else {
-		c.flags.CY = 0
+		c.flags.set(FLAG_CY, 0)
 	}
 }
 
@@ -216,9 +309,9 @@ func (c *CPU) add(val uint8, cy uint8) {
 	c.setZSP(uint8(ans))
 	c.setCarry(ans)
 	if ((uint16(c.reg.A) ^ uint16(val) ^ ans) & 0x10) > 0 {
-		c.flags.AC = 1
+		c.flags.set(FLAG_AC, 1)
 	} else {
-		c.flags.AC = 0
+		c.flags.set(FLAG_AC, 0)
 	}
 	c.reg.A = uint8(ans)
 }
@@ -226,16 +319,16 @@ func (c *CPU) add(val uint8, cy uint8) {
 func (c *CPU) sub(val uint8, cy uint8) {
 	cy = flip(cy)
 	c.add(^val, cy)
-	c.flags.CY = flip(c.flags.CY)
+	c.flags ^= FLAG_CY
 }
 
 func (c *CPU) inr(val uint8) uint8 {
 	val++
 	c.setZSP(val)
 	if (val & 0xf) == 0 {
-		c.flags.AC = 1
+		c.flags.set(FLAG_AC, 1)
 	} else {
-		c.flags.AC = 0
+		c.flags.set(FLAG_AC, 0)
 	}
 	return val
 }
@@ -244,31 +337,31 @@ func (c *CPU) dcr(val uint8) uint8 {
 	val--
 	c.setZSP(val)
 	if (val & 0xf) == 0xf {
-		c.flags.AC = 0
+		c.flags.set(FLAG_AC, 0)
 	} else {
-		c.flags.AC = 1
+		c.flags.set(FLAG_AC, 1)
 	}
 	return val
 }
 
 func (c *CPU) dad(val uint16) {
 	ans := uint32(c.GetHL()) + uint32(val)
-	c.setHL(uint16(ans))
+	c.SetHL(uint16(ans))
 	if ((ans >> 16) & 1) == 1 {
-		c.flags.CY = 1
+		c.flags.set(FLAG_CY, 1)
 	} else {
-		c.flags.CY = 0
+		c.flags.set(FLAG_CY, 0)
 	}
 }
 
 func (c *CPU) and(val uint8) {
 	ans := uint16(c.reg.A & val)
 	c.setZSP(uint8(ans))
-	c.flags.CY = 0
+	c.flags.set(FLAG_CY, 0)
 	if ((c.reg.A | val) & 0x08) > 0 {
-		c.flags.AC = 1
+		c.flags.set(FLAG_AC, 1)
 	} else {
-		c.flags.AC = 0
+		c.flags.set(FLAG_AC, 0)
 	}
 	c.reg.A = uint8(ans)
 }
@@ -276,16 +369,14 @@ func (c *CPU) and(val uint8) {
 func (c *CPU) xor(val uint8) {
 	ans := uint16(c.reg.A ^ val)
 	c.setZSP(uint8(ans))
-	c.flags.CY = 0
-	c.flags.AC = 0
+	c.flags &^= FLAG_CY | FLAG_AC
 	c.reg.A = uint8(ans)
 }
 
 func (c *CPU) or(val uint8) {
 	ans := uint16(c.reg.A | val)
 	c.setZSP(uint8(ans))
-	c.flags.CY = 0
-	c.flags.AC = 0
+	c.flags &^= FLAG_CY | FLAG_AC
 	c.reg.A = uint8(ans)
 }
 
@@ -294,9 +385,9 @@ func (c *CPU) cmp(val uint8) {
 	c.setZSP(uint8(ans))
 	c.setCarry(ans)
 	if (^(uint16(c.reg.A) ^ ans ^ uint16(val)) & 0x10) > 0 {
-		c.flags.AC = 1
+		c.flags.set(FLAG_AC, 1)
 	} else {
-		c.flags.AC = 0
+		c.flags.set(FLAG_AC, 0)
 	}
 }
 
@@ -308,7 +399,7 @@ func (c *CPU) push(val uint16) {
 
 func (c *CPU) pop() uint16 {
 	c.sp += 2
-	return ((uint16(c.read(c.sp-1)) << 8) | uint16(c.read(c.sp-2)))
+	return ((uint16(c.Read(c.sp-1)) << 8) | uint16(c.Read(c.sp-2)))
 }
 
 // Data Transfer Group
@@ -337,7 +428,7 @@ func movBL(c *CPU) {
 }
 
 func movBM(c *CPU) {
-	c.reg.B = c.read(c.GetHL())
+	c.reg.B = c.Read(c.GetHL())
 }
 
 func movBA(c *CPU) {
@@ -367,7 +458,7 @@ func movCL(c *CPU) {
 }
 
 func movCM(c *CPU) {
-	c.reg.C = c.read(c.GetHL())
+	c.reg.C = c.Read(c.GetHL())
 }
 
 func movCA(c *CPU) {
@@ -397,7 +488,7 @@ func movDL(c *CPU) {
 }
 
 func movDM(c *CPU) {
-	c.reg.D = c.read(c.GetHL())
+	c.reg.D = c.Read(c.GetHL())
 }
 
 func movDA(c *CPU) {
@@ -427,7 +518,7 @@ func movEL(c *CPU) {
 }
 
 func movEM(c *CPU) {
-	c.reg.E = c.read(c.GetHL())
+	c.reg.E = c.Read(c.GetHL())
 }
 
 func movEA(c *CPU) {
@@ -457,7 +548,7 @@ func movHL(c *CPU) {
 }
 
 func movHM(c *CPU) {
-	c.reg.H = c.read(c.GetHL())
+	c.reg.H = c.Read(c.GetHL())
 }
 
 func movHA(c *CPU) {
@@ -487,7 +578,7 @@ func movLH(c *CPU) {
 func movLL(c *CPU) {}
 
 func movLM(c *CPU) {
-	c.reg.L = c.read(c.GetHL())
+	c.reg.L = c.Read(c.GetHL())
 }
 
 func movLA(c *CPU) {
@@ -519,7 +610,7 @@ func movAL(c *CPU) {
 }
 
 func movAM(c *CPU) {
-	c.reg.A = c.read(c.GetHL())
+	c.reg.A = c.Read(c.GetHL())
 }
 
 func movAA(c *CPU) {}
@@ -585,15 +676,15 @@ func mviM(c *CPU) {
 }
 
 func lxiB(c *CPU) {
-	c.setBC(c.getNextTwoBytes())
+	c.SetBC(c.getNextTwoBytes())
 }
 
 func lxiD(c *CPU) {
-	c.setDE(c.getNextTwoBytes())
+	c.SetDE(c.getNextTwoBytes())
 }
 
 func lxiH(c *CPU) {
-	c.setHL(c.getNextTwoBytes())
+	c.SetHL(c.getNextTwoBytes())
 }
 
 func lxiSP(c *CPU) {
@@ -601,7 +692,7 @@ func lxiSP(c *CPU) {
 }
 
 func lda(c *CPU) {
-	c.reg.A = c.read(c.getNextTwoBytes())
+	c.reg.A = c.Read(c.getNextTwoBytes())
 }
 
 func sta(c *CPU) {
@@ -610,8 +701,8 @@ func sta(c *CPU) {
 
 func lhld(c *CPU) {
 	addr := c.getNextTwoBytes()
-	c.reg.L = c.read(addr)
-	c.reg.H = c.read(addr + 1)
+	c.reg.L = c.Read(addr)
+	c.reg.H = c.Read(addr + 1)
 }
 
 func shld(c *CPU) {
@@ -621,11 +712,11 @@ func shld(c *CPU) {
 }
 
 func ldaxB(c *CPU) {
-	c.reg.A = c.read(c.GetBC())
+	c.reg.A = c.Read(c.GetBC())
 }
 
 func ldaxD(c *CPU) {
-	c.reg.A = c.read(c.GetDE())
+	c.reg.A = c.Read(c.GetDE())
 }
 
 func staxB(c *CPU) {
@@ -668,7 +759,7 @@ func addL(c *CPU) {
 }
 
 func addM(c *CPU) {
-	c.add(c.read(c.GetHL()), 0)
+	c.add(c.Read(c.GetHL()), 0)
 }
 
 func addA(c *CPU) {
@@ -680,39 +771,39 @@ func adi(c *CPU) {
 }
 
 func adcB(c *CPU) {
-	c.add(c.reg.B, c.flags.CY)
+	c.add(c.reg.B, c.flags.bit(FLAG_CY))
 }
 
 func adcC(c *CPU) {
-	c.add(c.reg.C, c.flags.CY)
+	c.add(c.reg.C, c.flags.bit(FLAG_CY))
 }
 
 func adcD(c *CPU) {
-	c.add(c.reg.D, c.flags.CY)
+	c.add(c.reg.D, c.flags.bit(FLAG_CY))
 }
 
 func adcE(c *CPU) {
-	c.add(c.reg.E, c.flags.CY)
+	c.add(c.reg.E, c.flags.bit(FLAG_CY))
 }
 
 func adcH(c *CPU) {
-	c.add(c.reg.H, c.flags.CY)
+	c.add(c.reg.H, c.flags.bit(FLAG_CY))
 }
 
 func adcL(c *CPU) {
-	c.add(c.reg.L, c.flags.CY)
+	c.add(c.reg.L, c.flags.bit(FLAG_CY))
 }
 
 func adcA(c *CPU) {
-	c.add(c.reg.A, c.flags.CY)
+	c.add(c.reg.A, c.flags.bit(FLAG_CY))
 }
 
 func adcM(c *CPU) {
-	c.add(c.read(c.GetHL()), c.flags.CY)
+	c.add(c.Read(c.GetHL()), c.flags.bit(FLAG_CY))
 }
 
 func aci(c *CPU) {
-	c.add(c.getNextByte(), c.flags.CY)
+	c.add(c.getNextByte(), c.flags.bit(FLAG_CY))
 }
 
 func subB(c *CPU) {
@@ -744,7 +835,7 @@ func subA(c *CPU) {
 }
 
 func subM(c *CPU) {
-	c.sub(c.read(c.GetHL()), 0)
+	c.sub(c.Read(c.GetHL()), 0)
 }
 
 func sui(c *CPU) {
@@ -752,39 +843,39 @@ func sui(c *CPU) {
 }
 
 func sbbB(c *CPU) {
-	c.sub(c.reg.B, c.flags.CY)
+	c.sub(c.reg.B, c.flags.bit(FLAG_CY))
 }
 
 func sbbC(c *CPU) {
-	c.sub(c.reg.C, c.flags.CY)
+	c.sub(c.reg.C, c.flags.bit(FLAG_CY))
 }
 
 func sbbD(c *CPU) {
-	c.sub(c.reg.D, c.flags.CY)
+	c.sub(c.reg.D, c.flags.bit(FLAG_CY))
 }
 
 func sbbE(c *CPU) {
-	c.sub(c.reg.E, c.flags.CY)
+	c.sub(c.reg.E, c.flags.bit(FLAG_CY))
 }
 
 func sbbH(c *CPU) {
-	c.sub(c.reg.H, c.flags.CY)
+	c.sub(c.reg.H, c.flags.bit(FLAG_CY))
 }
 
 func sbbL(c *CPU) {
-	c.sub(c.reg.L, c.flags.CY)
+	c.sub(c.reg.L, c.flags.bit(FLAG_CY))
 }
 
 func sbbA(c *CPU) {
-	c.sub(c.reg.A, c.flags.CY)
+	c.sub(c.reg.A, c.flags.bit(FLAG_CY))
 }
 
 func sbbM(c *CPU) {
-	c.sub(c.read(c.GetHL()), c.flags.CY)
+	c.sub(c.Read(c.GetHL()), c.flags.bit(FLAG_CY))
 }
 
 func sbi(c *CPU) {
-	c.sub(c.getNextByte(), c.flags.CY)
+	c.sub(c.getNextByte(), c.flags.bit(FLAG_CY))
 }
 
 func inrB(c *CPU) {
@@ -816,7 +907,7 @@ func inrA(c *CPU) {
 }
 
 func inrM(c *CPU) {
-	c.Write(c.GetHL(), c.inr(c.read(c.GetHL())))
+	c.Write(c.GetHL(), c.inr(c.Read(c.GetHL())))
 }
 
 func dcrB(c *CPU) {
@@ -848,19 +939,19 @@ func dcrA(c *CPU) {
 }
 
 func dcrM(c *CPU) {
-	c.Write(c.GetHL(), c.dcr(c.read(c.GetHL())))
+	c.Write(c.GetHL(), c.dcr(c.Read(c.GetHL())))
 }
 
 func inxB(c *CPU) {
-	c.setBC(c.GetBC() + 1)
+	c.SetBC(c.GetBC() + 1)
 }
 
 func inxD(c *CPU) {
-	c.setDE(c.GetDE() + 1)
+	c.SetDE(c.GetDE() + 1)
 }
 
 func inxH(c *CPU) {
-	c.setHL(c.GetHL() + 1)
+	c.SetHL(c.GetHL() + 1)
 }
 
 func inxSP(c *CPU) {
@@ -868,15 +959,15 @@ func inxSP(c *CPU) {
 }
 
 func dcxB(c *CPU) {
-	c.setBC(c.GetBC() - 1)
+	c.SetBC(c.GetBC() - 1)
 }
 
 func dcxD(c *CPU) {
-	c.setDE(c.GetDE() - 1)
+	c.SetDE(c.GetDE() - 1)
 }
 
 func dcxH(c *CPU) {
-	c.setHL(c.GetHL() - 1)
+	c.SetHL(c.GetHL() - 1)
 }
 
 func dcxSP(c *CPU) {
@@ -900,22 +991,22 @@ func dadSP(c *CPU) {
 }
 
 func daa(c *CPU) {
-	cy := c.flags.CY
+	cy := c.flags.bit(FLAG_CY)
 	lsb := c.reg.A & 0x0f
 	msb := c.reg.A >> 4
 	correction := 0
 
-	if lsb > 9 || c.flags.AC == 1 {
+	if lsb > 9 || c.flags.bit(FLAG_AC) == 1 {
 		correction += 0x06
 	}
 
-	if (c.flags.CY == 1 || msb > 9) || (msb >= 9 && lsb > 9) {
+	if (c.flags.bit(FLAG_CY) == 1 || msb > 9) || (msb >= 9 && lsb > 9) {
 		correction += 0x60
 		cy = 1
 	}
 
 	c.add(uint8(correction), 0)
-	c.flags.CY = cy
+	c.flags.set(FLAG_CY, cy)
 }
 
 // Logical Group
@@ -949,7 +1040,7 @@ func anaA(c *CPU) {
 }
 
 func anaM(c *CPU) {
-	c.and(c.read(c.GetHL()))
+	c.and(c.Read(c.GetHL()))
 }
 
 func ani(c *CPU) {
@@ -985,7 +1076,7 @@ func oraA(c *CPU) {
 }
 
 func oraM(c *CPU) {
-	c.or(c.read(c.GetHL()))
+	c.or(c.Read(c.GetHL()))
 }
 
 func ori(c *CPU) {
@@ -1021,7 +1112,7 @@ func xraA(c *CPU) {
 }
 
 func xraM(c *CPU) {
-	c.xor(c.read(c.GetHL()))
+	c.xor(c.Read(c.GetHL()))
 }
 
 func xri(c *CPU) {
@@ -1057,7 +1148,7 @@ func cmpA(c *CPU) {
 }
 
 func cmpM(c *CPU) {
-	c.cmp(c.read(c.GetHL()))
+	c.cmp(c.Read(c.GetHL()))
 }
 
 func cpi(c *CPU) {
@@ -1065,24 +1156,24 @@ func cpi(c *CPU) {
 }
 
 func rlc(c *CPU) {
-	c.flags.CY = c.reg.A >> 7
-	c.reg.A = (c.reg.A << 1) | c.flags.CY
+	c.flags.set(FLAG_CY, c.reg.A>>7)
+	c.reg.A = (c.reg.A << 1) | c.flags.bit(FLAG_CY)
 }
 
 func rrc(c *CPU) {
-	c.flags.CY = c.reg.A & 1
-	c.reg.A = (c.reg.A >> 1) | (c.flags.CY << 7)
+	c.flags.set(FLAG_CY, c.reg.A&1)
+	c.reg.A = (c.reg.A >> 1) | (c.flags.bit(FLAG_CY) << 7)
 }
 
 func ral(c *CPU) {
-	cy := c.flags.CY
-	c.flags.CY = c.reg.A >> 7
+	cy := c.flags.bit(FLAG_CY)
+	c.flags.set(FLAG_CY, c.reg.A>>7)
 	c.reg.A = (c.reg.A << 1) | cy
 }
 
 func rar(c *CPU) {
-	cy := c.flags.CY
-	c.flags.CY = c.reg.A & 1
+	cy := c.flags.bit(FLAG_CY)
+	c.flags.set(FLAG_CY, c.reg.A&1)
 	c.reg.A = (c.reg.A >> 1) | (cy << 7)
 }
 
@@ -1091,11 +1182,11 @@ func cma(c *CPU) {
 }
 
 func cmc(c *CPU) {
-	c.flags.CY ^= 1
+	c.flags ^= FLAG_CY
 }
 
 func stc(c *CPU) {
-	c.flags.CY = 1
+	c.flags.set(FLAG_CY, 1)
 }
 
 // Branch Group
@@ -1108,40 +1199,40 @@ func jmpCond(c *CPU, cond bool) {
 	if cond {
 		jmp(c)
 	} else {
-		c.pc += 2
+		c.getNextTwoBytes()
 	}
 }
 
 func jnz(c *CPU) {
-	jmpCond(c, c.flags.Z == 0)
+	jmpCond(c, c.flags.bit(FLAG_Z) == 0)
 }
 
 func jz(c *CPU) {
-	jmpCond(c, c.flags.Z == 1)
+	jmpCond(c, c.flags.bit(FLAG_Z) == 1)
 }
 
 func jnc(c *CPU) {
-	jmpCond(c, c.flags.CY == 0)
+	jmpCond(c, c.flags.bit(FLAG_CY) == 0)
 }
 
 func jc(c *CPU) {
-	jmpCond(c, c.flags.CY == 1)
+	jmpCond(c, c.flags.bit(FLAG_CY) == 1)
 }
 
 func jpo(c *CPU) {
-	jmpCond(c, c.flags.P == 0)
+	jmpCond(c, c.flags.bit(FLAG_P) == 0)
 }
 
 func jpe(c *CPU) {
-	jmpCond(c, c.flags.P == 1)
+	jmpCond(c, c.flags.bit(FLAG_P) == 1)
 }
 
 func jp(c *CPU) {
-	jmpCond(c, c.flags.S == 0)
+	jmpCond(c, c.flags.bit(FLAG_S) == 0)
 }
 
 func jm(c *CPU) {
-	jmpCond(c, c.flags.S == 1)
+	jmpCond(c, c.flags.bit(FLAG_S) == 1)
 }
 
 func ret(c *CPU) {
@@ -1156,35 +1247,35 @@ func retCond(c *CPU, cond bool) {
 }
 
 func rnz(c *CPU) {
-	retCond(c, c.flags.Z == 0)
+	retCond(c, c.flags.bit(FLAG_Z) == 0)
 }
 
 func rz(c *CPU) {
-	retCond(c, c.flags.Z == 1)
+	retCond(c, c.flags.bit(FLAG_Z) == 1)
 }
 
 func rnc(c *CPU) {
-	retCond(c, c.flags.CY == 0)
+	retCond(c, c.flags.bit(FLAG_CY) == 0)
 }
 
 func rc(c *CPU) {
-	retCond(c, c.flags.CY == 1)
+	retCond(c, c.flags.bit(FLAG_CY) == 1)
 }
 
 func rpo(c *CPU) {
-	retCond(c, c.flags.P == 0)
+	retCond(c, c.flags.bit(FLAG_P) == 0)
 }
 
 func rpe(c *CPU) {
-	retCond(c, c.flags.P == 1)
+	retCond(c, c.flags.bit(FLAG_P) == 1)
 }
 
 func rp(c *CPU) {
-	retCond(c, c.flags.S == 0)
+	retCond(c, c.flags.bit(FLAG_S) == 0)
 }
 
 func rm(c *CPU) {
-	retCond(c, c.flags.S == 1)
+	retCond(c, c.flags.bit(FLAG_S) == 1)
 }
 
 func call(c *CPU) {
@@ -1198,44 +1289,44 @@ func callCond(c *CPU, cond bool) {
 		call(c)
 		c.cyc += 6
 	} else {
-		c.pc += 2
+		c.getNextTwoBytes()
 	}
 }
 
 func cnz(c *CPU) {
-	callCond(c, c.flags.Z == 0)
+	callCond(c, c.flags.bit(FLAG_Z) == 0)
 }
 
 func cz(c *CPU) {
-	callCond(c, c.flags.Z == 1)
+	callCond(c, c.flags.bit(FLAG_Z) == 1)
 }
 
 func cnc(c *CPU) {
-	callCond(c, c.flags.CY == 0)
+	callCond(c, c.flags.bit(FLAG_CY) == 0)
 }
 
 func cc(c *CPU) {
-	callCond(c, c.flags.CY == 1)
+	callCond(c, c.flags.bit(FLAG_CY) == 1)
 }
 
 func cpo(c *CPU) {
-	callCond(c, c.flags.P == 0)
+	callCond(c, c.flags.bit(FLAG_P) == 0)
 }
 
 func cpe(c *CPU) {
-	callCond(c, c.flags.P == 1)
+	callCond(c, c.flags.bit(FLAG_P) == 1)
 }
 
 func cp(c *CPU) {
-	callCond(c, c.flags.S == 0)
+	callCond(c, c.flags.bit(FLAG_S) == 0)
 }
 
 func cm(c *CPU) {
-	callCond(c, c.flags.S == 1)
+	callCond(c, c.flags.bit(FLAG_S) == 1)
 }
 
 func callRst(c *CPU, addr uint16) {
-	call(c)
+	c.push(c.pc)
 	c.pc = addr
 }
 
@@ -1290,42 +1381,28 @@ func pushH(c *CPU) {
 }
 
 func pushPSW(c *CPU) {
-	psw := uint8(0)
-	psw |= c.flags.Z << 6
-	psw |= c.flags.S << 7
-	psw |= c.flags.P << 2
-	psw |= c.flags.CY << 0
-	psw |= c.flags.AC << 4
-	psw |= 1 << 1
-	c.push((uint16(c.reg.A) << 8) | uint16(psw))
+	c.push(c.GetAF())
 }
 
 func popB(c *CPU) {
-	c.setBC(c.pop())
+	c.SetBC(c.pop())
 }
 
 func popD(c *CPU) {
-	c.setDE(c.pop())
+	c.SetDE(c.pop())
 }
 
 func popH(c *CPU) {
-	c.setHL(c.pop())
+	c.SetHL(c.pop())
 }
 
 func popPSW(c *CPU) {
-	af := c.pop()
-	c.reg.A = uint8(af >> 8)
-	psw := uint8(af & 0xff)
-	c.flags.Z = (psw >> 6) & 1
-	c.flags.S = (psw >> 7) & 1
-	c.flags.P = (psw >> 2) & 1
-	c.flags.CY = (psw >> 0) & 1
-	c.flags.AC = (psw >> 4) & 1
+	c.SetAF(c.pop())
 }
 
 func xthl(c *CPU) {
-	sp1 := c.read(c.sp)
-	sp2 := c.read(c.sp + 1)
+	sp1 := c.Read(c.sp)
+	sp2 := c.Read(c.sp + 1)
 	c.Write(c.sp, c.reg.L)
 	c.Write(c.sp+1, c.reg.H)
 	c.reg.H = sp2
@@ -1339,15 +1416,16 @@ func sphl(c *CPU) {
 // IO and Machine Control Group
 
 func in(c *CPU) {
-	c.portIn(c.getNextByte())
+	c.reg.A = c.io.In(c.getNextByte())
 }
 
 func out(c *CPU) {
-	c.portOut(c.getNextByte())
+	c.io.Out(c.getNextByte(), c.reg.A)
 }
 
 func ei(c *CPU) {
 	c.intEnabled = true
+	c.intDelay = true
 }
 
 func di(c *CPU) {
@@ -1355,7 +1433,7 @@ func di(c *CPU) {
 }
 
 func hlt(c *CPU) {
-	os.Exit(0)
+	c.halted = true
 }
 
 func noOp(c *CPU) {}
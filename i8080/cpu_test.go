@@ -0,0 +1,260 @@
+package i8080
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func newTestCPU(program ...uint8) *CPU {
+	c := NewCPU(0x0, nil, nil)
+	for i, b := range program {
+		c.Write(uint16(i), b)
+	}
+	return c
+}
+
+func TestHalt(t *testing.T) {
+	c := newTestCPU(0x31, 0x00, 0x01, 0x76, 0x3c)
+	c.Execute()
+	c.Execute()
+	if !c.IsHalted() {
+		t.Fatalf("[halted] expected: true, actual: false")
+	}
+	cyc := c.GetCycles()
+	for i := 0; i < 3; i++ {
+		c.Execute()
+	}
+	if c.GetPC() != 0x4 {
+		t.Errorf("[pc] expected: %04X, actual: %04X", 0x4, c.GetPC())
+	}
+	if c.GetCycles()-cyc != 12 {
+		t.Errorf("[cycles] expected: %d, actual: %d", 12, c.GetCycles()-cyc)
+	}
+}
+
+func TestInterruptResumesHalt(t *testing.T) {
+	c := newTestCPU(0x31, 0x00, 0x01, 0xfb, 0x76, 0x3c)
+	c.Write(0x8, 0x3c)
+	for i := 0; i < 4; i++ {
+		c.Execute()
+	}
+	cyc := c.GetCycles()
+	if !c.Interrupt(RST(1)) {
+		t.Fatalf("[interrupt] expected: true, actual: false")
+	}
+	if c.IsHalted() {
+		t.Fatalf("[halted] expected: false, actual: true")
+	}
+	if c.GetCycles()-cyc != 11 {
+		t.Errorf("[cycles] expected: %d, actual: %d", 11, c.GetCycles()-cyc)
+	}
+	c.Execute()
+	if c.GetRegisters().A != 1 {
+		t.Errorf("[A] expected: %d, actual: %d", 1, c.GetRegisters().A)
+	}
+	if c.GetPC() != 0x9 {
+		t.Errorf("[pc] expected: %04X, actual: %04X", 0x9, c.GetPC())
+	}
+	if c.Read(0xFF) != 0x00 || c.Read(0xFE) != 0x05 {
+		t.Errorf("[return] expected: %04X, actual: %02X%02X", 0x5, c.Read(0xFF), c.Read(0xFE))
+	}
+}
+
+func TestInterruptCall(t *testing.T) {
+	c := newTestCPU(0x31, 0x00, 0x01, 0xfb, 0x00)
+	for i := 0; i < 3; i++ {
+		c.Execute()
+	}
+	cyc := c.GetCycles()
+	if !c.Interrupt(0xcd, 0x34, 0x12) {
+		t.Fatalf("[interrupt] expected: true, actual: false")
+	}
+	if c.GetPC() != 0x1234 {
+		t.Errorf("[pc] expected: %04X, actual: %04X", 0x1234, c.GetPC())
+	}
+	if c.GetCycles()-cyc != 17 {
+		t.Errorf("[cycles] expected: %d, actual: %d", 17, c.GetCycles()-cyc)
+	}
+	if c.Read(0xFF) != 0x00 || c.Read(0xFE) != 0x05 {
+		t.Errorf("[return] expected: %04X, actual: %02X%02X", 0x5, c.Read(0xFF), c.Read(0xFE))
+	}
+}
+
+func TestInterruptEnableDelay(t *testing.T) {
+	c := newTestCPU(0xfb, 0x00, 0x00)
+	c.Execute()
+	if c.Interrupt(RST(7)) {
+		t.Fatalf("[interrupt] accepted before the instruction after EI")
+	}
+	c.Execute()
+	if !c.Interrupt(RST(7)) {
+		t.Fatalf("[interrupt] expected: true, actual: false")
+	}
+	if c.Interrupt(RST(7)) {
+		t.Errorf("[interrupt] accepted while interrupts are disabled")
+	}
+}
+
+func TestBusMirror(t *testing.T) {
+	ram := NewRAM(0x2000)
+	bus := NewBus()
+	bus.Map(0x0000, 0x2000, ROM(make([]uint8, 0x2000)))
+	bus.Map(0x2000, 0x2000, ram)
+	bus.Map(0x4000, 0x2000, ram)
+	c := NewCPU(0x0, bus, nil)
+	c.Load(0x0, []uint8{0x3e, 0x42, 0x32, 0x10, 0x40})
+	c.Execute()
+	c.Execute()
+	if c.Read(0x2010) != 0x42 {
+		t.Errorf("[mirror] expected: %02X, actual: %02X", 0x42, c.Read(0x2010))
+	}
+	c.Write(0x0001, 0x00)
+	if c.Read(0x0001) != 0x42 {
+		t.Errorf("[rom] expected: %02X, actual: %02X", 0x42, c.Read(0x0001))
+	}
+	if c.Read(0x8000) != 0xFF {
+		t.Errorf("[unmapped] expected: %02X, actual: %02X", 0xFF, c.Read(0x8000))
+	}
+	if aliases := bus.Aliases(0x4010, nil); len(aliases) != 2 || aliases[0] != 0x2010 || aliases[1] != 0x4010 {
+		t.Errorf("[aliases] expected: [2010 4010], actual: %04X", aliases)
+	}
+	if aliases := bus.Aliases(0x0010, nil); len(aliases) != 0 {
+		t.Errorf("[aliases] expected: none for ROM, actual: %04X", aliases)
+	}
+	buf := make([]uint16, 0, 2)
+	if n := testing.AllocsPerRun(100, func() { buf = bus.Aliases(0x2010, buf[:0]) }); n != 0 {
+		t.Errorf("[aliases] expected: no allocations, actual: %v", n)
+	}
+}
+
+type latch struct {
+	val uint8
+}
+
+func (l *latch) In(port uint8) uint8 {
+	return l.val + port
+}
+
+func (l *latch) Out(port uint8, val uint8) {
+	l.val = val
+}
+
+func TestPorts(t *testing.T) {
+	dev := &latch{}
+	ports := NewPorts()
+	ports.Attach(dev, 0x10, 0x11)
+	c := NewCPU(0x0, nil, ports)
+	c.Load(0x0, []uint8{0x3e, 0x40, 0xd3, 0x10, 0xdb, 0x11, 0x47, 0xdb, 0x12})
+	for i := 0; i < 5; i++ {
+		c.Execute()
+	}
+	if dev.val != 0x40 {
+		t.Errorf("[out] expected: %02X, actual: %02X", 0x40, dev.val)
+	}
+	if c.GetRegisters().B != 0x51 {
+		t.Errorf("[in] expected: %02X, actual: %02X", 0x51, c.GetRegisters().B)
+	}
+	if c.GetRegisters().A != 0xFF {
+		t.Errorf("[unattached] expected: %02X, actual: %02X", 0xFF, c.GetRegisters().A)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	c := newTestCPU(0x31, 0x00, 0x02, 0x3e, 0x99, 0x37, 0x21, 0x34, 0x12, 0xfb, 0xe5, 0x76)
+	for i := 0; i < 7; i++ {
+		c.Execute()
+	}
+	var buf bytes.Buffer
+	if err := c.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	r := NewCPU(0x0, nil, nil)
+	if err := r.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	if r.GetAF() != c.GetAF() || r.GetHL() != c.GetHL() || r.GetSP() != c.GetSP() || r.GetPC() != c.GetPC() {
+		t.Errorf("[registers] expected: %04X %04X %04X %04X, actual: %04X %04X %04X %04X",
+			c.GetAF(), c.GetHL(), c.GetSP(), c.GetPC(), r.GetAF(), r.GetHL(), r.GetSP(), r.GetPC())
+	}
+	if r.GetCycles() != c.GetCycles() || r.IsHalted() != c.IsHalted() || r.IsInterrupted() != c.IsInterrupted() {
+		t.Errorf("[status] state was not restored")
+	}
+	if r.Read(0x1FF) != 0x12 || r.Read(0x1FE) != 0x34 {
+		t.Errorf("[memory] expected: %04X, actual: %02X%02X", 0x1234, r.Read(0x1FF), r.Read(0x1FE))
+	}
+
+	saved[20] ^= 0xFF
+	if err := r.LoadState(bytes.NewReader(saved)); err != ErrStateChecksum {
+		t.Errorf("[checksum] expected: %v, actual: %v", ErrStateChecksum, err)
+	}
+
+	// Length is the last field of the header.
+	binary.LittleEndian.PutUint32(saved[6:], 0xFFFFFFF0)
+	if err := r.LoadState(bytes.NewReader(saved)); err != ErrStateLength {
+		t.Errorf("[length] expected: %v, actual: %v", ErrStateLength, err)
+	}
+}
+
+func TestSetters(t *testing.T) {
+	c := newTestCPU(0xf5, 0xc1) // PUSH PSW; POP B
+	c.SetAF(0x12FF)
+	c.SetBC(0x3456)
+	c.SetDE(0x789A)
+	c.SetHL(0xBCDE)
+	c.SetSP(0x100)
+	if c.GetAF() != 0x12D7 {
+		t.Errorf("[fixed bits] expected: %04X, actual: %04X", 0x12D7, c.GetAF())
+	}
+	if c.GetBC() != 0x3456 || c.GetDE() != 0x789A || c.GetHL() != 0xBCDE || c.GetSP() != 0x100 {
+		t.Errorf("[pairs] expected: 3456 789A BCDE 0100, actual: %04X %04X %04X %04X",
+			c.GetBC(), c.GetDE(), c.GetHL(), c.GetSP())
+	}
+	c.Execute()
+	c.Execute()
+	if c.GetBC() != 0x12D7 {
+		t.Errorf("[push psw] expected: %04X, actual: %04X", 0x12D7, c.GetBC())
+	}
+
+	c.SetAF(0x0000)
+	if c.GetAF() != 0x0002 {
+		t.Errorf("[bit 1] expected: %04X, actual: %04X", 0x0002, c.GetAF())
+	}
+
+	c.Reset(0x100)
+	if c.GetAF() != 0x0002 || c.GetBC() != 0 || c.GetSP() != 0 || c.GetPC() != 0x100 || c.GetCycles() != 0 {
+		t.Errorf("[reset] expected: 0002 0000 0000 0100 0, actual: %04X %04X %04X %04X %d",
+			c.GetAF(), c.GetBC(), c.GetSP(), c.GetPC(), c.GetCycles())
+	}
+	if c.Read(0) != 0xf5 {
+		t.Errorf("[reset memory] expected: %02X, actual: %02X", 0xf5, c.Read(0))
+	}
+}
+
+// A loop of arithmetic, logic, memory and stack instructions.
+var BENCHMARK = []uint8{
+	0x31, 0x00, 0x10, // LXI SP,1000H
+	0x26, 0x08, //       MVI H,08H
+	0x3c,             // INR A
+	0x86,             // ADD M
+	0x77,             // MOV M,A
+	0xa8,             // XRA B
+	0x23,             // INX H
+	0xc5,             // PUSH B
+	0xc1,             // POP B
+	0x0f,             // RRC
+	0xc3, 0x03, 0x00, // JMP 0003H
+}
+
+func BenchmarkExecute(b *testing.B) {
+	c := newTestCPU(BENCHMARK...)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		c.Execute()
+	}
+	b.ReportMetric(float64(c.GetCycles())/time.Since(start).Seconds()/1e6, "MHz")
+}
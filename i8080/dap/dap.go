@@ -0,0 +1,703 @@
+// Package dap serves the Debug Adapter Protocol for 8080 programs. Launching
+// an .asm file assembles it and maps breakpoints and stack frames onto
+// source lines through the assembler listing; binaries are debugged by
+// address only. Attaching connects to a machine that is already running.
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/asm"
+	"github.com/is386/Go8080/i8080/debugger"
+)
+
+const (
+	REGISTERS = iota + 1
+	FLAGS
+	SYMBOLS
+)
+
+var (
+	REGISTER_NAMES = []string{"A", "F", "B", "C", "D", "E", "H", "L", "BC", "DE", "HL", "SP", "PC"}
+	FLAG_BITS      = []struct {
+		name string
+		bit  uint
+	}{{"S", 7}, {"Z", 6}, {"AC", 4}, {"P", 2}, {"CY", 0}}
+
+	STOP_REASONS = map[int]string{
+		debugger.STEP:        "step",
+		debugger.BREAKPOINT:  "breakpoint",
+		debugger.WATCH_READ:  "data breakpoint",
+		debugger.WATCH_WRITE: "data breakpoint",
+		debugger.PORT_IN:     "exception",
+		debugger.PORT_OUT:    "exception",
+		debugger.HALT:        "pause",
+		debugger.PAUSE:       "pause",
+	}
+
+	// IDLE_COMMANDS are the requests that do not touch the machine, and so
+	// can be served while it runs.
+	IDLE_COMMANDS = map[string]bool{
+		"initialize": true, "threads": true, "pause": true, "terminate": true, "disconnect": true,
+	}
+)
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type launchArguments struct {
+	Program     string `json:"program"`
+	Org         *int   `json:"org"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type Session struct {
+	r           *bufio.Reader
+	w           io.Writer
+	mu          sync.Mutex
+	seq         int
+	attach      *debugger.Debugger
+	d           *debugger.Debugger
+	prog        *asm.Program
+	lines       map[uint16]asm.Line
+	labels      []string
+	breakpoints map[string][]uint16
+	stopOnEntry bool
+	pending     func() debugger.Stop
+	running     chan struct{}
+	continuing  bool
+	quiet       bool
+}
+
+// NewSession creates a session on a client connection. attach is the
+// machine an attach request connects to and may be nil. The machine is run
+// on a goroutine of the session's own, so it must not need to stay on one
+// thread, as SDL does.
+func NewSession(r io.Reader, w io.Writer, attach *debugger.Debugger) *Session {
+	return &Session{r: bufio.NewReader(r), w: w, attach: attach, breakpoints: map[string][]uint16{}}
+}
+
+// ListenAndServe accepts clients on a TCP address one at a time.
+func ListenAndServe(addr string, attach *debugger.Debugger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		err = NewSession(conn, conn, attach).Run()
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run handles requests until the client disconnects or terminates the
+// program.
+func (s *Session) Run() error {
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		body, err := s.serve(req)
+		resp := response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(&resp)
+		if s.pending != nil {
+			s.running = make(chan struct{})
+			go s.run(s.pending, s.running)
+			s.pending = nil
+		}
+		switch req.Command {
+		case "launch", "attach":
+			if err == nil {
+				s.event("initialized", nil)
+			}
+		case "configurationDone":
+			if s.stopOnEntry {
+				s.event("stopped", map[string]interface{}{"reason": "entry", "threadId": 1, "allThreadsStopped": true})
+			}
+		case "terminate":
+			s.interrupt()
+			s.event("terminated", nil)
+			return nil
+		case "disconnect":
+			s.interrupt()
+			return nil
+		}
+	}
+}
+
+func (s *Session) read() (*request, error) {
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length")
+	}
+	data := make([]uint8, n)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (s *Session) send(msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	data, _ := json.Marshal(msg)
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *Session) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// serve handles a request without touching the machine while it runs in
+// the background. Breakpoints are changed by pausing a Continue and resuming
+// it, and other requests that need the machine are refused.
+func (s *Session) serve(req *request) (interface{}, error) {
+	if IDLE_COMMANDS[req.Command] || !s.isRunning() {
+		return s.handle(req)
+	}
+	if req.Command != "setBreakpoints" {
+		return nil, fmt.Errorf("the program is running")
+	}
+	resume := s.interrupt()
+	body, err := s.handle(req)
+	if resume {
+		s.cont()
+	}
+	return body, err
+}
+
+func (s *Session) isRunning() bool {
+	if s.running == nil {
+		return false
+	}
+	select {
+	case <-s.running:
+		s.running = nil
+		return false
+	default:
+		return true
+	}
+}
+
+// interrupt pauses the machine and waits for it to stop. A Continue is
+// paused without telling the client, and interrupt reports whether it
+// should be resumed.
+func (s *Session) interrupt() bool {
+	if !s.isRunning() {
+		return false
+	}
+	s.mu.Lock()
+	s.quiet = s.continuing
+	s.mu.Unlock()
+	s.d.Pause()
+	<-s.running
+	s.running = nil
+
+	// The stop is still reported if the machine stopped by itself first.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resume := s.continuing && !s.quiet
+	s.quiet = false
+	return resume
+}
+
+func (s *Session) handle(req *request) (interface{}, error) {
+	if s.d == nil {
+		switch req.Command {
+		case "initialize", "launch", "attach", "disconnect":
+		default:
+			return nil, fmt.Errorf("no program is loaded")
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsSetVariable":              true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.launch(req.Arguments)
+	case "attach":
+		if s.attach == nil {
+			return nil, fmt.Errorf("there is no machine to attach to")
+		}
+		var args launchArguments
+		json.Unmarshal(req.Arguments, &args)
+		if args.Program != "" {
+			prog, err := asm.AssembleFile(args.Program)
+			if err != nil {
+				return nil, err
+			}
+			s.setProgram(prog)
+		}
+		s.d, s.stopOnEntry = s.attach, args.StopOnEntry
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "configurationDone":
+		if !s.stopOnEntry {
+			s.cont()
+		}
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []map[string]interface{}{{"id": 1, "name": "8080"}}}, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		scopes := []map[string]interface{}{
+			{"name": "Registers", "variablesReference": REGISTERS, "expensive": false},
+			{"name": "Flags", "variablesReference": FLAGS, "expensive": false},
+		}
+		if s.prog != nil {
+			scopes = append(scopes, map[string]interface{}{"name": "Symbols", "variablesReference": SYMBOLS, "expensive": false})
+		}
+		return map[string]interface{}{"scopes": scopes}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		return map[string]interface{}{"variables": s.variables(args.VariablesReference)}, nil
+	case "setVariable":
+		return s.setVariable(req.Arguments)
+	case "continue":
+		s.cont()
+		return map[string]bool{"allThreadsContinued": true}, nil
+	case "next":
+		s.resume(s.d.StepOver)
+	case "stepIn":
+		s.resume(s.d.Step)
+	case "stepOut":
+		s.resume(s.d.StepOut)
+	case "pause":
+		s.d.Pause()
+	case "readMemory":
+		return s.readMemory(req.Arguments)
+	case "writeMemory":
+		return s.writeMemory(req.Arguments)
+	case "terminate", "disconnect":
+	default:
+		return nil, fmt.Errorf("%s is not supported", req.Command)
+	}
+	return nil, nil
+}
+
+func (s *Session) launch(raw json.RawMessage) error {
+	var args launchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	cpu := i8080.NewCPU(0, nil, nil)
+	if strings.EqualFold(filepath.Ext(args.Program), ".asm") {
+		prog, err := asm.AssembleFile(args.Program)
+		if err != nil {
+			return err
+		}
+		prog.LoadInto(cpu)
+		cpu.SetPC(prog.Entry)
+		s.setProgram(prog)
+	} else {
+		data, err := ioutil.ReadFile(args.Program)
+		if err != nil {
+			return err
+		}
+		org := 0x100
+		if args.Org != nil {
+			org = *args.Org
+		}
+		cpu.Load(uint16(org), data)
+		cpu.SetPC(uint16(org))
+	}
+	s.d, s.stopOnEntry = debugger.New(cpu, nil), args.StopOnEntry
+	return nil
+}
+
+func (s *Session) setProgram(prog *asm.Program) {
+	s.prog = prog
+	s.lines = map[uint16]asm.Line{}
+	for _, line := range prog.Listing {
+		if _, ok := s.lines[line.Addr]; len(line.Bytes) > 0 && !ok {
+			line.File = absPath(line.File)
+			s.lines[line.Addr] = line
+		}
+	}
+	s.labels = nil
+	for name := range prog.Symbols {
+		if !strings.HasPrefix(name, "??") {
+			s.labels = append(s.labels, name)
+		}
+	}
+	sort.Slice(s.labels, func(i, j int) bool {
+		a, b := prog.Symbols[s.labels[i]], prog.Symbols[s.labels[j]]
+		return a < b || (a == b && s.labels[i] < s.labels[j])
+	})
+}
+
+// resume runs the machine once the response has been sent. It runs in the
+// background so that a pause request can be handled.
+func (s *Session) resume(run func() debugger.Stop) {
+	s.pending, s.continuing = run, false
+}
+
+func (s *Session) cont() {
+	s.pending, s.continuing = s.d.Continue, true
+}
+
+func (s *Session) run(run func() debugger.Stop, done chan struct{}) {
+	stop := run()
+	s.mu.Lock()
+	quiet := s.quiet && stop.Reason == debugger.PAUSE
+	if quiet {
+		s.quiet = false
+	}
+	s.mu.Unlock()
+	// The client may ask about the stop as soon as it is told of it.
+	close(done)
+	if quiet {
+		return
+	}
+	if stop.Reason == debugger.EXIT {
+		s.event("exited", map[string]int{"exitCode": 0})
+		s.event("terminated", nil)
+		return
+	}
+	s.event("stopped", map[string]interface{}{
+		"reason": STOP_REASONS[stop.Reason], "description": stop.String(),
+		"threadId": 1, "allThreadsStopped": true,
+	})
+}
+
+func (s *Session) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	path := absPath(args.Source.Path)
+	for _, addr := range s.breakpoints[path] {
+		s.d.RemoveBreakpoint(addr)
+	}
+	s.breakpoints[path] = nil
+
+	results := make([]map[string]interface{}, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		line, ok := s.findLine(path, bp.Line)
+		if !ok {
+			results = append(results, map[string]interface{}{"verified": false, "line": bp.Line})
+			continue
+		}
+		s.d.AddBreakpoint(line.Addr)
+		s.breakpoints[path] = append(s.breakpoints[path], line.Addr)
+		results = append(results, map[string]interface{}{
+			"verified": true, "line": line.Line, "instructionReference": fmt.Sprintf("0x%04X", line.Addr),
+		})
+	}
+	return map[string]interface{}{"breakpoints": results}, nil
+}
+
+// findLine returns the first line at or after line in path that assembled
+// to code.
+func (s *Session) findLine(path string, line int) (asm.Line, bool) {
+	var best asm.Line
+	found := false
+	for _, l := range s.lines {
+		if l.File != path || l.Line < line {
+			continue
+		}
+		if !found || l.Line < best.Line || (l.Line == best.Line && l.Addr < best.Addr) {
+			best, found = l, true
+		}
+	}
+	return best, found
+}
+
+func (s *Session) stackTrace() interface{} {
+	cpu := s.d.GetCPU()
+	addrs := []uint16{cpu.GetPC()}
+	calls := s.d.CallStack()
+	for i := len(calls) - 1; i >= 0; i-- {
+		addrs = append(addrs, calls[i].Call)
+	}
+	frames := make([]map[string]interface{}, len(addrs))
+	for i, addr := range addrs {
+		frame := map[string]interface{}{
+			"id": i, "name": s.name(addr), "line": 0, "column": 0,
+			"instructionPointerReference": fmt.Sprintf("0x%04X", addr),
+		}
+		if line, ok := s.lines[addr]; ok {
+			frame["source"] = source{Name: filepath.Base(line.File), Path: line.File}
+			frame["line"], frame["column"] = line.Line, 1
+		}
+		frames[i] = frame
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// name describes addr by the nearest label at or below it.
+func (s *Session) name(addr uint16) string {
+	i := sort.Search(len(s.labels), func(i int) bool { return s.prog.Symbols[s.labels[i]] > addr })
+	if i == 0 {
+		return fmt.Sprintf("%04XH", addr)
+	}
+	label := s.labels[i-1]
+	if off := addr - s.prog.Symbols[label]; off != 0 {
+		return fmt.Sprintf("%s+%XH", label, off)
+	}
+	return label
+}
+
+func (s *Session) variables(ref int) []map[string]interface{} {
+	cpu := s.d.GetCPU()
+	vars := []map[string]interface{}{}
+	switch ref {
+	case REGISTERS:
+		for _, name := range REGISTER_NAMES {
+			val := s.register(name)
+			format := "%02XH"
+			if len(name) == 2 {
+				format = "%04XH"
+			}
+			v := map[string]interface{}{"name": name, "value": fmt.Sprintf(format, val), "variablesReference": 0}
+			if len(name) == 2 {
+				v["memoryReference"] = fmt.Sprintf("0x%04X", val)
+			}
+			vars = append(vars, v)
+		}
+	case FLAGS:
+		f := uint8(cpu.GetAF())
+		for _, flag := range FLAG_BITS {
+			vars = append(vars, map[string]interface{}{
+				"name": flag.name, "value": strconv.Itoa(int(f>>flag.bit) & 1), "variablesReference": 0,
+			})
+		}
+	case SYMBOLS:
+		for _, name := range s.labels {
+			addr := s.prog.Symbols[name]
+			lo, hi := s.d.Read(addr), s.d.Read(addr+1)
+			vars = append(vars, map[string]interface{}{
+				"name": name, "value": fmt.Sprintf("%02XH (word %04XH)", lo, uint16(hi)<<8|uint16(lo)),
+				"variablesReference": 0, "memoryReference": fmt.Sprintf("0x%04X", addr),
+			})
+		}
+	}
+	return vars
+}
+
+func (s *Session) register(name string) uint16 {
+	cpu := s.d.GetCPU()
+	switch name {
+	case "A":
+		return cpu.GetAF() >> 8
+	case "F":
+		return cpu.GetAF() & 0xFF
+	case "B":
+		return cpu.GetBC() >> 8
+	case "C":
+		return cpu.GetBC() & 0xFF
+	case "D":
+		return cpu.GetDE() >> 8
+	case "E":
+		return cpu.GetDE() & 0xFF
+	case "H":
+		return cpu.GetHL() >> 8
+	case "L":
+		return cpu.GetHL() & 0xFF
+	case "BC":
+		return cpu.GetBC()
+	case "DE":
+		return cpu.GetDE()
+	case "HL":
+		return cpu.GetHL()
+	case "SP":
+		return cpu.GetSP()
+	}
+	return cpu.GetPC()
+}
+
+func (s *Session) setVariable(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	val, err := parseNumber(args.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch args.VariablesReference {
+	case REGISTERS:
+		if err := s.d.SetRegister(args.Name, uint16(val)); err != nil {
+			return nil, err
+		}
+	case FLAGS:
+		af := s.d.GetCPU().GetAF()
+		for _, flag := range FLAG_BITS {
+			if flag.name == args.Name {
+				af = af&^(1<<flag.bit) | uint16(val&1)<<flag.bit
+			}
+		}
+		s.d.GetCPU().SetAF(af)
+	default:
+		return nil, fmt.Errorf("%s cannot be set", args.Name)
+	}
+	for _, v := range s.variables(args.VariablesReference) {
+		if v["name"] == strings.ToUpper(args.Name) {
+			return map[string]interface{}{"value": v["value"]}, nil
+		}
+	}
+	return map[string]interface{}{"value": args.Value}, nil
+}
+
+func (s *Session) readMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	base, err := parseNumber(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	if args.Count < 0 {
+		return nil, fmt.Errorf("invalid count %d", args.Count)
+	}
+	if args.Count > 0x10000 {
+		args.Count = 0x10000
+	}
+	addr := uint16(base + args.Offset)
+	data := make([]uint8, args.Count)
+	for i := range data {
+		data[i] = s.d.Read(addr + uint16(i))
+	}
+	return map[string]interface{}{
+		"address": fmt.Sprintf("0x%04X", addr), "data": base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+func (s *Session) writeMemory(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Data            string `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	base, err := parseNumber(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, err
+	}
+	addr := uint16(base + args.Offset)
+	for i, b := range data {
+		s.d.Write(addr+uint16(i), b)
+	}
+	return map[string]int{"bytesWritten": len(data)}, nil
+}
+
+// parseNumber reads a decimal number, a 0x prefixed or H suffixed
+// hexadecimal number.
+func parseNumber(text string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(text))
+	base := 10
+	if strings.HasPrefix(s, "0X") {
+		base, s = 16, s[2:]
+	} else if strings.HasSuffix(s, "H") {
+		base, s = 16, s[:len(s)-1]
+	}
+	val, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return int(val), nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,278 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const PROGRAM = `	ORG	0
+	LXI	SP,100H
+	CALL	OUTER
+	HLT
+OUTER:	MVI	A,5
+	CALL	INNER
+	RET
+INNER:	INR	A
+	RET
+	END
+`
+
+type message struct {
+	Type    string                 `json:"type"`
+	Event   string                 `json:"event"`
+	Command string                 `json:"command"`
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Body    map[string]interface{} `json:"body"`
+}
+
+type client struct {
+	t   *testing.T
+	w   io.Writer
+	r   *bufio.Reader
+	seq int
+}
+
+func (c *client) request(command string, args interface{}) {
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (c *client) next() message {
+	var n int
+	if _, err := fmt.Fscanf(c.r, "Content-Length: %d\r\n\r\n", &n); err != nil {
+		c.t.Fatal(err)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// expect reads the next message and checks that it is the given response
+// or event.
+func (c *client) expect(kind string, name string) message {
+	msg := c.next()
+	if msg.Type != kind || (msg.Command != name && msg.Event != name) {
+		c.t.Fatalf("[%s] expected: %s %s, actual: %+v", name, kind, name, msg)
+	}
+	if kind == "response" && !msg.Success {
+		c.t.Fatalf("[%s] failed: %s", name, msg.Message)
+	}
+	return msg
+}
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.asm")
+	if err := ioutil.WriteFile(path, []byte(PROGRAM), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewSession(inR, outW, nil).Run()
+	}()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("initialize", map[string]string{"adapterID": "i8080"})
+	c.expect("response", "initialize")
+	c.request("launch", map[string]interface{}{"program": path})
+	c.expect("response", "launch")
+	c.expect("event", "initialized")
+
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 8}, {"line": 100}},
+	})
+	bps := c.expect("response", "setBreakpoints").Body["breakpoints"].([]interface{})
+	if bp := bps[0].(map[string]interface{}); bp["verified"] != true || bp["line"] != 8.0 {
+		t.Errorf("[breakpoint] expected: verified at line 8, actual: %v", bp)
+	}
+	if bp := bps[1].(map[string]interface{}); bp["verified"] != false {
+		t.Errorf("[breakpoint] expected: unverified, actual: %v", bp)
+	}
+
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone")
+	if stop := c.expect("event", "stopped"); stop.Body["reason"] != "breakpoint" {
+		t.Errorf("[stopped] expected: breakpoint, actual: %v", stop.Body)
+	}
+
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	frames := c.expect("response", "stackTrace").Body["stackFrames"].([]interface{})
+	expected := []struct {
+		name string
+		line float64
+	}{{"INNER", 8}, {"OUTER+2H", 6}, {"0003H", 3}}
+	if len(frames) != len(expected) {
+		t.Fatalf("[stackTrace] expected: %d frames, actual: %v", len(expected), frames)
+	}
+	for i, exp := range expected {
+		frame := frames[i].(map[string]interface{})
+		if frame["name"] != exp.name || frame["line"] != exp.line {
+			t.Errorf("[frame %d] expected: %s line %v, actual: %v line %v", i, exp.name, exp.line, frame["name"], frame["line"])
+		}
+	}
+
+	c.request("stepIn", map[string]int{"threadId": 1})
+	c.expect("response", "stepIn")
+	c.expect("event", "stopped")
+	c.request("variables", map[string]int{"variablesReference": REGISTERS})
+	vars := c.expect("response", "variables").Body["variables"].([]interface{})
+	if a := vars[0].(map[string]interface{}); a["name"] != "A" || a["value"] != "06H" {
+		t.Errorf("[A] expected: 06H, actual: %v", a)
+	}
+
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x0000", "count": -1})
+	if msg := c.next(); msg.Command != "readMemory" || msg.Success {
+		t.Errorf("[readMemory] expected: negative count refused, actual: %+v", msg)
+	}
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x0000", "count": 3})
+	if m := c.expect("response", "readMemory"); m.Body["data"] != "MQAB" {
+		t.Errorf("[readMemory] expected: MQAB, actual: %v", m.Body)
+	}
+
+	c.request("setVariable", map[string]interface{}{"variablesReference": REGISTERS, "name": "HL", "value": "0x1234"})
+	if v := c.expect("response", "setVariable"); v.Body["value"] != "1234H" {
+		t.Errorf("[HL] expected: 1234H, actual: %v", v.Body)
+	}
+
+	c.request("stepOut", map[string]int{"threadId": 1})
+	c.expect("response", "stepOut")
+	c.expect("event", "stopped")
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	frames = c.expect("response", "stackTrace").Body["stackFrames"].([]interface{})
+	if frame := frames[0].(map[string]interface{}); frame["line"] != 7.0 || len(frames) != 2 {
+		t.Errorf("[step out] expected: 2 frames at line 7, actual: %v", frames)
+	}
+
+	c.request("continue", map[string]int{"threadId": 1})
+	c.expect("response", "continue")
+	if stop := c.expect("event", "stopped"); stop.Body["description"] != "halted" {
+		t.Errorf("[halt] expected: halted, actual: %v", stop.Body)
+	}
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect")
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "loop.asm")
+	if err := ioutil.WriteFile(path, []byte("\tORG\t0\nLOOP:\tINR\tA\n\tJMP\tLOOP\n\tEND\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewSession(inR, outW, nil).Run()
+	}()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("launch", map[string]interface{}{"program": path})
+	c.expect("response", "launch")
+	c.expect("event", "initialized")
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone")
+
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	if msg := c.next(); msg.Command != "stackTrace" || msg.Success {
+		t.Errorf("[stackTrace] expected: refused while running, actual: %+v", msg)
+	}
+
+	// The loop is paused to add the breakpoint, and runs on until it hits it.
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 3}},
+	})
+	c.expect("response", "setBreakpoints")
+	if stop := c.expect("event", "stopped"); stop.Body["reason"] != "breakpoint" {
+		t.Errorf("[stopped] expected: breakpoint, actual: %v", stop.Body)
+	}
+
+	// Terminating stops the running program and ends the session.
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": path}})
+	c.expect("response", "setBreakpoints")
+	c.request("continue", map[string]int{"threadId": 1})
+	c.expect("response", "continue")
+	c.request("terminate", nil)
+	c.expect("response", "terminate")
+	c.expect("event", "terminated")
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLaunchBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "loop.bin")
+	// LOOP: INR A; JMP LOOP
+	if err := ioutil.WriteFile(path, []uint8{0x3C, 0xC3, 0x00, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewSession(inR, outW, nil).Run()
+	}()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR)}
+
+	c.request("launch", map[string]interface{}{"program": path, "org": 0, "stopOnEntry": true})
+	c.expect("response", "launch")
+	c.expect("event", "initialized")
+	c.request("configurationDone", nil)
+	c.expect("response", "configurationDone")
+	c.expect("event", "stopped")
+
+	c.request("variables", map[string]int{"variablesReference": REGISTERS})
+	vars := c.expect("response", "variables").Body["variables"].([]interface{})
+	if pc := vars[len(vars)-1].(map[string]interface{}); pc["name"] != "PC" || pc["value"] != "0000H" {
+		t.Errorf("[PC] expected: 0000H, actual: %v", pc)
+	}
+	c.request("readMemory", map[string]interface{}{"memoryReference": "0x0000", "count": 4})
+	if m := c.expect("response", "readMemory"); m.Body["data"] != "PMMAAA==" {
+		t.Errorf("[org 0] expected: PMMAAA==, actual: %v", m.Body)
+	}
+
+	c.request("disconnect", nil)
+	c.expect("response", "disconnect")
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,390 @@
+// Package debugger wraps a machine's CPU with breakpoints, watchpoints and
+// stepping. A machine hands over its CPU and a step function that executes
+// one instruction, along with whatever interrupts or screen updates the
+// machine performs between instructions.
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/disasm"
+)
+
+const (
+	STEP = iota
+	BREAKPOINT
+	WATCH_READ
+	WATCH_WRITE
+	PORT_IN
+	PORT_OUT
+	HALT
+	PAUSE
+	EXIT
+)
+
+const (
+	READ  = 1
+	WRITE = 2
+
+	IN  = 1
+	OUT = 2
+)
+
+var REASONS = map[int]string{
+	STEP:        "step",
+	BREAKPOINT:  "breakpoint",
+	WATCH_READ:  "read watchpoint",
+	WATCH_WRITE: "write watchpoint",
+	PORT_IN:     "port in",
+	PORT_OUT:    "port out",
+	HALT:        "halted",
+	PAUSE:       "paused",
+	EXIT:        "machine stopped",
+}
+
+// Stop describes why execution stopped. Addr is the address of the
+// breakpoint or watchpoint, Port and Value the port access that stopped it.
+type Stop struct {
+	Reason int
+	Addr   uint16
+	Port   uint8
+	Value  uint8
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case BREAKPOINT:
+		return fmt.Sprintf("%s at %04X", REASONS[s.Reason], s.Addr)
+	case WATCH_READ, WATCH_WRITE:
+		return fmt.Sprintf("%s at %04X (%02X)", REASONS[s.Reason], s.Addr, s.Value)
+	case PORT_IN, PORT_OUT:
+		return fmt.Sprintf("%s %02X (%02X)", REASONS[s.Reason], s.Port, s.Value)
+	}
+	return REASONS[s.Reason]
+}
+
+// Frame is a subroutine call on the shadow call stack: the address of the
+// call, where it went, where it returns to and SP just after the call.
+type Frame struct {
+	Call   uint16
+	Target uint16
+	Return uint16
+	SP     uint16
+}
+
+type Debugger struct {
+	cpu         *i8080.CPU
+	step        func() bool
+	mem         i8080.Memory
+	io          i8080.IODevice
+	breakpoints map[uint16]bool
+	watches     map[uint16]int
+	ports       map[uint8]int
+	tracking    bool
+	fetchStart  uint16
+	fetchLen    int
+	lastOp      uint8
+	hit         *Stop
+	frames      []Frame
+	pause       int32
+}
+
+// New attaches a debugger to cpu. step executes one instruction and returns
+// false once the machine has stopped; a nil step just calls cpu.Execute.
+func New(cpu *i8080.CPU, step func() bool) *Debugger {
+	if step == nil {
+		step = func() bool {
+			cpu.Execute()
+			return true
+		}
+	}
+	d := &Debugger{cpu: cpu, step: step, mem: cpu.GetMemory(), io: cpu.GetIO(),
+		breakpoints: map[uint16]bool{}, watches: map[uint16]int{}, ports: map[uint8]int{}}
+	cpu.SetMemory(&watchMemory{d})
+	cpu.SetIO(&watchIO{d})
+	return d
+}
+
+func (d *Debugger) GetCPU() *i8080.CPU {
+	return d.cpu
+}
+
+func (d *Debugger) AddBreakpoint(addr uint16) {
+	d.breakpoints[addr] = true
+}
+
+func (d *Debugger) RemoveBreakpoint(addr uint16) {
+	delete(d.breakpoints, addr)
+}
+
+func (d *Debugger) Breakpoints() []uint16 {
+	addrs := make([]uint16, 0, len(d.breakpoints))
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Watch stops execution when the CPU reads or writes addr, depending on
+// whether kind has READ, WRITE or both set. A kind of 0 removes the watch.
+func (d *Debugger) Watch(addr uint16, kind int) {
+	if kind == 0 {
+		delete(d.watches, addr)
+		return
+	}
+	d.watches[addr] = kind
+}
+
+func (d *Debugger) Watches() map[uint16]int {
+	return d.watches
+}
+
+// BreakPort stops execution on IN and/or OUT instructions for port. A kind
+// of 0 removes the break.
+func (d *Debugger) BreakPort(port uint8, kind int) {
+	if kind == 0 {
+		delete(d.ports, port)
+		return
+	}
+	d.ports[port] = kind
+}
+
+func (d *Debugger) PortBreaks() map[uint8]int {
+	return d.ports
+}
+
+// Read and Write access memory without triggering watchpoints. Write goes
+// through Load when the memory supports it so that ROM can be patched.
+func (d *Debugger) Read(addr uint16) uint8 {
+	return d.mem.Read(addr)
+}
+
+func (d *Debugger) Write(addr uint16, val uint8) {
+	if l, ok := d.mem.(i8080.Loader); ok {
+		l.Load(addr, []uint8{val})
+		return
+	}
+	d.mem.Write(addr, val)
+}
+
+// Memory returns the machine's memory without the watchpoints.
+func (d *Debugger) Memory() i8080.Memory {
+	return d.mem
+}
+
+// SetRegister sets A, F, B, C, D, E, H, L or one of the pairs AF, BC, DE,
+// HL, SP and PC.
+func (d *Debugger) SetRegister(name string, val uint16) error {
+	reg := d.cpu.GetRegisters()
+	switch strings.ToUpper(name) {
+	case "A":
+		reg.A = uint8(val)
+	case "F":
+		d.cpu.SetAF(uint16(reg.A)<<8 | val&0xFF)
+	case "B":
+		reg.B = uint8(val)
+	case "C":
+		reg.C = uint8(val)
+	case "D":
+		reg.D = uint8(val)
+	case "E":
+		reg.E = uint8(val)
+	case "H":
+		reg.H = uint8(val)
+	case "L":
+		reg.L = uint8(val)
+	case "AF", "PSW":
+		d.cpu.SetAF(val)
+	case "BC":
+		d.cpu.SetBC(val)
+	case "DE":
+		d.cpu.SetDE(val)
+	case "HL":
+		d.cpu.SetHL(val)
+	case "SP":
+		d.cpu.SetSP(val)
+	case "PC":
+		d.cpu.SetPC(val)
+	default:
+		return fmt.Errorf("unknown register %s", name)
+	}
+	return nil
+}
+
+// CallStack returns the calls that have not yet returned, outermost first.
+// Calls are detected by a return address being pushed, so interrupts show
+// up too.
+func (d *Debugger) CallStack() []Frame {
+	return d.frames
+}
+
+// Pause makes a running Continue, StepOver, StepOut or RunTo return. It is
+// safe to call from another goroutine, and a pause that comes before the
+// run has started stops it after its first instruction.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pause, 1)
+}
+
+// Step executes a single instruction.
+func (d *Debugger) Step() Stop {
+	if stop, ok := d.exec(); ok {
+		return stop
+	}
+	return Stop{Reason: STEP, Addr: d.cpu.GetPC()}
+}
+
+// Continue runs until a breakpoint, watchpoint or port break is hit. A
+// breakpoint at the current PC does not stop the first instruction.
+func (d *Debugger) Continue() Stop {
+	return d.run(nil)
+}
+
+// RunTo runs until PC reaches addr, stopping early on breakpoints.
+func (d *Debugger) RunTo(addr uint16) Stop {
+	return d.run(func() bool { return d.cpu.GetPC() == addr })
+}
+
+// StepOver steps over CALL and RST instructions by running until the call
+// returns to the next instruction with the stack back where it was.
+func (d *Debugger) StepOver() Stop {
+	pc, sp := d.cpu.GetPC(), d.cpu.GetSP()
+	inst := disasm.Decode(d.mem, pc)
+	if !isCall(inst.Opcode) {
+		return d.Step()
+	}
+	ret := pc + uint16(inst.Len())
+	return d.run(func() bool { return d.cpu.GetPC() == ret && d.cpu.GetSP() >= sp })
+}
+
+// StepOut runs until the current subroutine returns.
+func (d *Debugger) StepOut() Stop {
+	sp := d.cpu.GetSP()
+	return d.run(func() bool { return isReturn(d.lastOp) && d.cpu.GetSP() > sp })
+}
+
+func (d *Debugger) run(done func() bool) Stop {
+	defer atomic.StoreInt32(&d.pause, 0)
+	for {
+		if stop, ok := d.exec(); ok {
+			return stop
+		}
+		pc := d.cpu.GetPC()
+		if done != nil && done() {
+			return Stop{Reason: STEP, Addr: pc}
+		}
+		if d.breakpoints[pc] {
+			return Stop{Reason: BREAKPOINT, Addr: pc}
+		}
+		if atomic.LoadInt32(&d.pause) != 0 {
+			return Stop{Reason: PAUSE, Addr: pc}
+		}
+	}
+}
+
+// exec runs the machine's step function and reports a stop if a watchpoint
+// or port break was hit or the machine can go no further. Reads of the
+// instruction's own bytes are fetches and do not trigger read watchpoints.
+func (d *Debugger) exec() (Stop, bool) {
+	pc, sp := d.cpu.GetPC(), d.cpu.GetSP()
+	inst := disasm.Decode(d.mem, pc)
+	d.fetchStart, d.fetchLen = pc, inst.Len()
+	d.lastOp = inst.Opcode
+	d.hit = nil
+	d.tracking = true
+	alive := d.step()
+	d.tracking = false
+	d.trackCalls(pc, sp, pc+uint16(inst.Len()))
+
+	pc = d.cpu.GetPC()
+	switch {
+	case !alive:
+		return Stop{Reason: EXIT, Addr: pc}, true
+	case d.hit != nil:
+		return *d.hit, true
+	case d.cpu.IsHalted() && !d.cpu.IsInterrupted():
+		return Stop{Reason: HALT, Addr: pc}, true
+	}
+	return Stop{}, false
+}
+
+func (d *Debugger) trackCalls(pc uint16, sp uint16, ret uint16) {
+	newSP := d.cpu.GetSP()
+	for len(d.frames) > 0 && newSP > d.frames[len(d.frames)-1].SP {
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+	pushed := uint16(d.mem.Read(newSP)) | uint16(d.mem.Read(newSP+1))<<8
+	if newSP == sp-2 && pushed == ret && d.cpu.GetPC() != ret {
+		d.frames = append(d.frames, Frame{Call: pc, Target: d.cpu.GetPC(), Return: ret, SP: newSP})
+	}
+}
+
+func (d *Debugger) record(stop Stop) {
+	if d.hit == nil {
+		d.hit = &stop
+	}
+}
+
+func isCall(op uint8) bool {
+	return op == 0xCD || op == 0xDD || op == 0xED || op == 0xFD || op&0xC7 == 0xC4 || op&0xC7 == 0xC7
+}
+
+func isReturn(op uint8) bool {
+	return op == 0xC9 || op == 0xD9 || op&0xC7 == 0xC0
+}
+
+type watchMemory struct {
+	d *Debugger
+}
+
+func (w *watchMemory) Read(addr uint16) uint8 {
+	d := w.d
+	val := d.mem.Read(addr)
+	if d.tracking && d.watches[addr]&READ != 0 && uint16(addr-d.fetchStart) >= uint16(d.fetchLen) {
+		d.record(Stop{Reason: WATCH_READ, Addr: addr, Value: val})
+	}
+	return val
+}
+
+func (w *watchMemory) Write(addr uint16, val uint8) {
+	d := w.d
+	d.mem.Write(addr, val)
+	if d.tracking && d.watches[addr]&WRITE != 0 {
+		d.record(Stop{Reason: WATCH_WRITE, Addr: addr, Value: val})
+	}
+}
+
+func (w *watchMemory) Load(addr uint16, data []uint8) {
+	if l, ok := w.d.mem.(i8080.Loader); ok {
+		l.Load(addr, data)
+		return
+	}
+	for i, b := range data {
+		w.d.mem.Write(addr+uint16(i), b)
+	}
+}
+
+type watchIO struct {
+	d *Debugger
+}
+
+func (w *watchIO) In(port uint8) uint8 {
+	d := w.d
+	val := d.io.In(port)
+	if d.tracking && d.ports[port]&IN != 0 {
+		d.record(Stop{Reason: PORT_IN, Port: port, Value: val})
+	}
+	return val
+}
+
+func (w *watchIO) Out(port uint8, val uint8) {
+	d := w.d
+	d.io.Out(port, val)
+	if d.tracking && d.ports[port]&OUT != 0 {
+		d.record(Stop{Reason: PORT_OUT, Port: port, Value: val})
+	}
+}
@@ -0,0 +1,95 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/asm"
+)
+
+const PROGRAM = `
+	ORG	0
+	LXI	SP,100H
+	CALL	OUTER
+	OUT	2
+	LDA	VALUE
+	HLT
+OUTER:	MVI	A,5
+	CALL	INNER
+	STA	VALUE
+	RET
+INNER:	INR	A
+	RET
+VALUE:	DB	0
+`
+
+func newTestDebugger(t *testing.T) (*Debugger, *asm.Program) {
+	prog, err := asm.Assemble("test.asm", []uint8(PROGRAM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := i8080.NewCPU(0, nil, nil)
+	prog.LoadInto(cpu)
+	return New(cpu, nil), prog
+}
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	d, prog := newTestDebugger(t)
+	cpu := d.GetCPU()
+
+	d.AddBreakpoint(prog.Symbols["OUTER"])
+	if stop := d.Continue(); stop.Reason != BREAKPOINT || stop.Addr != prog.Symbols["OUTER"] {
+		t.Errorf("[continue] expected: breakpoint at %04X, actual: %s", prog.Symbols["OUTER"], stop)
+	}
+	d.Step()
+	if frames := d.CallStack(); len(frames) != 1 || frames[0].Call != 3 || frames[0].Return != 6 {
+		t.Errorf("[call stack] expected: call at 0003 returning to 0006, actual: %+v", frames)
+	}
+	if stop := d.StepOver(); stop.Reason != STEP || cpu.GetRegisters().A != 6 {
+		t.Errorf("[step over] expected: A=6, actual: %s A=%X", stop, cpu.GetRegisters().A)
+	}
+	if stop := d.StepOut(); stop.Reason != STEP || cpu.GetPC() != 6 {
+		t.Errorf("[step out] expected: 0006, actual: %s at %04X", stop, cpu.GetPC())
+	}
+	if stop := d.Continue(); stop.Reason != HALT {
+		t.Errorf("[halt] expected: halted, actual: %s", stop)
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	d, prog := newTestDebugger(t)
+	value := prog.Symbols["VALUE"]
+
+	d.Watch(value, READ|WRITE)
+	d.BreakPort(2, OUT)
+	expected := []Stop{
+		{Reason: WATCH_WRITE, Addr: value, Value: 6},
+		{Reason: PORT_OUT, Port: 2, Value: 6},
+		{Reason: WATCH_READ, Addr: value, Value: 6},
+		{Reason: HALT, Addr: prog.Symbols["OUTER"]},
+	}
+	for i, exp := range expected {
+		if stop := d.Continue(); stop != exp {
+			t.Errorf("[stop %d] expected: %s, actual: %s", i, exp, stop)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	d, _ := newTestDebugger(t)
+	var out bytes.Buffer
+	cmds := "u 6\nset hl 1234\ne 100 AB CD\nx 100 2\n\nq\n"
+	if err := d.REPL(strings.NewReader(cmds), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"PC: 0006", "HL: 1234", "0100  AB CD"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("[%s] expected in output:\n%s", want, out.String())
+		}
+	}
+	if d.GetCPU().GetHL() != 0x1234 || d.Read(0x101) != 0xCD {
+		t.Errorf("[edit] expected: 1234 CD, actual: %04X %02X", d.GetCPU().GetHL(), d.Read(0x101))
+	}
+}
@@ -0,0 +1,316 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/is386/Go8080/i8080/disasm"
+)
+
+const HELP = `s [n]            step n instructions
+n                step over a call
+o                step out of the current subroutine
+c                continue
+u ADDR           run until PC reaches ADDR
+b [ADDR]         set a breakpoint, or list breakpoints
+d ADDR           delete a breakpoint
+w ADDR [r|w|rw]  watch memory reads and/or writes
+uw ADDR          remove a watchpoint
+p PORT [i|o|io]  break on IN and/or OUT for a port
+up PORT          remove a port break
+r                show registers
+bt               show the call stack
+set REG VAL      set a register (A F B C D E H L AF BC DE HL SP PC)
+x ADDR [n]       dump n bytes of memory
+e ADDR VAL...    write bytes to memory
+l [ADDR] [n]     disassemble n instructions
+q                quit
+Numbers are hexadecimal. An empty line repeats the last command.`
+
+// REPL reads debugger commands from in until it ends or q is entered,
+// writing the results to out.
+func (d *Debugger) REPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	d.printState(out)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "q" || args[0] == "quit" {
+			return nil
+		}
+		if err := d.command(out, args[0], args[1:]); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+func (d *Debugger) command(out io.Writer, cmd string, args []string) error {
+	switch cmd {
+	case "s", "step":
+		n, err := optional(args, 0, 1)
+		if err != nil {
+			return err
+		}
+		stop := Stop{Reason: STEP}
+		for i := 0; i < n && stop.Reason == STEP; i++ {
+			stop = d.Step()
+		}
+		d.report(out, stop)
+	case "n", "next":
+		d.report(out, d.StepOver())
+	case "o", "out":
+		d.report(out, d.StepOut())
+	case "c", "continue":
+		d.report(out, d.Continue())
+	case "u", "until":
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		d.report(out, d.RunTo(uint16(addr)))
+	case "b", "break":
+		if len(args) == 0 {
+			for _, addr := range d.Breakpoints() {
+				fmt.Fprintf(out, "%04X\n", addr)
+			}
+			return nil
+		}
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		d.AddBreakpoint(uint16(addr))
+	case "d", "delete":
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		d.RemoveBreakpoint(uint16(addr))
+	case "w", "watch":
+		if len(args) == 0 {
+			d.listWatches(out)
+			return nil
+		}
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		kind, err := access(args, "r", "w", READ, WRITE)
+		if err != nil {
+			return err
+		}
+		d.Watch(uint16(addr), kind)
+	case "uw", "unwatch":
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		d.Watch(uint16(addr), 0)
+	case "p", "port":
+		if len(args) == 0 {
+			d.listWatches(out)
+			return nil
+		}
+		port, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		kind, err := access(args, "i", "o", IN, OUT)
+		if err != nil {
+			return err
+		}
+		d.BreakPort(uint8(port), kind)
+	case "up", "unport":
+		port, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		d.BreakPort(uint8(port), 0)
+	case "r", "regs":
+		d.printState(out)
+	case "bt", "backtrace":
+		frames := d.CallStack()
+		fmt.Fprintf(out, "#0  %04X\n", d.cpu.GetPC())
+		for i := len(frames) - 1; i >= 0; i-- {
+			f := frames[i]
+			fmt.Fprintf(out, "#%d  %04X  call %04X, returns to %04X\n", len(frames)-i, f.Call, f.Target, f.Return)
+		}
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: set REG VAL")
+		}
+		val, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+		if err := d.SetRegister(args[0], uint16(val)); err != nil {
+			return err
+		}
+		d.printState(out)
+	case "x", "examine":
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		n, err := optional(args, 1, 0x40)
+		if err != nil {
+			return err
+		}
+		d.dump(out, uint16(addr), n)
+	case "e", "edit":
+		addr, err := required(args, 0)
+		if err != nil {
+			return err
+		}
+		for i := 1; i < len(args); i++ {
+			val, err := parseNumber(args[i])
+			if err != nil {
+				return err
+			}
+			d.Write(uint16(addr)+uint16(i-1), uint8(val))
+		}
+	case "l", "list":
+		addr := int(d.cpu.GetPC())
+		var err error
+		if len(args) > 0 {
+			if addr, err = required(args, 0); err != nil {
+				return err
+			}
+		}
+		n, err := optional(args, 1, 10)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			inst := disasm.Decode(d.mem, uint16(addr))
+			d.printInstruction(out, inst)
+			addr += inst.Len()
+		}
+	case "h", "help", "?":
+		fmt.Fprintln(out, HELP)
+	default:
+		return fmt.Errorf("unknown command %s, try h", cmd)
+	}
+	return nil
+}
+
+func (d *Debugger) report(out io.Writer, stop Stop) {
+	if stop.Reason != STEP {
+		fmt.Fprintln(out, stop)
+	}
+	d.printState(out)
+}
+
+func (d *Debugger) printState(out io.Writer) {
+	c := d.cpu
+	fmt.Fprintf(out, "PC: %04X, AF: %04X, BC: %04X, DE: %04X, HL: %04X, SP: %04X, CYC: %d\n",
+		c.GetPC(), c.GetAF(), c.GetBC(), c.GetDE(), c.GetHL(), c.GetSP(), c.GetCycles())
+	d.printInstruction(out, disasm.Decode(d.mem, c.GetPC()))
+}
+
+func (d *Debugger) printInstruction(out io.Writer, inst disasm.Instruction) {
+	raw := make([]string, len(inst.Bytes))
+	for i, b := range inst.Bytes {
+		raw[i] = fmt.Sprintf("%02X", b)
+	}
+	mark := " "
+	if d.breakpoints[inst.Addr] {
+		mark = "*"
+	}
+	fmt.Fprintf(out, "%s%04X  %-9s %s\n", mark, inst.Addr, strings.Join(raw, " "), inst.Intel())
+}
+
+func (d *Debugger) dump(out io.Writer, addr uint16, n int) {
+	for i := 0; i < n; i += 16 {
+		row := make([]string, 0, 16)
+		text := make([]byte, 0, 16)
+		for j := i; j < i+16 && j < n; j++ {
+			b := d.Read(addr + uint16(j))
+			row = append(row, fmt.Sprintf("%02X", b))
+			if b < 0x20 || b > 0x7E {
+				b = '.'
+			}
+			text = append(text, b)
+		}
+		fmt.Fprintf(out, "%04X  %-47s  %s\n", addr+uint16(i), strings.Join(row, " "), text)
+	}
+}
+
+func (d *Debugger) listWatches(out io.Writer) {
+	names := map[int]string{READ: "r", WRITE: "w", READ | WRITE: "rw"}
+	addrs := make([]int, 0, len(d.watches))
+	for addr := range d.watches {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		fmt.Fprintf(out, "watch %04X %s\n", addr, names[d.watches[uint16(addr)]])
+	}
+	ports := make([]int, 0, len(d.ports))
+	for port := range d.ports {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		fmt.Fprintf(out, "port %02X %s\n", port, strings.NewReplacer("r", "i", "w", "o").Replace(names[d.ports[uint8(port)]]))
+	}
+}
+
+// parseNumber reads a hexadecimal number, optionally written with a 0x or $
+// prefix or an H suffix.
+func parseNumber(text string) (int, error) {
+	s := strings.ToUpper(text)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0X"), "$")
+	s = strings.TrimSuffix(s, "H")
+	val, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", text)
+	}
+	return int(val), nil
+}
+
+func required(args []string, i int) (int, error) {
+	if i >= len(args) {
+		return 0, fmt.Errorf("missing address")
+	}
+	return parseNumber(args[i])
+}
+
+func optional(args []string, i int, def int) (int, error) {
+	if i >= len(args) {
+		return def, nil
+	}
+	return parseNumber(args[i])
+}
+
+func access(args []string, r string, w string, rk int, wk int) (int, error) {
+	if len(args) < 2 {
+		return rk | wk, nil
+	}
+	switch args[1] {
+	case r:
+		return rk, nil
+	case w:
+		return wk, nil
+	case r + w:
+		return rk | wk, nil
+	}
+	return 0, fmt.Errorf("invalid access %s", args[1])
+}
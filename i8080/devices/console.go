@@ -0,0 +1,101 @@
+package devices
+
+import (
+	"bufio"
+	"io"
+)
+
+// EOF_POLLS is how many times a program may look for input after the end
+// of it without writing anything before it is taken to be waiting forever.
+// Programs such as BASIC also look for a ^C between statements.
+var EOF_POLLS = 100000
+
+// Console connects a machine's terminal to the host. Input is read in the
+// background, so that programs can poll for it the way they poll a real
+// serial line, and newlines and CR LF are turned into the CR that 8080
+// software expects.
+type Console struct {
+	in      chan uint8
+	out     io.Writer
+	pending uint8
+	ready   bool
+	eof     bool
+	polls   int
+}
+
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	c := &Console{in: make(chan uint8, 256), out: out}
+	go c.fill(bufio.NewReader(in))
+	return c
+}
+
+func (c *Console) fill(r *bufio.Reader) {
+	last := uint8(0)
+	for {
+		ch, err := r.ReadByte()
+		if err != nil {
+			close(c.in)
+			return
+		}
+		if ch == '\n' {
+			if last == '\r' {
+				last = ch
+				continue
+			}
+			ch = '\r'
+		}
+		last = ch
+		c.in <- ch
+	}
+}
+
+// Ready reports whether a character is waiting.
+func (c *Console) Ready() bool {
+	if !c.ready && !c.eof {
+		select {
+		case ch, ok := <-c.in:
+			c.pending, c.ready, c.eof = ch, ok, !ok
+		default:
+		}
+	}
+	if c.eof && !c.ready {
+		c.polls++
+	}
+	return c.ready
+}
+
+// Read returns the waiting character, or 0 when there is none.
+func (c *Console) Read() uint8 {
+	if !c.Ready() {
+		return 0
+	}
+	c.ready = false
+	return c.pending
+}
+
+// Wait waits for a character. It fails at the end of the input.
+func (c *Console) Wait() (uint8, bool) {
+	if c.ready {
+		c.ready = false
+		return c.pending, true
+	}
+	if c.eof {
+		return 0, false
+	}
+	ch, ok := <-c.in
+	c.eof = !ok
+	return ch, ok
+}
+
+// Write writes a character, without the parity bit.
+func (c *Console) Write(ch uint8) {
+	c.polls = 0
+	c.out.Write([]uint8{ch & 0x7F})
+}
+
+// Starved reports whether the input has ended and the program has since
+// looked for more EOF_POLLS times without writing anything, so that it
+// would wait forever.
+func (c *Console) Starved() bool {
+	return c.polls >= EOF_POLLS
+}
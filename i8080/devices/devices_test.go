@@ -0,0 +1,266 @@
+package devices
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+func TestKCS(t *testing.T) {
+	data := make([]uint8, 256)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+	rand.New(rand.NewSource(8080)).Read(data[128:])
+	for _, rate := range []int{KCS_RATE, 22050, 44100} {
+		var wav bytes.Buffer
+		if err := EncodeKCS(&wav, data, rate); err != nil {
+			t.Fatalf("%v", err)
+		}
+		got, err := DecodeKCS(&wav)
+		if err != nil {
+			t.Fatalf("[%d Hz] %v", rate, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("[%d Hz] expected: % X, actual: % X", rate, data, got)
+		}
+	}
+}
+
+func TestKCSNotWAV(t *testing.T) {
+	if _, err := DecodeKCS(bytes.NewReader([]uint8("not a wav file"))); err == nil {
+		t.Errorf("[not a wav] expected an error")
+	}
+}
+
+// wav writes samples between -1 and 1 as a PCM WAV, with the second
+// channel inverted. 8-bit samples are unsigned.
+func wav(samples []float64, rate int, bits int, channels int) []uint8 {
+	var pcm bytes.Buffer
+	for _, v := range samples {
+		for ch := 0; ch < channels; ch++ {
+			if ch == 1 {
+				v = -v
+			}
+			if bits == 8 {
+				pcm.WriteByte(uint8(128 + 127*v))
+			} else {
+				binary.Write(&pcm, binary.LittleEndian, int16(32767*v))
+			}
+		}
+	}
+	var buf bytes.Buffer
+	frame := channels * bits / 8
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+pcm.Len()))
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []interface{}{uint32(16), uint16(1), uint16(channels), uint32(rate), uint32(rate * frame), uint16(frame), uint16(bits)} {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(pcm.Len()))
+	buf.Write(pcm.Bytes())
+	return buf.Bytes()
+}
+
+func TestKCSRecordings(t *testing.T) {
+	data := make([]uint8, 64)
+	rand.New(rand.NewSource(8080)).Read(data)
+	var encoded bytes.Buffer
+	if err := EncodeKCS(&encoded, data, 22050); err != nil {
+		t.Fatalf("%v", err)
+	}
+	// The encoder writes a 44 byte header and 16-bit samples.
+	raw := encoded.Bytes()[44:]
+	clean := make([]float64, len(raw)/2)
+	for i := range clean {
+		clean[i] = float64(int16(binary.LittleEndian.Uint16(raw[2*i:]))) / 32768
+	}
+
+	// A worn tape fades in and out and has hiss and a DC offset.
+	noise := rand.New(rand.NewSource(1))
+	worn := make([]float64, len(clean))
+	for i, v := range clean {
+		gain := 0.45 + 0.3*math.Sin(float64(i)/5000)
+		worn[i] = gain*v + 0.02*(noise.Float64()-0.5) + 0.1
+	}
+
+	tests := []struct {
+		name     string
+		samples  []float64
+		bits     int
+		channels int
+	}{
+		{"8-bit", clean, 8, 1},
+		{"8-bit stereo", clean, 8, 2},
+		{"16-bit stereo", clean, 16, 2},
+		{"worn", worn, 16, 1},
+		{"worn 8-bit", worn, 8, 1},
+	}
+	for _, tt := range tests {
+		got, err := DecodeKCS(bytes.NewReader(wav(tt.samples, 22050, tt.bits, tt.channels)))
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("[%s] expected: % X, actual: % X", tt.name, data, got)
+		}
+	}
+}
+
+// COPY reads the tape on ports 0 and 1 into 1000H until the reader is empty,
+// and punches it again on ports 2 and 3.
+var COPY = []uint8{
+	0x21, 0x00, 0x10, // LXI H,1000H
+	0xDB, 0x00, // LOOP: IN 0
+	0xE6, 0x01, // ANI 1
+	0xCA, 0x12, 0x00, // JZ DONE
+	0xDB, 0x01, // IN 1
+	0x77,       // MOV M,A
+	0xD3, 0x03, // OUT 3
+	0x23,             // INX H
+	0xC3, 0x03, 0x00, // JMP LOOP
+	0x76, // DONE: HLT
+}
+
+func TestTapeCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tape")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	data := []uint8("10 PRINT \"HELLO\"\r\n")
+	in, out := filepath.Join(dir, "in.wav"), filepath.Join(dir, "out.wav")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	EncodeKCS(f, data, KCS_RATE)
+	f.Close()
+
+	reader, err := LoadCassette(in, Status{Port: 0, Ready: 0x01}, 1)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	punch, err := CreateCassette(out, Status{Port: 2, Ready: 0x80, ActiveLow: true}, 3)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ports := i8080.NewPorts()
+	ports.Attach(reader, reader.Ports()...)
+	ports.Attach(punch, punch.Ports()...)
+	if s := ports.In(2); s != 0x7F {
+		t.Errorf("[punch status] expected: 7F, actual: %02X", s)
+	}
+
+	cpu := i8080.NewCPU(0, nil, ports)
+	cpu.Load(0, COPY)
+	for !cpu.IsHalted() {
+		cpu.Execute()
+	}
+	if err := punch.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for i, b := range data {
+		if m := cpu.Read(0x1000 + uint16(i)); m != b {
+			t.Fatalf("[read] byte %d expected: %02X, actual: %02X", i, b, m)
+		}
+	}
+	if reader.Remaining() != 0 || ports.In(1) != 0 {
+		t.Errorf("[read] expected blank tape after the end")
+	}
+
+	f, err = os.Open(out)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	punched, err := DecodeKCS(f)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(punched, data) {
+		t.Errorf("[punch] expected: %q, actual: %q", data, punched)
+	}
+}
+
+func TestPaperTape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tape")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tape.bin")
+	for _, chunk := range []string{"AB", "C"} {
+		p, err := OpenTapePunch(filename, Status{Port: 4, Ready: 0x01}, 5)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		for _, b := range []uint8(chunk) {
+			p.Out(5, b)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	// A reader with active low status, as on many boards.
+	r, err := OpenTapeReader(filename, Status{Port: 6, Ready: 0x01, ActiveLow: true}, 7)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got []uint8
+	for r.In(6)&0x01 == 0 {
+		got = append(got, r.In(7))
+	}
+	if string(got) != "ABC" {
+		t.Errorf("[tape] expected: ABC, actual: %q", got)
+	}
+	r.Rewind()
+	if r.Remaining() != 3 {
+		t.Errorf("[rewind] expected 3 bytes, actual: %d", r.Remaining())
+	}
+}
+
+func TestConsole(t *testing.T) {
+	var out bytes.Buffer
+	c := NewConsole(strings.NewReader("a\r\nb\nc"), &out)
+	var got []uint8
+	for ch, ok := c.Wait(); ok; ch, ok = c.Wait() {
+		got = append(got, ch)
+	}
+	if string(got) != "a\rb\rc" {
+		t.Errorf("[input] expected: %q, actual: %q", "a\rb\rc", got)
+	}
+	c.Write('x' | 0x80)
+	if out.String() != "x" {
+		t.Errorf("[output] expected: %q, actual: %q", "x", out.String())
+	}
+
+	// A program that only polls at the end of the input would wait forever,
+	// but one that is still writing is not.
+	c = NewConsole(strings.NewReader(""), ioutil.Discard)
+	for i := 0; i < 100*EOF_POLLS && !c.Starved(); i++ {
+		if c.Ready() {
+			t.Fatalf("[empty] expected no input")
+		}
+		if i == EOF_POLLS/2 {
+			c.Write('x')
+		}
+	}
+	if !c.Starved() {
+		t.Errorf("[starved] expected the console to be starved")
+	}
+	c.Write('x')
+	if c.Starved() {
+		t.Errorf("[write] expected a write to reset the polls")
+	}
+}
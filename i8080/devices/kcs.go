@@ -0,0 +1,227 @@
+package devices
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// The Kansas City Standard records a 0 bit as four cycles of 1200 Hz and a
+// 1 bit as eight cycles of 2400 Hz, at 300 baud. Each byte is a 0 start bit,
+// eight data bits from the lowest, and two 1 stop bits. The tape starts and
+// ends with the mark tone.
+const (
+	KCS_SPACE  = 1200
+	KCS_MARK   = 2400
+	KCS_BAUD   = 300
+	KCS_RATE   = 9600
+	KCS_LEADER = 2 * KCS_BAUD
+)
+
+// EncodeKCS writes data as a mono 16-bit WAV at rate samples per second,
+// with KCS_LEADER bits of mark tone before and after it.
+func EncodeKCS(w io.Writer, data []uint8, rate int) error {
+	var samples []int16
+	bit := func(one bool) {
+		freq := KCS_SPACE
+		if one {
+			freq = KCS_MARK
+		}
+		n := rate / KCS_BAUD
+		for i := 0; i < n; i++ {
+			samples = append(samples, int16(20000*math.Sin(2*math.Pi*float64(freq*i)/float64(rate))))
+		}
+	}
+	for i := 0; i < KCS_LEADER; i++ {
+		bit(true)
+	}
+	for _, b := range data {
+		bit(false)
+		for i := uint(0); i < 8; i++ {
+			bit(b&(1<<i) != 0)
+		}
+		bit(true)
+		bit(true)
+	}
+	for i := 0; i < KCS_LEADER; i++ {
+		bit(true)
+	}
+
+	var buf bytes.Buffer
+	size := uint32(len(samples) * 2)
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, 36+size)
+	buf.WriteString("WAVEfmt ")
+	for _, field := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(rate * 2), uint16(2), uint16(16)} {
+		binary.Write(&buf, binary.LittleEndian, field)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, size)
+	binary.Write(&buf, binary.LittleEndian, samples)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readWAV returns the samples of the first channel of a PCM WAV, centred on
+// their mean, and the sample rate.
+func readWAV(r io.Reader) ([]float64, int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	var format, channels, bits uint16
+	var rate uint32
+	var pcm []uint8
+	for pos := 12; pos+8 <= len(data); {
+		id, size := string(data[pos:pos+4]), int(binary.LittleEndian.Uint32(data[pos+4:]))
+		body := data[pos+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("invalid WAV format chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:])
+			channels = binary.LittleEndian.Uint16(body[2:])
+			rate = binary.LittleEndian.Uint32(body[4:])
+			bits = binary.LittleEndian.Uint16(body[14:])
+		case "data":
+			pcm = body
+		}
+		pos += 8 + size + size%2
+	}
+	if format != 1 || channels == 0 || (bits != 8 && bits != 16) || rate == 0 {
+		return nil, 0, fmt.Errorf("unsupported WAV: format %d, %d channels, %d bits", format, channels, bits)
+	}
+
+	frame := int(channels) * int(bits) / 8
+	samples := make([]float64, len(pcm)/frame)
+	mean := 0.0
+	for i := range samples {
+		if bits == 8 {
+			samples[i] = float64(pcm[i*frame]) - 128
+		} else {
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*frame:])))
+		}
+		mean += samples[i]
+	}
+	if len(samples) > 0 {
+		mean /= float64(len(samples))
+	}
+	for i := range samples {
+		samples[i] -= mean
+	}
+	return samples, int(rate), nil
+}
+
+// DecodeKCS reads the bytes recorded on a KCS WAV at any sample rate. It
+// times the zero crossings of the signal: a half cycle longer than halfway
+// between the two tones starts a byte, and each bit is then read from the
+// number of crossings in its 1/300 s.
+func DecodeKCS(r io.Reader) ([]uint8, error) {
+	samples, rate, err := readWAV(r)
+	if err != nil {
+		return nil, err
+	}
+	var crossings []float64
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			// Interpolate where the signal passes 0.
+			crossings = append(crossings, float64(i-1)+samples[i-1]/(samples[i-1]-samples[i]))
+		}
+	}
+
+	// Halfway between the half cycles of the two tones.
+	long := float64(rate) * (1.0/KCS_SPACE + 1.0/KCS_MARK) / 4
+	period := float64(rate) / KCS_BAUD
+	// A bit of space has 8 crossings and a bit of mark 16.
+	count := func(from float64, i int) (int, int) {
+		for i < len(crossings) && crossings[i] < from {
+			i++
+		}
+		n := 0
+		for i+n < len(crossings) && crossings[i+n] < from+period {
+			n++
+		}
+		return n, i
+	}
+
+	var data []uint8
+	for i := 0; i+1 < len(crossings); {
+		if crossings[i+1]-crossings[i] <= long {
+			i++
+			continue
+		}
+		start := crossings[i]
+		var b uint8
+		j := i
+		for bit := 1; bit <= 8; bit++ {
+			var n int
+			n, j = count(start+float64(bit)*period, j)
+			if n > 12 {
+				b |= 1 << uint(bit-1)
+			}
+		}
+		data = append(data, b)
+		// Look for the next start bit from the middle of the first stop bit.
+		for i < len(crossings) && crossings[i] < start+9.5*period {
+			i++
+		}
+	}
+	return data, nil
+}
+
+// LoadCassette decodes a KCS recording onto a tape for a TapeReader.
+func LoadCassette(filename string, status Status, data uint8) (*TapeReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tape, err := DecodeKCS(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return NewTapeReader(tape, status, data), nil
+}
+
+// kcsWriter keeps the bytes written to it and records them when it is
+// closed.
+type kcsWriter struct {
+	f    *os.File
+	data []uint8
+}
+
+func (k *kcsWriter) Write(p []uint8) (int, error) {
+	k.data = append(k.data, p...)
+	return len(p), nil
+}
+
+func (k *kcsWriter) Close() error {
+	err := EncodeKCS(k.f, k.data, KCS_RATE)
+	if cerr := k.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CreateCassette returns a TapePunch whose bytes are recorded to a KCS WAV
+// file when it is closed.
+func CreateCassette(filename string, status Status, data uint8) (*TapePunch, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewTapePunch(&kcsWriter{f: f}, status, data), nil
+}
@@ -0,0 +1,131 @@
+// Package devices has generic peripherals that attach to any machine
+// through i8080.Ports.
+package devices
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Status describes how a device shows that it is ready on its status port.
+// The Ready bits are set when it is ready, or clear when ActiveLow is set,
+// and the other bits read as the opposite.
+type Status struct {
+	Port      uint8
+	Ready     uint8
+	ActiveLow bool
+}
+
+func (s Status) value(ready bool) uint8 {
+	val := uint8(0)
+	if ready {
+		val = s.Ready
+	}
+	if s.ActiveLow {
+		val = ^val
+	}
+	return val
+}
+
+// TapeReader is a paper tape reader. Its status shows whether a byte is
+// waiting, and each read of its data port takes the next one. Past the end
+// of the tape it reads blank tape, 0.
+type TapeReader struct {
+	tape   []uint8
+	pos    int
+	status Status
+	data   uint8
+}
+
+func NewTapeReader(tape []uint8, status Status, data uint8) *TapeReader {
+	return &TapeReader{tape: tape, status: status, data: data}
+}
+
+// OpenTapeReader loads a tape from a binary file.
+func OpenTapeReader(filename string, status Status, data uint8) (*TapeReader, error) {
+	tape, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewTapeReader(tape, status, data), nil
+}
+
+// Ports returns the ports to attach the reader to.
+func (t *TapeReader) Ports() []uint8 {
+	return []uint8{t.status.Port, t.data}
+}
+
+func (t *TapeReader) In(port uint8) uint8 {
+	ready := t.pos < len(t.tape)
+	if port == t.status.Port && port != t.data {
+		return t.status.value(ready)
+	}
+	if !ready {
+		return 0
+	}
+	t.pos++
+	return t.tape[t.pos-1]
+}
+
+func (t *TapeReader) Out(port uint8, val uint8) {}
+
+// Rewind moves the tape back to its start.
+func (t *TapeReader) Rewind() {
+	t.pos = 0
+}
+
+// Remaining returns the number of bytes left to read.
+func (t *TapeReader) Remaining() int {
+	return len(t.tape) - t.pos
+}
+
+// TapePunch is a paper tape punch that writes each byte sent to its data
+// port. It is always ready.
+type TapePunch struct {
+	w      io.Writer
+	status Status
+	data   uint8
+	err    error
+}
+
+func NewTapePunch(w io.Writer, status Status, data uint8) *TapePunch {
+	return &TapePunch{w: w, status: status, data: data}
+}
+
+// OpenTapePunch appends punched bytes to a file, creating it if needed.
+func OpenTapePunch(filename string, status Status, data uint8) (*TapePunch, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewTapePunch(f, status, data), nil
+}
+
+func (p *TapePunch) Ports() []uint8 {
+	return []uint8{p.status.Port, p.data}
+}
+
+func (p *TapePunch) In(port uint8) uint8 {
+	if port == p.status.Port {
+		return p.status.value(true)
+	}
+	return 0xFF
+}
+
+func (p *TapePunch) Out(port uint8, val uint8) {
+	if port != p.data || p.err != nil {
+		return
+	}
+	_, p.err = p.w.Write([]uint8{val})
+}
+
+// Close closes the punch's file, and returns the first error punching.
+func (p *TapePunch) Close() error {
+	if c, ok := p.w.(io.Closer); ok {
+		if err := c.Close(); p.err == nil {
+			p.err = err
+		}
+	}
+	return p.err
+}
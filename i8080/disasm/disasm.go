@@ -0,0 +1,221 @@
+// Package disasm decodes Intel 8080 machine code into Intel or Zilog
+// style mnemonics.
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+var (
+	REGS   = [8]string{"B", "C", "D", "E", "H", "L", "M", "A"}
+	PAIRS  = [4]string{"B", "D", "H", "SP"}
+	CONDS  = [8]string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	ZREGS  = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	ZPAIRS = [4]string{"BC", "DE", "HL", "SP"}
+)
+
+type opcode struct {
+	intel, zilog string
+	length       int
+	undocumented bool
+	conditional  bool
+}
+
+var opcodes [256]opcode
+
+type Instruction struct {
+	Addr         uint16
+	Opcode       uint8
+	Bytes        []uint8
+	Cycles       int
+	CyclesTaken  int
+	Undocumented bool
+}
+
+func init() {
+	set := func(op uint8, intel string, zilog string) {
+		length := 1
+		if strings.Contains(intel, "%b") {
+			length = 2
+		} else if strings.Contains(intel, "%w") {
+			length = 3
+		}
+		opcodes[op] = opcode{intel: intel, zilog: zilog, length: length}
+	}
+	alias := func(op uint8, of uint8) {
+		o := opcodes[of]
+		o.intel = "*" + o.intel
+		o.zilog = "*" + o.zilog
+		o.undocumented = true
+		opcodes[op] = o
+	}
+
+	set(0x00, "NOP", "NOP")
+	for p := uint8(0); p < 4; p++ {
+		set(0x01|p<<4, "LXI "+PAIRS[p]+",%w", "LD "+ZPAIRS[p]+",%w")
+		set(0x03|p<<4, "INX "+PAIRS[p], "INC "+ZPAIRS[p])
+		set(0x09|p<<4, "DAD "+PAIRS[p], "ADD HL,"+ZPAIRS[p])
+		set(0x0B|p<<4, "DCX "+PAIRS[p], "DEC "+ZPAIRS[p])
+	}
+	set(0x02, "STAX B", "LD (BC),A")
+	set(0x12, "STAX D", "LD (DE),A")
+	set(0x22, "SHLD %w", "LD (%w),HL")
+	set(0x32, "STA %w", "LD (%w),A")
+	set(0x0A, "LDAX B", "LD A,(BC)")
+	set(0x1A, "LDAX D", "LD A,(DE)")
+	set(0x2A, "LHLD %w", "LD HL,(%w)")
+	set(0x3A, "LDA %w", "LD A,(%w)")
+	for r := uint8(0); r < 8; r++ {
+		set(0x04|r<<3, "INR "+REGS[r], "INC "+ZREGS[r])
+		set(0x05|r<<3, "DCR "+REGS[r], "DEC "+ZREGS[r])
+		set(0x06|r<<3, "MVI "+REGS[r]+",%b", "LD "+ZREGS[r]+",%b")
+	}
+	set(0x07, "RLC", "RLCA")
+	set(0x0F, "RRC", "RRCA")
+	set(0x17, "RAL", "RLA")
+	set(0x1F, "RAR", "RRA")
+	set(0x27, "DAA", "DAA")
+	set(0x2F, "CMA", "CPL")
+	set(0x37, "STC", "SCF")
+	set(0x3F, "CMC", "CCF")
+
+	for d := uint8(0); d < 8; d++ {
+		for s := uint8(0); s < 8; s++ {
+			set(0x40|d<<3|s, "MOV "+REGS[d]+","+REGS[s], "LD "+ZREGS[d]+","+ZREGS[s])
+		}
+	}
+	set(0x76, "HLT", "HALT")
+
+	alu := [8][2]string{
+		{"ADD ", "ADD A,"}, {"ADC ", "ADC A,"}, {"SUB ", "SUB "}, {"SBB ", "SBC A,"},
+		{"ANA ", "AND "}, {"XRA ", "XOR "}, {"ORA ", "OR "}, {"CMP ", "CP "},
+	}
+	imm := [8]string{"ADI", "ACI", "SUI", "SBI", "ANI", "XRI", "ORI", "CPI"}
+	for op := uint8(0); op < 8; op++ {
+		for r := uint8(0); r < 8; r++ {
+			set(0x80|op<<3|r, alu[op][0]+REGS[r], alu[op][1]+ZREGS[r])
+		}
+		set(0xC6|op<<3, imm[op]+" %b", alu[op][1]+"%b")
+	}
+
+	for cc := uint8(0); cc < 8; cc++ {
+		set(0xC0|cc<<3, "R"+CONDS[cc], "RET "+CONDS[cc])
+		set(0xC2|cc<<3, "J"+CONDS[cc]+" %w", "JP "+CONDS[cc]+",%w")
+		set(0xC4|cc<<3, "C"+CONDS[cc]+" %w", "CALL "+CONDS[cc]+",%w")
+		set(0xC7|cc<<3, fmt.Sprintf("RST %d", cc), "RST "+hex(uint16(cc)*8, 2))
+		opcodes[0xC0|cc<<3].conditional = true
+		opcodes[0xC4|cc<<3].conditional = true
+	}
+	set(0xC1, "POP B", "POP BC")
+	set(0xD1, "POP D", "POP DE")
+	set(0xE1, "POP H", "POP HL")
+	set(0xF1, "POP PSW", "POP AF")
+	set(0xC5, "PUSH B", "PUSH BC")
+	set(0xD5, "PUSH D", "PUSH DE")
+	set(0xE5, "PUSH H", "PUSH HL")
+	set(0xF5, "PUSH PSW", "PUSH AF")
+	set(0xC3, "JMP %w", "JP %w")
+	set(0xC9, "RET", "RET")
+	set(0xCD, "CALL %w", "CALL %w")
+	set(0xD3, "OUT %b", "OUT (%b),A")
+	set(0xDB, "IN %b", "IN A,(%b)")
+	set(0xE3, "XTHL", "EX (SP),HL")
+	set(0xE9, "PCHL", "JP (HL)")
+	set(0xEB, "XCHG", "EX DE,HL")
+	set(0xF3, "DI", "DI")
+	set(0xF9, "SPHL", "LD SP,HL")
+	set(0xFB, "EI", "EI")
+
+	for _, op := range []uint8{0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38} {
+		alias(op, 0x00)
+	}
+	alias(0xCB, 0xC3)
+	alias(0xD9, 0xC9)
+	alias(0xDD, 0xCD)
+	alias(0xED, 0xCD)
+	alias(0xFD, 0xCD)
+}
+
+func hex(val uint16, digits int) string {
+	s := fmt.Sprintf("%0*XH", digits, val)
+	if s[0] > '9' {
+		s = "0" + s
+	}
+	return s
+}
+
+func Decode(mem i8080.Memory, addr uint16) Instruction {
+	op := mem.Read(addr)
+	o := opcodes[op]
+	inst := Instruction{Addr: addr, Opcode: op, Undocumented: o.undocumented,
+		Cycles: i8080.CYCLES[op], CyclesTaken: i8080.CYCLES[op]}
+	for i := 0; i < o.length; i++ {
+		inst.Bytes = append(inst.Bytes, mem.Read(addr+uint16(i)))
+	}
+	if o.conditional {
+		inst.CyclesTaken += 6
+	}
+	return inst
+}
+
+// Range disassembles from start up to and including end.
+func Range(mem i8080.Memory, start uint16, end uint16) []Instruction {
+	var insts []Instruction
+	for addr := uint32(start); addr <= uint32(end); {
+		inst := Decode(mem, uint16(addr))
+		insts = append(insts, inst)
+		addr += uint32(inst.Len())
+	}
+	return insts
+}
+
+func (i Instruction) Len() int {
+	return len(i.Bytes)
+}
+
+func (i Instruction) Operand() uint16 {
+	switch len(i.Bytes) {
+	case 2:
+		return uint16(i.Bytes[1])
+	case 3:
+		return uint16(i.Bytes[2])<<8 | uint16(i.Bytes[1])
+	}
+	return 0
+}
+
+func (i Instruction) format(tmpl string) string {
+	switch len(i.Bytes) {
+	case 2:
+		return strings.Replace(tmpl, "%b", hex(i.Operand(), 2), 1)
+	case 3:
+		return strings.Replace(tmpl, "%w", hex(i.Operand(), 4), 1)
+	}
+	return tmpl
+}
+
+func (i Instruction) Mnemonic() string {
+	return strings.Fields(opcodes[i.Opcode].intel)[0]
+}
+
+func (i Instruction) Operands() string {
+	parts := strings.SplitN(i.Intel(), " ", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+func (i Instruction) Intel() string {
+	return i.format(opcodes[i.Opcode].intel)
+}
+
+func (i Instruction) Zilog() string {
+	return i.format(opcodes[i.Opcode].zilog)
+}
+
+func (i Instruction) String() string {
+	return i.Intel()
+}
@@ -0,0 +1,61 @@
+package disasm
+
+import (
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		code         []uint8
+		intel, zilog string
+		length       int
+		cycles       int
+		taken        int
+		undocumented bool
+	}{
+		{[]uint8{0x00}, "NOP", "NOP", 1, 4, 4, false},
+		{[]uint8{0x3e, 0xff}, "MVI A,0FFH", "LD A,0FFH", 2, 7, 7, false},
+		{[]uint8{0x21, 0x34, 0x12}, "LXI H,1234H", "LD HL,1234H", 3, 10, 10, false},
+		{[]uint8{0x7e}, "MOV A,M", "LD A,(HL)", 1, 7, 7, false},
+		{[]uint8{0x9e}, "SBB M", "SBC A,(HL)", 1, 7, 7, false},
+		{[]uint8{0xc4, 0x00, 0xe0}, "CNZ 0E000H", "CALL NZ,0E000H", 3, 11, 17, false},
+		{[]uint8{0xd8}, "RC", "RET C", 1, 5, 11, false},
+		{[]uint8{0xef}, "RST 5", "RST 28H", 1, 11, 11, false},
+		{[]uint8{0xd3, 0x01}, "OUT 01H", "OUT (01H),A", 2, 10, 10, false},
+		{[]uint8{0x08}, "*NOP", "*NOP", 1, 4, 4, true},
+		{[]uint8{0xcb, 0x00, 0x01}, "*JMP 0100H", "*JP 0100H", 3, 10, 10, true},
+		{[]uint8{0xd9}, "*RET", "*RET", 1, 10, 10, true},
+		{[]uint8{0xfd, 0x05, 0x00}, "*CALL 0005H", "*CALL 0005H", 3, 17, 17, true},
+	}
+	for _, test := range tests {
+		mem := i8080.NewFlatMemory(0, 64*1024)
+		mem.Load(0x100, test.code)
+		inst := Decode(mem, 0x100)
+		if inst.Intel() != test.intel || inst.Zilog() != test.zilog {
+			t.Errorf("[mnemonic] expected: %s / %s, actual: %s / %s", test.intel, test.zilog, inst.Intel(), inst.Zilog())
+		}
+		if inst.Len() != test.length {
+			t.Errorf("[%s length] expected: %d, actual: %d", test.intel, test.length, inst.Len())
+		}
+		if inst.Cycles != test.cycles || inst.CyclesTaken != test.taken {
+			t.Errorf("[%s cycles] expected: %d/%d, actual: %d/%d", test.intel, test.cycles, test.taken, inst.Cycles, inst.CyclesTaken)
+		}
+		if inst.Undocumented != test.undocumented {
+			t.Errorf("[%s undocumented] expected: %v, actual: %v", test.intel, test.undocumented, inst.Undocumented)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	mem := i8080.NewFlatMemory(0, 64*1024)
+	mem.Load(0xfffc, []uint8{0x3e, 0x01, 0x00, 0xc9})
+	insts := Range(mem, 0xfffc, 0xffff)
+	if len(insts) != 3 {
+		t.Fatalf("[count] expected: %d, actual: %d", 3, len(insts))
+	}
+	if insts[2].Addr != 0xffff || insts[2].Intel() != "RET" {
+		t.Errorf("[last] expected: FFFF RET, actual: %04X %s", insts[2].Addr, insts[2].Intel())
+	}
+}
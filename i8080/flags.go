@@ -1,9 +1,34 @@
 package i8080
 
-type Flags struct {
-	Z  uint8
-	S  uint8
-	P  uint8
-	CY uint8
-	AC uint8
+// Flags is the packed PSW flags byte, laid out as S Z 0 AC 0 P 1 CY.
+type Flags uint8
+
+const (
+	FLAG_CY Flags = 1 << 0
+	FLAG_P  Flags = 1 << 2
+	FLAG_AC Flags = 1 << 4
+	FLAG_Z  Flags = 1 << 6
+	FLAG_S  Flags = 1 << 7
+
+	// FLAG_FIXED is bit 1, which always reads as 1. Bits 3 and 5 always
+	// read as 0.
+	FLAG_FIXED Flags = 1 << 1
+	FLAG_MASK        = FLAG_S | FLAG_Z | FLAG_AC | FLAG_P | FLAG_CY
+)
+
+// bit returns 1 if flag is set and 0 otherwise.
+func (f Flags) bit(flag Flags) uint8 {
+	if f&flag != 0 {
+		return 1
+	}
+	return 0
+}
+
+// set sets flag when val is non-zero and clears it otherwise.
+func (f *Flags) set(flag Flags, val uint8) {
+	if val != 0 {
+		*f |= flag
+	} else {
+		*f &^= flag
+	}
 }
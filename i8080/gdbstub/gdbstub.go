@@ -0,0 +1,356 @@
+// Package gdbstub serves the GDB remote serial protocol for a machine
+// attached to a debugger.Debugger. The 8080 has no register layout in GDB,
+// so g and G packets carry AF, BC, DE, HL, SP and PC as six little-endian
+// 16-bit values, in that order.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/is386/Go8080/i8080/debugger"
+)
+
+var (
+	REGISTERS   = []string{"AF", "BC", "DE", "HL", "SP", "PC"}
+	PACKET_SIZE = 0x1000
+)
+
+// packet is a packet as received, which is dropped if its checksum is
+// wrong.
+type packet struct {
+	data  string
+	valid bool
+}
+
+type Stub struct {
+	d     *debugger.Debugger
+	noAck bool
+	stop  debugger.Stop
+}
+
+func New(d *debugger.Debugger) *Stub {
+	return &Stub{d: d, stop: debugger.Stop{Reason: debugger.PAUSE}}
+}
+
+// ListenAndServe waits for GDB on a TCP address such as "localhost:1234"
+// and serves one connection at a time until one of them kills the machine.
+func (s *Stub) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		killed, err := s.Serve(conn)
+		conn.Close()
+		if killed || err != nil {
+			return err
+		}
+	}
+}
+
+// Serve handles packets from conn until the client detaches, kills the
+// machine or disconnects. It reports whether the machine was killed.
+func (s *Stub) Serve(conn io.ReadWriter) (bool, error) {
+	s.noAck = false
+	packets := make(chan packet)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go s.read(conn, packets, errs, done)
+
+	w := bufio.NewWriter(conn)
+	for {
+		var p packet
+		select {
+		case p = <-packets:
+		case err := <-errs:
+			if err == io.EOF {
+				err = nil
+			}
+			return false, err
+		}
+		if !p.valid && !s.noAck {
+			w.WriteString("-")
+			if err := w.Flush(); err != nil {
+				return false, err
+			}
+			continue
+		}
+		pkt := p.data
+		if !s.noAck {
+			w.WriteString("+")
+		}
+		if pkt == "k" {
+			return true, w.Flush()
+		}
+		writePacket(w, s.handle(pkt))
+		if err := w.Flush(); err != nil {
+			return false, err
+		}
+		if pkt == "D" {
+			return false, nil
+		}
+	}
+}
+
+// read splits the byte stream into packets. A ^C pauses the machine
+// straight away since the main loop may be busy running it.
+func (s *Stub) read(conn io.Reader, packets chan<- packet, errs chan<- error, done <-chan struct{}) {
+	r := bufio.NewReader(conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			errs <- err
+			return
+		}
+		switch b {
+		case 0x03:
+			s.d.Pause()
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				errs <- err
+				return
+			}
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				errs <- err
+				return
+			}
+			data = data[:len(data)-1]
+			want, err := strconv.ParseUint(string(sum), 16, 8)
+			p := packet{data: unescape(data), valid: err == nil && uint8(want) == checksum(data)}
+			select {
+			case packets <- p:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+func (s *Stub) handle(pkt string) string {
+	if pkt == "" {
+		return ""
+	}
+	args := pkt[1:]
+	switch pkt[0] {
+	case '?':
+		return s.stopReply()
+	case 'g':
+		var sb strings.Builder
+		for _, val := range s.registers() {
+			fmt.Fprintf(&sb, "%02x%02x", uint8(val), uint8(val>>8))
+		}
+		return sb.String()
+	case 'G':
+		data, err := hex.DecodeString(args)
+		if err != nil || len(data) < 2*len(REGISTERS) {
+			return "E01"
+		}
+		for i, name := range REGISTERS {
+			s.d.SetRegister(name, uint16(data[2*i])|uint16(data[2*i+1])<<8)
+		}
+		return "OK"
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || int(n) >= len(REGISTERS) {
+			return "E01"
+		}
+		val := s.registers()[n]
+		return fmt.Sprintf("%02x%02x", uint8(val), uint8(val>>8))
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || int(n) >= len(REGISTERS) || len(parts) != 2 {
+			return "E01"
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != 2 {
+			return "E01"
+		}
+		s.d.SetRegister(REGISTERS[n], uint16(data[0])|uint16(data[1])<<8)
+		return "OK"
+	case 'm':
+		addr, n, err := parseRange(args)
+		if err != nil {
+			return "E01"
+		}
+		data := make([]uint8, n)
+		for i := range data {
+			data[i] = s.d.Read(addr + uint16(i))
+		}
+		return hex.EncodeToString(data)
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, n, err := parseRange(parts[0])
+		if err != nil || len(parts) != 2 {
+			return "E01"
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != n {
+			return "E01"
+		}
+		for i, b := range data {
+			s.d.Write(addr+uint16(i), b)
+		}
+		return "OK"
+	case 'Z', 'z':
+		return s.breakpoint(pkt[0] == 'Z', args)
+	case 's', 'c':
+		if args != "" {
+			addr, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01"
+			}
+			s.d.GetCPU().SetPC(uint16(addr))
+		}
+		if pkt[0] == 's' {
+			s.stop = s.d.Step()
+		} else {
+			s.stop = s.d.Continue()
+		}
+		return s.stopReply()
+	case 'D':
+		return "OK"
+	case 'H':
+		return "OK"
+	case 'q':
+		switch {
+		case strings.HasPrefix(args, "Supported"):
+			return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+;swbreak+", PACKET_SIZE)
+		case args == "Attached":
+			return "1"
+		case args == "C":
+			return "QC1"
+		case args == "fThreadInfo":
+			return "m1"
+		case args == "sThreadInfo":
+			return "l"
+		}
+	case 'Q':
+		if args == "StartNoAckMode" {
+			s.noAck = true
+			return "OK"
+		}
+	}
+	return ""
+}
+
+func (s *Stub) registers() []uint16 {
+	c := s.d.GetCPU()
+	return []uint16{c.GetAF(), c.GetBC(), c.GetDE(), c.GetHL(), c.GetSP(), c.GetPC()}
+}
+
+// breakpoint handles Z and z packets. Type 0 and 1 are execution
+// breakpoints, 2 is a write watchpoint, 3 read and 4 access.
+func (s *Stub) breakpoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	addr, n, err := parseRange(parts[1] + "," + parts[2])
+	if err != nil {
+		return "E01"
+	}
+	kind := 0
+	switch parts[0] {
+	case "0", "1":
+		if insert {
+			s.d.AddBreakpoint(addr)
+		} else {
+			s.d.RemoveBreakpoint(addr)
+		}
+		return "OK"
+	case "2":
+		kind = debugger.WRITE
+	case "3":
+		kind = debugger.READ
+	case "4":
+		kind = debugger.READ | debugger.WRITE
+	default:
+		return ""
+	}
+	for i := 0; i < n || i == 0; i++ {
+		a := addr + uint16(i)
+		if insert {
+			s.d.Watch(a, s.d.Watches()[a]|kind)
+		} else {
+			s.d.Watch(a, s.d.Watches()[a]&^kind)
+		}
+	}
+	return "OK"
+}
+
+func (s *Stub) stopReply() string {
+	switch s.stop.Reason {
+	case debugger.EXIT:
+		return "W00"
+	case debugger.PAUSE:
+		return "S02"
+	case debugger.WATCH_WRITE:
+		return fmt.Sprintf("T05watch:%04x;", s.stop.Addr)
+	case debugger.WATCH_READ:
+		return fmt.Sprintf("T05rwatch:%04x;", s.stop.Addr)
+	case debugger.BREAKPOINT:
+		return "T05swbreak:;"
+	}
+	return "S05"
+}
+
+func parseRange(text string) (uint16, int, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range %s", text)
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 32)
+	// Each byte is sent as two hex digits, inside the $ and #xx.
+	if err != nil || n > uint64((PACKET_SIZE-4)/2) {
+		return 0, 0, fmt.Errorf("invalid length %s", parts[1])
+	}
+	return uint16(addr), int(n), nil
+}
+
+func checksum(data string) uint8 {
+	sum := uint8(0)
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func writePacket(w io.Writer, data string) {
+	fmt.Fprintf(w, "$%s#%02x", data, checksum(data))
+}
+
+// unescape undoes the '}' escaping used for binary data in packets.
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			sb.WriteByte(data[i] ^ 0x20)
+			continue
+		}
+		sb.WriteByte(data[i])
+	}
+	return sb.String()
+}
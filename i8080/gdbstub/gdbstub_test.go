@@ -0,0 +1,121 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
+)
+
+// The program counts B down from 3, storing each value at 0100H.
+var PROGRAM = []uint8{
+	0x31, 0x00, 0x02, // LXI SP,0200H
+	0x06, 0x03, // MVI B,3
+	0x78,             // LOOP: MOV A,B
+	0x32, 0x00, 0x01, // STA 0100H
+	0x05,             // DCR B
+	0xC2, 0x05, 0x00, // JNZ LOOP
+	0x76, // HLT
+}
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) send(pkt string) string {
+	writePacket(c.conn, pkt)
+	if pkt == "k" {
+		if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+			c.t.Fatalf("[k] expected: +, actual: %q %v", ack, err)
+		}
+		return ""
+	}
+	ack, err := c.r.ReadString('$')
+	if err != nil || ack != "+$" {
+		c.t.Fatalf("[%s] expected: +$, actual: %q %v", pkt, ack, err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	sum := make([]byte, 2)
+	if _, err := c.r.Read(sum); err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+	var check strings.Builder
+	writePacket(&check, data)
+	if check.String() != "$"+data+"#"+string(sum) {
+		c.t.Errorf("[%s] bad checksum %s for %q", pkt, sum, data)
+	}
+	return data
+}
+
+func TestStub(t *testing.T) {
+	cpu := i8080.NewCPU(0, nil, nil)
+	cpu.Load(0, PROGRAM)
+	stub := New(debugger.New(cpu, nil))
+
+	server, conn := net.Pipe()
+	killed := make(chan bool)
+	go func() {
+		k, _ := stub.Serve(server)
+		killed <- k
+	}()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	tests := []struct {
+		pkt, reply string
+	}{
+		{"qSupported:swbreak+", fmt.Sprintf("PacketSize=%x;QStartNoAckMode+;swbreak+", PACKET_SIZE)},
+		{"?", "S02"},
+		{"Z0,5,1", "OK"},
+		{"c", "T05swbreak:;"},
+		{"g", "0200" + "0003" + "0000" + "0000" + "0002" + "0500"},
+		{"z0,5,1", "OK"},
+		{"Z2,100,1", "OK"},
+		{"c", "T05watch:0100;"},
+		{"m100,1", "03"},
+		{"s", "S05"},
+		{"p1", "0002"},
+		{"P1=0100", "OK"},
+		{"z2,100,1", "OK"},
+		{"c", "S05"},
+		{"g", "5601" + "0100" + "0000" + "0000" + "0002" + "0e00"},
+		{"M100,2:abcd", "OK"},
+		{"m100,2", "abcd"},
+		{"G" + "0000" + "3412" + "0000" + "0000" + "0002" + "0000", "OK"},
+		{"p5", "0000"},
+		{"p1", "3412"},
+		{"vMustReplyEmpty", ""},
+	}
+	for _, test := range tests {
+		if reply := c.send(test.pkt); reply != test.reply {
+			t.Errorf("[%s] expected: %s, actual: %s", test.pkt, test.reply, reply)
+		}
+	}
+
+	// A corrupt packet is refused and dropped, and GDB sends it again.
+	fmt.Fprintf(conn, "$m100,2#00")
+	if nak, err := c.r.ReadByte(); err != nil || nak != '-' {
+		t.Errorf("[bad checksum] expected: -, actual: %q %v", nak, err)
+	}
+	if reply := c.send("m100,2"); reply != "abcd" {
+		t.Errorf("[resend] expected: abcd, actual: %s", reply)
+	}
+	if reply := c.send(fmt.Sprintf("m0,%x", PACKET_SIZE)); reply != "E01" {
+		t.Errorf("[too long] expected: E01, actual: %s", reply)
+	}
+
+	c.send("k")
+	if !<-killed {
+		t.Errorf("[k] expected: killed")
+	}
+	conn.Close()
+}
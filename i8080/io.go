@@ -0,0 +1,47 @@
+package i8080
+
+type IODevice interface {
+	In(port uint8) uint8
+	Out(port uint8, val uint8)
+}
+
+// Ports dispatches each port to the device attached to it, so several
+// peripherals can share one machine. Unattached ports read as 0xFF.
+type Ports struct {
+	in  [256]IODevice
+	out [256]IODevice
+}
+
+func NewPorts() *Ports {
+	return &Ports{}
+}
+
+func (p *Ports) Attach(dev IODevice, ports ...uint8) {
+	p.AttachIn(dev, ports...)
+	p.AttachOut(dev, ports...)
+}
+
+func (p *Ports) AttachIn(dev IODevice, ports ...uint8) {
+	for _, port := range ports {
+		p.in[port] = dev
+	}
+}
+
+func (p *Ports) AttachOut(dev IODevice, ports ...uint8) {
+	for _, port := range ports {
+		p.out[port] = dev
+	}
+}
+
+func (p *Ports) In(port uint8) uint8 {
+	if dev := p.in[port]; dev != nil {
+		return dev.In(port)
+	}
+	return 0xFF
+}
+
+func (p *Ports) Out(port uint8, val uint8) {
+	if dev := p.out[port]; dev != nil {
+		dev.Out(port, val)
+	}
+}
@@ -0,0 +1,188 @@
+package i8080
+
+type Memory interface {
+	Read(addr uint16) uint8
+	Write(addr uint16, val uint8)
+}
+
+// Aliaser is implemented by memories that mirror the same storage at more
+// than one address.
+type Aliaser interface {
+	// Aliases appends every address that reaches the same byte as addr,
+	// including addr, to aliases, or nothing when it is not mirrored.
+	Aliases(addr uint16, aliases []uint16) []uint16
+}
+
+// Loader is implemented by memories that can be filled regardless of
+// their write protection, such as when a ROM image is loaded.
+type Loader interface {
+	Load(addr uint16, data []uint8)
+}
+
+type FlatMemory struct {
+	mem            [64 * 1024]uint8
+	romMax, ramMax uint32
+}
+
+func NewFlatMemory(romMax uint32, ramMax uint32) *FlatMemory {
+	return &FlatMemory{romMax: romMax, ramMax: ramMax}
+}
+
+func (m *FlatMemory) Read(addr uint16) uint8 {
+	return m.mem[addr]
+}
+
+func (m *FlatMemory) Write(addr uint16, val uint8) {
+	if uint32(addr) >= m.romMax && uint32(addr) < m.ramMax {
+		m.mem[addr] = val
+	}
+}
+
+func (m *FlatMemory) Load(addr uint16, data []uint8) {
+	for i := 0; i < len(data); i++ {
+		m.mem[addr+uint16(i)] = data[i]
+	}
+}
+
+type RAM []uint8
+
+func NewRAM(size int) RAM {
+	return make(RAM, size)
+}
+
+func (r RAM) Read(addr uint16) uint8 {
+	return r[int(addr)%len(r)]
+}
+
+func (r RAM) Write(addr uint16, val uint8) {
+	r[int(addr)%len(r)] = val
+}
+
+type ROM []uint8
+
+func (r ROM) Read(addr uint16) uint8 {
+	return r[int(addr)%len(r)]
+}
+
+func (r ROM) Write(addr uint16, val uint8) {
+}
+
+func (r ROM) Load(addr uint16, data []uint8) {
+	for i := 0; i < len(data); i++ {
+		r[(int(addr)+i)%len(r)] = data[i]
+	}
+}
+
+type region struct {
+	start, end uint32
+	mem        Memory
+	storage    *uint8
+	size       uint32
+	mirror     int
+}
+
+// span is one place that a RAM or ROM appears on the bus, in regions[region].
+type span struct {
+	start, end uint32
+	region     int
+}
+
+// Bus maps address ranges onto other memories. Each region sees addresses
+// relative to its own start, so mapping the same RAM or ROM twice mirrors
+// it. Unmapped reads float high and unmapped writes are dropped.
+type Bus struct {
+	regions []region
+	mirrors [][]span
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Map(start uint16, size uint32, mem Memory) {
+	r := region{start: uint32(start), end: uint32(start) + size, mem: mem, mirror: -1}
+	switch m := mem.(type) {
+	case RAM:
+		if len(m) > 0 {
+			r.storage, r.size = &m[0], uint32(len(m))
+		}
+	case ROM:
+		if len(m) > 0 {
+			r.storage, r.size = &m[0], uint32(len(m))
+		}
+	}
+	if r.storage != nil {
+		for i := range b.regions {
+			if b.regions[i].storage == r.storage {
+				r.mirror = b.regions[i].mirror
+			}
+		}
+		if r.mirror < 0 {
+			r.mirror = len(b.mirrors)
+			b.mirrors = append(b.mirrors, nil)
+		}
+		// RAM and ROM wrap around, so a region larger than them holds
+		// several spans.
+		for a := r.start; a < r.end; a += r.size {
+			end := a + r.size
+			if end > r.end {
+				end = r.end
+			}
+			b.mirrors[r.mirror] = append(b.mirrors[r.mirror], span{start: a, end: end, region: len(b.regions)})
+		}
+	}
+	b.regions = append(b.regions, r)
+}
+
+func (b *Bus) find(addr uint16) *region {
+	for i := range b.regions {
+		r := &b.regions[i]
+		if uint32(addr) >= r.start && uint32(addr) < r.end {
+			return r
+		}
+	}
+	return nil
+}
+
+func (b *Bus) Read(addr uint16) uint8 {
+	if r := b.find(addr); r != nil {
+		return r.mem.Read(addr - uint16(r.start))
+	}
+	return 0xFF
+}
+
+func (b *Bus) Aliases(addr uint16, aliases []uint16) []uint16 {
+	r := b.find(addr)
+	if r == nil || r.mirror < 0 || len(b.mirrors[r.mirror]) < 2 {
+		return aliases
+	}
+	off := (uint32(addr) - r.start) % r.size
+	for _, sp := range b.mirrors[r.mirror] {
+		// The span may be hidden by a region mapped before it.
+		if a := sp.start + off; a < sp.end && b.find(uint16(a)) == &b.regions[sp.region] {
+			aliases = append(aliases, uint16(a))
+		}
+	}
+	return aliases
+}
+
+func (b *Bus) Write(addr uint16, val uint8) {
+	if r := b.find(addr); r != nil {
+		r.mem.Write(addr-uint16(r.start), val)
+	}
+}
+
+func (b *Bus) Load(addr uint16, data []uint8) {
+	for i := 0; i < len(data); i++ {
+		a := addr + uint16(i)
+		r := b.find(a)
+		if r == nil {
+			continue
+		}
+		if l, ok := r.mem.(Loader); ok {
+			l.Load(a-uint16(r.start), data[i:i+1])
+		} else {
+			r.mem.Write(a-uint16(r.start), data[i])
+		}
+	}
+}
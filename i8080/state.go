@@ -0,0 +1,134 @@
+package i8080
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// A save state is laid out as follows, with all integers little endian:
+//
+//	magic    [4]byte  "8080"
+//	version  uint16   STATE_VERSION at the time of saving
+//	length   uint32   size of the payload in bytes
+//	payload  [length]byte
+//	checksum uint32   CRC-32 (IEEE) of the payload
+//
+// The version 1 payload is:
+//
+//	A, F, B, C, D, E, H, L  uint8 each, F being the PSW flags byte
+//	PC, SP                  uint16
+//	cycles                  int64
+//	status                  uint8, bit 0 INTE, bit 1 EI pending, bit 2 halted
+//	memory                  [65536]uint8, read through the memory interface
+//
+// New fields are only ever appended to the payload, together with a version
+// bump, and LoadState keeps accepting every earlier version.
+const (
+	STATE_MAGIC   = "8080"
+	STATE_VERSION = 1
+)
+
+var (
+	ErrStateMagic    = errors.New("i8080: not a save state")
+	ErrStateVersion  = errors.New("i8080: unsupported save state version")
+	ErrStateChecksum = errors.New("i8080: save state checksum mismatch")
+	ErrStateSize     = errors.New("i8080: save state payload too short")
+	ErrStateLength   = errors.New("i8080: save state payload too long")
+)
+
+type stateHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Length  uint32
+}
+
+type stateV1 struct {
+	A, F, B, C, D, E, H, L uint8
+	PC, SP                 uint16
+	Cycles                 int64
+	Status                 uint8
+}
+
+func (c *CPU) SaveState(w io.Writer) error {
+	st := stateV1{
+		A: c.reg.A, F: uint8(c.GetAF()), B: c.reg.B, C: c.reg.C,
+		D: c.reg.D, E: c.reg.E, H: c.reg.H, L: c.reg.L,
+		PC: c.pc, SP: c.sp, Cycles: int64(c.cyc),
+	}
+	if c.intEnabled {
+		st.Status |= 1 << 0
+	}
+	if c.intDelay {
+		st.Status |= 1 << 1
+	}
+	if c.halted {
+		st.Status |= 1 << 2
+	}
+
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.LittleEndian, &st)
+	for addr := 0; addr < 64*1024; addr++ {
+		payload.WriteByte(c.Read(uint16(addr)))
+	}
+
+	hdr := stateHeader{Version: STATE_VERSION, Length: uint32(payload.Len())}
+	copy(hdr.Magic[:], STATE_MAGIC)
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+}
+
+func (c *CPU) LoadState(r io.Reader) error {
+	var hdr stateHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if string(hdr.Magic[:]) != STATE_MAGIC {
+		return ErrStateMagic
+	}
+	if hdr.Version < 1 || hdr.Version > STATE_VERSION {
+		return ErrStateVersion
+	}
+	// The length is checked before it is allocated, since the state may be
+	// corrupt. The latest version has the largest payload.
+	if hdr.Length > uint32(binary.Size(stateV1{})+64*1024) {
+		return ErrStateLength
+	}
+	payload := make([]uint8, hdr.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+		return err
+	}
+	if sum != crc32.ChecksumIEEE(payload) {
+		return ErrStateChecksum
+	}
+
+	var st stateV1
+	size := binary.Size(&st)
+	if len(payload) < size+64*1024 {
+		return ErrStateSize
+	}
+	binary.Read(bytes.NewReader(payload), binary.LittleEndian, &st)
+	c.Load(0, payload[size:size+64*1024])
+	c.SetAF((uint16(st.A) << 8) | uint16(st.F))
+	c.SetBC((uint16(st.B) << 8) | uint16(st.C))
+	c.SetDE((uint16(st.D) << 8) | uint16(st.E))
+	c.SetHL((uint16(st.H) << 8) | uint16(st.L))
+	c.SetPC(st.PC)
+	c.SetSP(st.SP)
+	c.cyc = int(st.Cycles)
+	c.intEnabled = st.Status&(1<<0) != 0
+	c.intDelay = st.Status&(1<<1) != 0
+	c.halted = st.Status&(1<<2) != 0
+	return nil
+}
@@ -0,0 +1,166 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+var FLAG_NAMES = []struct {
+	name string
+	bit  uint
+}{{"S", 7}, {"Z", 6}, {"AC", 4}, {"P", 2}, {"CY", 0}}
+
+// Differ runs a machine against a reference trace and reports the first
+// instruction whose state differs.
+type Differ struct {
+	cpu      *i8080.CPU
+	step     func() bool
+	lines    int
+	recorded []Write
+}
+
+// NewDiffer compares cpu against a reference, using step to execute one
+// instruction of the machine. context lines are shown on either side of a
+// divergence.
+func NewDiffer(cpu *i8080.CPU, step func() bool, context int) *Differ {
+	d := &Differ{cpu: cpu, step: step, lines: context}
+	cpu.SetMemory(&recorder{cpu.GetMemory(), &d.recorded})
+	return d
+}
+
+// Run reads the reference from ref and writes the report to out. It returns
+// whether the machine diverged. The reference may be in any trace format,
+// with or without writes. A divergent state is blamed on the instruction
+// before it, which produced it, and divergent writes on their own
+// instruction.
+func (d *Differ) Run(ref io.Reader, out io.Writer) (bool, error) {
+	scanner := bufio.NewScanner(ref)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	var before []string
+	var prev uint16
+	n := 0
+	alive := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		want, format, err := Parse(line)
+		if err != nil {
+			return false, fmt.Errorf("reference line %d: %v", n+1, err)
+		}
+		n++
+		if !alive {
+			fmt.Fprintf(out, "first divergence after instruction %d, PC %04X: the machine stopped\n\n", n-1, prev)
+			d.context(out, n, before)
+			fmt.Fprintf(out, "- %8d  %s\n", n, Format(format, want))
+			return true, nil
+		}
+		var got Entry
+		got, alive = d.next()
+		got.HasWrites = want.HasWrites
+
+		diffs := compare(want, got)
+		if len(diffs) == 0 {
+			before = append(before, Format(format, got))
+			if len(before) > d.lines {
+				before = before[1:]
+			}
+			prev = got.PC
+			continue
+		}
+
+		where := fmt.Sprintf("at instruction %d, PC %04X", n, want.PC)
+		state := want
+		state.HasWrites = false
+		if n > 1 && len(compare(state, got)) > 0 {
+			where = fmt.Sprintf("after instruction %d, PC %04X", n-1, prev)
+		}
+		fmt.Fprintf(out, "first divergence %s: %s\n\n", where, strings.Join(diffs, ", "))
+		d.context(out, n, before)
+		fmt.Fprintf(out, "- %8d  %s\n", n, Format(format, want))
+		fmt.Fprintf(out, "+ %8d  %s\n", n, Format(format, got))
+		for i := 1; i <= d.lines && alive && scanner.Scan(); i++ {
+			if want, _, err = Parse(scanner.Text()); err != nil {
+				break
+			}
+			got, alive = d.next()
+			got.HasWrites = want.HasWrites
+			fmt.Fprintf(out, "- %8d  %s\n", n+i, Format(format, want))
+			fmt.Fprintf(out, "+ %8d  %s\n", n+i, Format(format, got))
+		}
+		return true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(out, "no divergence in %d instructions\n", n)
+	return false, nil
+}
+
+// context prints the matching lines before instruction n.
+func (d *Differ) context(out io.Writer, n int, before []string) {
+	for i, l := range before {
+		fmt.Fprintf(out, "  %8d  %s\n", n-len(before)+i, l)
+	}
+}
+
+// next captures the state before the machine's next instruction and runs
+// it to collect its writes.
+func (d *Differ) next() (Entry, bool) {
+	e := Capture(d.cpu)
+	d.recorded = nil
+	alive := d.step()
+	e.Writes = d.recorded
+	return e, alive
+}
+
+func compare(want Entry, got Entry) []string {
+	var diffs []string
+	pairs := []struct {
+		name      string
+		want, got uint16
+	}{
+		{"PC", want.PC, got.PC}, {"A", want.AF >> 8, got.AF >> 8}, {"BC", want.BC, got.BC},
+		{"DE", want.DE, got.DE}, {"HL", want.HL, got.HL}, {"SP", want.SP, got.SP},
+	}
+	for _, p := range pairs {
+		if p.want != p.got {
+			diffs = append(diffs, fmt.Sprintf("%s is %04X, expected %04X", p.name, p.got, p.want))
+		}
+	}
+	for _, f := range FLAG_NAMES {
+		if (want.AF>>f.bit)&1 != (got.AF>>f.bit)&1 {
+			diffs = append(diffs, fmt.Sprintf("flag %s is %d, expected %d", f.name, (got.AF>>f.bit)&1, (want.AF>>f.bit)&1))
+		}
+	}
+	if want.HasCycles && want.Cycles != got.Cycles {
+		diffs = append(diffs, fmt.Sprintf("cycles are %d, expected %d", got.Cycles, want.Cycles))
+	}
+	for i := 0; i < len(want.Mem) && i < len(got.Mem); i++ {
+		if want.Mem[i] != got.Mem[i] {
+			diffs = append(diffs, fmt.Sprintf("memory at PC+%d is %02X, expected %02X", i, got.Mem[i], want.Mem[i]))
+			break
+		}
+	}
+	if want.HasWrites && !sameWrites(want.Writes, got.Writes) {
+		diffs = append(diffs, "memory writes differ")
+	}
+	return diffs
+}
+
+func sameWrites(a []Write, b []Write) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
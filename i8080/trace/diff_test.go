@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+func reference(t *testing.T, format int, n int) string {
+	var buf bytes.Buffer
+	tracer := New(&buf, format)
+	tracer.SetWrites(true)
+	run(tracer, n)
+	return buf.String()
+}
+
+// differ runs PROGRAM, calling fault after instruction bug like a broken
+// opcode would.
+func differ(bug int, fault func(*i8080.CPU)) *Differ {
+	cpu := i8080.NewCPU(0, nil, nil)
+	cpu.Load(0, PROGRAM)
+	n := 0
+	return NewDiffer(cpu, func() bool {
+		cpu.Execute()
+		if n++; n == bug {
+			fault(cpu)
+		}
+		return true
+	}, 2)
+}
+
+func TestParse(t *testing.T) {
+	e := Entry{PC: 0x1234, AF: 0x56D7, BC: 1, DE: 2, HL: 3, SP: 0xFFFE, Cycles: 42, HasCycles: true,
+		Mem: []uint8{0x3E, 0x01, 0x3C, 0x3C}, Writes: []Write{{0xFFFD, 0x12}, {0xFFFC, 0x34}}, HasWrites: true}
+	for name, format := range FORMATS {
+		parsed, detected, err := Parse(Format(format, e))
+		if err != nil {
+			t.Errorf("[%s] %v", name, err)
+			continue
+		}
+		if detected != format {
+			t.Errorf("[%s format] expected: %d, actual: %d", name, format, detected)
+		}
+		if diffs := compare(parsed, e); len(diffs) != 0 {
+			t.Errorf("[%s] %s", name, strings.Join(diffs, ", "))
+		}
+	}
+}
+
+func TestDiffSame(t *testing.T) {
+	for name, format := range FORMATS {
+		var out bytes.Buffer
+		diverged, err := differ(0, nil).Run(strings.NewReader(reference(t, format, 10)), &out)
+		if err != nil || diverged {
+			t.Errorf("[%s] expected no divergence, actual: %v %q", name, err, out.String())
+		}
+	}
+}
+
+func TestDiffDivergence(t *testing.T) {
+	// The third instruction is the INR A at 0003.
+	tests := []struct {
+		name     string
+		fault    func(*i8080.CPU)
+		expected string
+	}{
+		{"state", func(c *i8080.CPU) { c.SetAF(c.GetAF() | 0x01) },
+			"first divergence after instruction 3, PC 0003: flag CY is 1, expected 0"},
+		{"writes", func(c *i8080.CPU) { c.GetMemory().Write(0x100, 0xFF) },
+			"first divergence at instruction 3, PC 0003: memory writes differ"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		diverged, err := differ(3, test.fault).Run(strings.NewReader(reference(t, SUPERZAZU, 10)), &out)
+		if err != nil || !diverged {
+			t.Fatalf("[%s] expected a divergence, actual: %v", test.name, err)
+		}
+		first := strings.SplitN(out.String(), "\n", 2)[0]
+		if first != test.expected {
+			t.Errorf("[%s] expected: %q, actual: %q", test.name, test.expected, first)
+		}
+		if n := strings.Count(out.String(), "\n- "); n != 3 {
+			t.Errorf("[%s context] expected: 3 reference lines, actual: %d\n%s", test.name, n, out.String())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/disasm"
+)
+
+type Write struct {
+	Addr uint16
+	Val  uint8
+}
+
+// Entry is the CPU state before one instruction. Mem holds the bytes at PC,
+// or on the data bus for an interrupt, and Writes the memory the
+// instruction wrote. Parsed entries only have the
+// fields their format records.
+type Entry struct {
+	PC, AF, BC, DE, HL, SP uint16
+	Cycles                 int
+	Mem                    []uint8
+	Writes                 []Write
+	HasCycles, HasWrites   bool
+}
+
+func Capture(c *i8080.CPU) Entry {
+	pc := c.GetPC()
+	mem := c.GetMemory()
+	e := Entry{
+		PC: pc, AF: c.GetAF(), BC: c.GetBC(), DE: c.GetDE(), HL: c.GetHL(), SP: c.GetSP(),
+		Cycles: c.GetCycles(), HasCycles: true,
+		Mem: []uint8{mem.Read(pc), mem.Read(pc + 1), mem.Read(pc + 2), mem.Read(pc + 3)},
+	}
+	if bus := c.GetBus(); bus != nil {
+		e.Mem = append([]uint8{}, bus...)
+	}
+	return e
+}
+
+type entryMemory struct {
+	e *Entry
+}
+
+func (m entryMemory) Read(addr uint16) uint8 {
+	if i := int(addr - m.e.PC); i < len(m.e.Mem) {
+		return m.e.Mem[i]
+	}
+	return 0
+}
+
+func (m entryMemory) Write(addr uint16, val uint8) {}
+
+func (e *Entry) mem(i int) uint8 {
+	if i < len(e.Mem) {
+		return e.Mem[i]
+	}
+	return 0
+}
+
+// Format renders e as one line, without a newline, followed by its writes
+// when HasWrites is set.
+func Format(format int, e Entry) string {
+	var line string
+	switch format {
+	case SUPERZAZU:
+		line = fmt.Sprintf("PC: %04X, AF: %04X, BC: %04X, DE: %04X, HL: %04X, SP: %04X, CYC: %d\t(%02X %02X %02X %02X)",
+			e.PC, e.AF, e.BC, e.DE, e.HL, e.SP, e.Cycles, e.mem(0), e.mem(1), e.mem(2), e.mem(3))
+	case DOCTOR:
+		line = fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+			e.AF>>8, uint8(e.AF), e.BC>>8, uint8(e.BC), e.DE>>8, uint8(e.DE), e.HL>>8, uint8(e.HL),
+			e.SP, e.PC, e.mem(0), e.mem(1), e.mem(2), e.mem(3))
+	case VERBOSE:
+		inst := disasm.Decode(entryMemory{&e}, e.PC)
+		raw := make([]string, len(inst.Bytes))
+		for i, b := range inst.Bytes {
+			raw[i] = fmt.Sprintf("%02X", b)
+		}
+		line = fmt.Sprintf("%04X  %-9s %-15s AF=%04X BC=%04X DE=%04X HL=%04X SP=%04X CYC=%d",
+			e.PC, strings.Join(raw, " "), inst.Intel(), e.AF, e.BC, e.DE, e.HL, e.SP, e.Cycles)
+	}
+	if e.HasWrites {
+		writes := make([]string, len(e.Writes))
+		for i, w := range e.Writes {
+			writes[i] = fmt.Sprintf("%04X=%02X", w.Addr, w.Val)
+		}
+		line += " W:" + strings.Join(writes, ",")
+	}
+	return line
+}
+
+// Parse reads a line in any of the formats and reports which one it was.
+func Parse(line string) (Entry, int, error) {
+	var e Entry
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.LastIndex(line, " W:"); i >= 0 {
+		e.HasWrites = true
+		if list := line[i+3:]; list != "" {
+			for _, w := range strings.Split(list, ",") {
+				var addr, val uint
+				if _, err := fmt.Sscanf(w, "%X=%X", &addr, &val); err != nil {
+					return e, 0, fmt.Errorf("invalid write %q", w)
+				}
+				e.Writes = append(e.Writes, Write{uint16(addr), uint8(val)})
+			}
+		}
+		line = line[:i]
+	}
+
+	var b [4]uint
+	switch {
+	case strings.HasPrefix(line, "PC: "):
+		var pc, af, bc, de, hl, sp uint
+		_, err := fmt.Sscanf(line, "PC: %X, AF: %X, BC: %X, DE: %X, HL: %X, SP: %X, CYC: %d\t(%X %X %X %X)",
+			&pc, &af, &bc, &de, &hl, &sp, &e.Cycles, &b[0], &b[1], &b[2], &b[3])
+		if err != nil {
+			return e, 0, fmt.Errorf("invalid trace line %q", line)
+		}
+		e.PC, e.AF, e.BC, e.DE, e.HL, e.SP = uint16(pc), uint16(af), uint16(bc), uint16(de), uint16(hl), uint16(sp)
+		e.HasCycles = true
+		e.Mem = []uint8{uint8(b[0]), uint8(b[1]), uint8(b[2]), uint8(b[3])}
+		return e, SUPERZAZU, nil
+	case strings.HasPrefix(line, "A:"):
+		var r [8]uint
+		var sp, pc uint
+		_, err := fmt.Sscanf(line, "A:%X F:%X B:%X C:%X D:%X E:%X H:%X L:%X SP:%X PC:%X PCMEM:%X,%X,%X,%X",
+			&r[0], &r[1], &r[2], &r[3], &r[4], &r[5], &r[6], &r[7], &sp, &pc, &b[0], &b[1], &b[2], &b[3])
+		if err != nil {
+			return e, 0, fmt.Errorf("invalid trace line %q", line)
+		}
+		pair := func(hi uint, lo uint) uint16 { return uint16(hi)<<8 | uint16(lo&0xFF) }
+		e.AF, e.BC, e.DE, e.HL = pair(r[0], r[1]), pair(r[2], r[3]), pair(r[4], r[5]), pair(r[6], r[7])
+		e.PC, e.SP = uint16(pc), uint16(sp)
+		e.Mem = []uint8{uint8(b[0]), uint8(b[1]), uint8(b[2]), uint8(b[3])}
+		return e, DOCTOR, nil
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return e, 0, fmt.Errorf("invalid trace line %q", line)
+	}
+	pc, err := strconv.ParseUint(fields[0], 16, 16)
+	if err != nil {
+		return e, 0, fmt.Errorf("invalid trace line %q", line)
+	}
+	e.PC = uint16(pc)
+	for _, f := range fields[1:] {
+		if len(f) != 2 || len(e.Mem) == 3 {
+			break
+		}
+		v, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			break
+		}
+		e.Mem = append(e.Mem, uint8(v))
+	}
+	regs := map[string]*uint16{"AF": &e.AF, "BC": &e.BC, "DE": &e.DE, "HL": &e.HL, "SP": &e.SP}
+	found := 0
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == "CYC" {
+			if e.Cycles, err = strconv.Atoi(kv[1]); err == nil {
+				e.HasCycles = true
+				found++
+			}
+		} else if reg, ok := regs[kv[0]]; ok {
+			v, err := strconv.ParseUint(kv[1], 16, 16)
+			if err != nil {
+				return e, 0, fmt.Errorf("invalid trace line %q", line)
+			}
+			*reg = uint16(v)
+			found++
+		}
+	}
+	if found != len(regs)+1 {
+		return e, 0, fmt.Errorf("invalid trace line %q", line)
+	}
+	return e, VERBOSE, nil
+}
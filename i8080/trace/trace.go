@@ -0,0 +1,171 @@
+// Package trace writes a log line for every instruction a CPU executes, in
+// the formats used by common 8080 reference emulators so that logs can be
+// compared line by line.
+package trace
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+const (
+	// SUPERZAZU matches superzazu/8080:
+	// PC: 0100, AF: 0002, BC: 0000, DE: 0000, HL: 0000, SP: 0000, CYC: 0	(C3 B2 01 4D)
+	SUPERZAZU = iota
+	// DOCTOR matches the Gameboy Doctor style used by many 8080 cores:
+	// A:00 F:02 B:00 C:00 D:00 E:00 H:00 L:00 SP:0000 PC:0100 PCMEM:C3,B2,01,4D
+	DOCTOR
+	// VERBOSE adds the disassembled instruction:
+	// 0100  C3 B2 01  JMP 01B2H       AF=0002 BC=0000 DE=0000 HL=0000 SP=0000 CYC=0
+	VERBOSE
+)
+
+var FORMATS = map[string]int{"superzazu": SUPERZAZU, "doctor": DOCTOR, "verbose": VERBOSE}
+
+type Tracer struct {
+	w        *bufio.Writer
+	closers  []io.Closer
+	format   int
+	from, to uint16
+	skip     uint64
+	count    uint64
+	seen     uint64
+	written  uint64
+	writes   bool
+	pending  *Entry
+	recorded []Write
+}
+
+func New(w io.Writer, format int) *Tracer {
+	return &Tracer{w: bufio.NewWriterSize(w, 1<<16), format: format, to: 0xFFFF}
+}
+
+// Create writes the trace to a file, gzip compressed when the name ends in
+// .gz.
+func Create(filename string, format int) (*Tracer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(filename, ".gz") {
+		t := New(f, format)
+		t.closers = []io.Closer{f}
+		return t, nil
+	}
+	gz := gzip.NewWriter(f)
+	t := New(gz, format)
+	t.closers = []io.Closer{gz, f}
+	return t, nil
+}
+
+func ParseFormat(name string) (int, error) {
+	format, ok := FORMATS[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown trace format %s", name)
+	}
+	return format, nil
+}
+
+// SetRange only traces instructions with PC from from to to inclusive.
+func (t *Tracer) SetRange(from uint16, to uint16) {
+	t.from, t.to = from, to
+}
+
+// SetCount skips the first skip instructions in range and then traces at
+// most count of them. A count of 0 traces everything.
+func (t *Tracer) SetCount(skip uint64, count uint64) {
+	t.skip, t.count = skip, count
+}
+
+// SetWrites appends the memory written by each instruction to its line as
+// W:ADDR=VAL,... It must be called before Attach.
+func (t *Tracer) SetWrites(writes bool) {
+	t.writes = writes
+}
+
+func (t *Tracer) Attach(c *i8080.CPU) {
+	if t.writes {
+		c.SetMemory(&recorder{c.GetMemory(), &t.recorded})
+	}
+	c.SetTracer(t.Trace)
+}
+
+func (t *Tracer) Trace(c *i8080.CPU) {
+	t.emit()
+	pc := c.GetPC()
+	if pc < t.from || pc > t.to {
+		return
+	}
+	t.seen++
+	if t.seen <= t.skip || (t.count > 0 && t.written >= t.count) {
+		return
+	}
+	t.written++
+
+	e := Capture(c)
+	if !t.writes {
+		t.w.WriteString(Format(t.format, e))
+		t.w.WriteByte('\n')
+		return
+	}
+	t.pending, t.recorded = &e, t.recorded[:0]
+}
+
+// emit writes the line held back until its instruction's writes are known.
+func (t *Tracer) emit() {
+	if t.pending == nil {
+		return
+	}
+	t.pending.Writes, t.pending.HasWrites = t.recorded, true
+	t.w.WriteString(Format(t.format, *t.pending))
+	t.w.WriteByte('\n')
+	t.pending = nil
+}
+
+// Written returns the number of lines traced so far.
+func (t *Tracer) Written() uint64 {
+	return t.written
+}
+
+func (t *Tracer) Flush() error {
+	t.emit()
+	return t.w.Flush()
+}
+
+// Close flushes the trace and closes the file made by Create.
+func (t *Tracer) Close() error {
+	err := t.Flush()
+	for _, c := range t.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// recorder appends every write to a list.
+type recorder struct {
+	i8080.Memory
+	writes *[]Write
+}
+
+func (r *recorder) Write(addr uint16, val uint8) {
+	r.Memory.Write(addr, val)
+	*r.writes = append(*r.writes, Write{addr, val})
+}
+
+func (r *recorder) Load(addr uint16, data []uint8) {
+	if l, ok := r.Memory.(i8080.Loader); ok {
+		l.Load(addr, data)
+		return
+	}
+	for i, b := range data {
+		r.Memory.Write(addr+uint16(i), b)
+	}
+}
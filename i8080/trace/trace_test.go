@@ -0,0 +1,116 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+// MVI A,1; INR A; INR A; JMP 0
+var PROGRAM = []uint8{0x3E, 0x01, 0x3C, 0x3C, 0xC3, 0x00, 0x00}
+
+func run(t *Tracer, n int) {
+	cpu := i8080.NewCPU(0, nil, nil)
+	cpu.Load(0, PROGRAM)
+	t.Attach(cpu)
+	for i := 0; i < n; i++ {
+		cpu.Execute()
+	}
+	t.Flush()
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format int
+		line   string
+	}{
+		{SUPERZAZU, "PC: 0002, AF: 0102, BC: 0000, DE: 0000, HL: 0000, SP: 0000, CYC: 7\t(3C 3C C3 00)"},
+		{DOCTOR, "A:01 F:02 B:00 C:00 D:00 E:00 H:00 L:00 SP:0000 PC:0002 PCMEM:3C,3C,C3,00"},
+		{VERBOSE, "0002  3C        INR A           AF=0102 BC=0000 DE=0000 HL=0000 SP=0000 CYC=7"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		tracer := New(&buf, test.format)
+		run(tracer, 2)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 || lines[1] != test.line {
+			t.Errorf("[format %d] expected: %q, actual: %q", test.format, test.line, lines)
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(&buf, VERBOSE)
+	cpu := i8080.NewCPU(0, nil, nil)
+	cpu.Load(0, []uint8{0xFB, 0x00, 0x00}) // EI; NOP; NOP
+	tracer.Attach(cpu)
+	cpu.Execute()
+	cpu.Execute()
+	cpu.Interrupt(i8080.RST(1))
+	tracer.Flush()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	line := "0002  CF        RST 1           AF=0002 BC=0000 DE=0000 HL=0000 SP=0000 CYC=8"
+	if len(lines) != 3 || lines[2] != line {
+		t.Errorf("[interrupt] expected: %q, actual: %q", line, lines)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(&buf, DOCTOR)
+	tracer.SetRange(2, 3)
+	tracer.SetCount(1, 3)
+	run(tracer, 20)
+	if tracer.Written() != 3 {
+		t.Errorf("[count] expected: 3, actual: %d", tracer.Written())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "PC:0002") && !strings.Contains(line, "PC:0003") {
+			t.Errorf("[range] unexpected line %q", line)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "A:02 F:02") {
+		t.Errorf("[skip] expected the first traced INR to be skipped, actual: %q", buf.String())
+	}
+}
+
+func TestCreateGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "trace.log.gz")
+	tracer, err := Create(name, SUPERZAZU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(tracer, 4)
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 4 {
+		t.Errorf("[lines] expected: 4, actual: %d", n)
+	}
+}
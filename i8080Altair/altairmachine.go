@@ -0,0 +1,182 @@
+package i8080Altair
+
+import (
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+// The ports of the standard Altair serial boards and the sense switches.
+var (
+	SIO_STATUS   = uint8(0x00)
+	SIO_DATA     = uint8(0x01)
+	SIO2_CONTROL = uint8(0x10)
+	SIO2_DATA    = uint8(0x11)
+	SENSE        = uint8(0xFF)
+)
+
+// Panel is the state of the front panel lights.
+type Panel struct {
+	Address uint16
+	Data    uint8
+	Wait    bool
+	Inte    bool
+	Hlta    bool
+}
+
+// AltairMachine is an Altair 8800 with 64K of RAM, an 88-SIO and an 88-2SIO
+// both connected to the host terminal, an 88-DCDD floppy controller, and a
+// front panel that is driven through its methods.
+type AltairMachine struct {
+	cpu      *i8080.CPU
+	ports    *i8080.Ports
+	term     *devices.Console
+	disks    *DCDD
+	switches uint16
+	address  uint16
+	stop     int32
+	running  int32
+	lights   uint32
+}
+
+func NewAltairMachine(in io.Reader, out io.Writer) *AltairMachine {
+	m := &AltairMachine{ports: i8080.NewPorts(), term: devices.NewConsole(in, out), disks: NewDCDD()}
+	m.cpu = i8080.NewCPU(0, i8080.NewFlatMemory(0, 64*1024), m.ports)
+	m.ports.Attach(NewSIO(m.term, SIO_STATUS, SIO_DATA), SIO_STATUS, SIO_DATA)
+	m.ports.Attach(NewACIA(m.term, SIO2_CONTROL, SIO2_DATA), SIO2_CONTROL, SIO2_DATA)
+	m.ports.Attach(m.disks, DCDD_SELECT, DCDD_CONTROL, DCDD_DATA)
+	m.ports.AttachIn(m, SENSE)
+	return m
+}
+
+// GetPorts returns the I/O bus, for attaching more boards.
+func (m *AltairMachine) GetPorts() *i8080.Ports {
+	return m.ports
+}
+
+func (m *AltairMachine) GetCPU() *i8080.CPU {
+	return m.cpu
+}
+
+// LoadFile loads a binary such as a BASIC or monitor image at addr.
+func (m *AltairMachine) LoadFile(filename string, addr uint16) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	m.cpu.Load(addr, data)
+	return nil
+}
+
+// GetDisks returns the floppy controller, for mounting disk images.
+func (m *AltairMachine) GetDisks() *DCDD {
+	return m.disks
+}
+
+// Close writes back and closes the disk images.
+func (m *AltairMachine) Close() error {
+	return m.disks.Close()
+}
+
+// In reads the sense switches, the upper half of the address switches.
+func (m *AltairMachine) In(port uint8) uint8 {
+	return uint8(m.switches >> 8)
+}
+
+func (m *AltairMachine) Out(port uint8, val uint8) {}
+
+// SetSwitches sets the 16 address and data switches.
+func (m *AltairMachine) SetSwitches(val uint16) {
+	m.switches = val
+}
+
+func (m *AltairMachine) GetSwitches() uint16 {
+	return m.switches
+}
+
+// Examine shows the byte at addr and makes addr the next instruction, like
+// EXAMINE with addr on the switches.
+func (m *AltairMachine) Examine(addr uint16) uint8 {
+	m.address = addr
+	m.cpu.SetPC(addr)
+	return m.cpu.Read(addr)
+}
+
+func (m *AltairMachine) ExamineNext() uint8 {
+	return m.Examine(m.address + 1)
+}
+
+// Deposit stores val at the examined address.
+func (m *AltairMachine) Deposit(val uint8) {
+	m.cpu.Write(m.address, val)
+}
+
+func (m *AltairMachine) DepositNext(val uint8) {
+	m.Examine(m.address + 1)
+	m.Deposit(val)
+}
+
+// Reset clears the CPU and starts again at address 0. Memory is kept.
+func (m *AltairMachine) Reset() {
+	m.cpu.Reset(0)
+	m.address = 0
+}
+
+// SingleStep executes one instruction.
+func (m *AltairMachine) SingleStep() {
+	m.cpu.Execute()
+	m.address = m.cpu.GetPC()
+}
+
+// Run runs from the current address until Stop is called, the CPU halts or
+// the program waits for input after the end of it.
+func (m *AltairMachine) Run() {
+	atomic.StoreInt32(&m.stop, 0)
+	atomic.StoreInt32(&m.running, 1)
+	defer atomic.StoreInt32(&m.running, 0)
+	for m.Step() {
+	}
+}
+
+// Stop makes Run return. It is safe to call from another goroutine.
+func (m *AltairMachine) Stop() {
+	atomic.StoreInt32(&m.stop, 1)
+}
+
+// Step executes one instruction and reports whether the machine should keep
+// running. The instruction's address and opcode are shown on the panel.
+func (m *AltairMachine) Step() bool {
+	pc := m.cpu.GetPC()
+	lights := uint32(pc)<<8 | uint32(m.cpu.Read(pc))
+	if m.cpu.IsInterrupted() {
+		lights |= 1 << 24
+	}
+	atomic.StoreUint32(&m.lights, lights)
+	m.SingleStep()
+	return !m.cpu.IsHalted() && !m.term.Starved() && atomic.LoadInt32(&m.stop) == 0
+}
+
+func (m *AltairMachine) Debugger() *debugger.Debugger {
+	return debugger.New(m.cpu, m.Step)
+}
+
+// GetPanel returns the lights. While the machine waits, the address and
+// data lights show the examined address and its contents. While it runs,
+// which may be on another goroutine, they show the last instruction fetched.
+func (m *AltairMachine) GetPanel() Panel {
+	if atomic.LoadInt32(&m.running) != 0 {
+		lights := atomic.LoadUint32(&m.lights)
+		return Panel{Address: uint16(lights >> 8), Data: uint8(lights), Inte: lights&(1<<24) != 0}
+	}
+	return Panel{
+		Address: m.address,
+		Data:    m.cpu.Read(m.address),
+		Wait:    true,
+		Inte:    m.cpu.IsInterrupted(),
+		Hlta:    m.cpu.IsHalted(),
+	}
+}
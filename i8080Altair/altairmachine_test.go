@@ -0,0 +1,260 @@
+package i8080Altair
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080/asm"
+)
+
+func TestSerialEcho(t *testing.T) {
+	tests := []struct {
+		name, src string
+	}{
+		{"88-SIO", `
+LOOP:	IN	0
+	RRC
+	JC	LOOP
+	IN	1
+	OUT	1
+	JMP	LOOP`},
+		{"88-2SIO", `
+	MVI	A,3
+	OUT	10H
+LOOP:	IN	10H
+	RRC
+	JNC	LOOP
+	IN	11H
+	OUT	11H
+	JMP	LOOP`},
+	}
+	for _, tt := range tests {
+		prog, err := asm.Assemble("echo.asm", []uint8(tt.src))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		var out bytes.Buffer
+		m := NewAltairMachine(strings.NewReader("hi\r\nok\n"), &out)
+		prog.LoadInto(m.GetCPU())
+		m.Run()
+		if want := "hi\rok\r"; out.String() != want {
+			t.Errorf("[%s] expected: %q, actual: %q", tt.name, want, out.String())
+		}
+	}
+}
+
+func TestSerialEOF(t *testing.T) {
+	// Print five x's, looking for a ^C before each one like BASIC does.
+	prog, err := asm.Assemble("eof.asm", []uint8(`
+	MVI	B,5
+LOOP:	IN	0
+	RRC
+	JNC	DONE
+	MVI	A,'x'
+	OUT	1
+	DCR	B
+	JNZ	LOOP
+DONE:	HLT`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var out bytes.Buffer
+	m := NewAltairMachine(strings.NewReader(""), &out)
+	prog.LoadInto(m.GetCPU())
+	m.Run()
+	if out.String() != "xxxxx" || !m.GetCPU().IsHalted() {
+		t.Errorf("[eof] expected: xxxxx and halted, actual: %q", out.String())
+	}
+}
+
+func TestFrontPanel(t *testing.T) {
+	m := NewAltairMachine(strings.NewReader(""), ioutil.Discard)
+	m.SetSwitches(0xA500)
+
+	// IN 0FFH, STA 0020H, HLT
+	m.Examine(0)
+	m.Deposit(0xDB)
+	for _, b := range []uint8{0xFF, 0x32, 0x20, 0x00, 0x76} {
+		m.DepositNext(b)
+	}
+	if p := m.GetPanel(); p.Address != 5 || p.Data != 0x76 || !p.Wait {
+		t.Errorf("[deposit] expected: 0005 76 WAIT, actual: %+v", p)
+	}
+
+	m.Examine(0)
+	m.SingleStep()
+	if p := m.GetPanel(); p.Address != 2 || p.Data != 0x32 {
+		t.Errorf("[single step] expected: 0002 32, actual: %+v", p)
+	}
+	if a := uint8(m.GetCPU().GetAF() >> 8); a != 0xA5 {
+		t.Errorf("[sense switches] expected: A5, actual: %02X", a)
+	}
+
+	m.Run()
+	if p := m.GetPanel(); !p.Hlta || p.Address != 6 {
+		t.Errorf("[run] expected: 0006 HLTA, actual: %+v", p)
+	}
+	if v := m.Examine(0x20); v != 0xA5 {
+		t.Errorf("[examine] expected: A5, actual: %02X", v)
+	}
+}
+
+// signal is an output that tells when it is first written.
+type signal chan bool
+
+func (s signal) Write(p []uint8) (int, error) {
+	select {
+	case s <- true:
+	default:
+	}
+	return len(p), nil
+}
+
+func TestRunningPanel(t *testing.T) {
+	// Print a character and then loop at 0003H with JMP 0003H, which the
+	// panel shows while it runs.
+	started := make(signal, 1)
+	m := NewAltairMachine(strings.NewReader(""), started)
+	m.GetCPU().Load(0, []uint8{0xD3, 0x01, 0x00, 0xC3, 0x03, 0x00})
+	done := make(chan bool)
+	go func() {
+		m.Run()
+		done <- true
+	}()
+	<-started
+	var p Panel
+	for i := 0; i < 1000000 && (p.Address != 3 || p.Data != 0xC3); i++ {
+		p = m.GetPanel()
+	}
+	m.Stop()
+	<-done
+	if p.Address != 3 || p.Data != 0xC3 || p.Wait {
+		t.Errorf("[running] expected: 0003 C3, actual: %+v", p)
+	}
+}
+
+func TestDCDD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "altair")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	image := make([]uint8, DCDD_DISK_SIZE)
+	sector := (1*DCDD_SECTORS + 3) * DCDD_SECTOR_SIZE
+	for i := 0; i < DCDD_SECTOR_SIZE; i++ {
+		image[sector+i] = uint8(i)
+	}
+	filename := filepath.Join(dir, "disk.dsk")
+	if err := ioutil.WriteFile(filename, image, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m := NewAltairMachine(strings.NewReader(""), ioutil.Discard)
+	if err := m.GetDisks().Mount(0, filename); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ports := m.GetPorts()
+	if s := ports.In(DCDD_SELECT); s != 0xFF {
+		t.Errorf("[no drive] expected status: FF, actual: %02X", s)
+	}
+	ports.Out(DCDD_SELECT, 0)
+	if s := ports.In(DCDD_SELECT); s&(DCDD_TRACK0|DCDD_MH) != 0 || s&DCDD_HS == 0 {
+		t.Errorf("[select] expected track 0 and head unloaded, actual: %02X", s)
+	}
+	ports.Out(DCDD_CONTROL, DCDD_STEP_IN|DCDD_LOAD)
+	if s := ports.In(DCDD_SELECT); s&DCDD_TRACK0 == 0 || s&DCDD_HS != 0 {
+		t.Errorf("[step] expected track 1 and head loaded, actual: %02X", s)
+	}
+
+	// Wait for sector 3 and read it.
+	for ports.In(DCDD_CONTROL) != 0xC0|3<<1 {
+	}
+	for i := 0; i < DCDD_SECTOR_SIZE; i++ {
+		if ports.In(DCDD_SELECT)&DCDD_NRDA != 0 {
+			t.Fatalf("[read] no data at byte %d", i)
+		}
+		if b := ports.In(DCDD_DATA); b != uint8(i) {
+			t.Fatalf("[read] byte %d expected: %02X, actual: %02X", i, i, b)
+		}
+	}
+
+	// Write the next sector, which goes back to the image when it is left.
+	ports.In(DCDD_CONTROL)
+	ports.Out(DCDD_CONTROL, DCDD_WRITE)
+	for i := 0; i < DCDD_SECTOR_SIZE; i++ {
+		if ports.In(DCDD_SELECT)&DCDD_ENWD != 0 {
+			t.Fatalf("[write] not ready at byte %d", i)
+		}
+		ports.Out(DCDD_DATA, 0xAA)
+	}
+	if ports.In(DCDD_SELECT)&DCDD_ENWD == 0 {
+		t.Errorf("[write] expected the sector to be full")
+	}
+	ports.Out(DCDD_CONTROL, DCDD_UNLOAD)
+	if err := m.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	next := sector + DCDD_SECTOR_SIZE
+	if !bytes.Equal(data[next:next+DCDD_SECTOR_SIZE], bytes.Repeat([]uint8{0xAA}, DCDD_SECTOR_SIZE)) {
+		t.Errorf("[write] track 1 sector 4 was not written back")
+	}
+}
+
+func TestDCDDReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "altair")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	image := make([]uint8, DCDD_DISK_SIZE)
+	filename := filepath.Join(dir, "disk.dsk")
+	if err := ioutil.WriteFile(filename, image, 0444); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m := NewAltairMachine(strings.NewReader(""), ioutil.Discard)
+	disks := m.GetDisks()
+	if err := disks.Mount(0, filename); err != nil {
+		t.Fatalf("[mount] %v", err)
+	}
+	// Root can open any file for writing, so the image is also put in drive
+	// 1 read only directly.
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := disks.mount(1, f, true); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ports := m.GetPorts()
+	ports.Out(DCDD_SELECT, 1)
+	ports.Out(DCDD_CONTROL, DCDD_LOAD)
+	ports.In(DCDD_CONTROL)
+	ports.Out(DCDD_CONTROL, DCDD_WRITE)
+	for i := 0; i < DCDD_SECTOR_SIZE; i++ {
+		ports.Out(DCDD_DATA, 0xAA)
+	}
+	ports.Out(DCDD_CONTROL, DCDD_UNLOAD)
+	if err := disks.Err(); err == nil || !strings.HasSuffix(err.Error(), "is read only") {
+		t.Errorf("[write] expected: read only error, actual: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(data, image) {
+		t.Errorf("[write] expected the read only image to be unchanged")
+	}
+}
@@ -0,0 +1,256 @@
+package i8080Altair
+
+import (
+	"fmt"
+	"os"
+)
+
+// The geometry of an Altair 8" disk. Each sector holds 137 bytes, the data
+// and the framing that Altair software lays out itself.
+const (
+	DCDD_TRACKS      = 77
+	DCDD_SECTORS     = 32
+	DCDD_SECTOR_SIZE = 137
+	DCDD_DISK_SIZE   = DCDD_TRACKS * DCDD_SECTORS * DCDD_SECTOR_SIZE
+	DCDD_DRIVES      = 16
+)
+
+// The ports of the 88-DCDD.
+var (
+	DCDD_SELECT  = uint8(0x08)
+	DCDD_CONTROL = uint8(0x09)
+	DCDD_DATA    = uint8(0x0A)
+)
+
+// The status bits of DCDD_SELECT, which read as 0 when true.
+const (
+	DCDD_ENWD   = 0x01 // ready for a byte to write
+	DCDD_MH     = 0x02 // head may move
+	DCDD_HS     = 0x04 // head loaded
+	DCDD_INTE   = 0x20
+	DCDD_TRACK0 = 0x40
+	DCDD_NRDA   = 0x80 // a byte is ready to read
+)
+
+// The bits of a DCDD_CONTROL write.
+const (
+	DCDD_STEP_IN  = 0x01
+	DCDD_STEP_OUT = 0x02
+	DCDD_LOAD     = 0x04
+	DCDD_UNLOAD   = 0x08
+	DCDD_WRITE    = 0x80
+)
+
+type dcddDrive struct {
+	f        *os.File
+	readOnly bool
+	track    int
+	sector   int
+	head     bool
+	buf      [DCDD_SECTOR_SIZE]uint8
+	pos      int
+	writing  bool
+	dirty    bool
+}
+
+// flush writes the sector buffer back to the image if it has changed.
+func (d *dcddDrive) flush() error {
+	if !d.dirty {
+		return nil
+	}
+	d.dirty = false
+	if d.readOnly {
+		return fmt.Errorf("%s is read only", d.f.Name())
+	}
+	_, err := d.f.WriteAt(d.buf[:], d.offset())
+	return err
+}
+
+func (d *dcddDrive) offset() int64 {
+	return int64(d.track*DCDD_SECTORS+d.sector) * DCDD_SECTOR_SIZE
+}
+
+// DCDD is a MITS 88-DCDD floppy controller. The selected drive's sector
+// under the head comes round each time the sector position is read, and its
+// bytes are then transferred one at a time through DCDD_DATA.
+type DCDD struct {
+	drives   [DCDD_DRIVES]*dcddDrive
+	selected int
+	err      error
+}
+
+func NewDCDD() *DCDD {
+	return &DCDD{selected: -1}
+}
+
+// Mount opens a .dsk image in a drive. Writes go back to the image as each
+// sector is left. An image that cannot be written is mounted read only, and
+// writes to it are dropped and reported by Err.
+func (dc *DCDD) Mount(drive int, filename string) error {
+	if drive < 0 || drive >= DCDD_DRIVES {
+		return fmt.Errorf("no drive %d", drive)
+	}
+	readOnly := false
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if os.IsPermission(err) {
+		f, err = os.Open(filename)
+		readOnly = true
+	}
+	if err != nil {
+		return err
+	}
+	return dc.mount(drive, f, readOnly)
+}
+
+func (dc *DCDD) mount(drive int, f *os.File, readOnly bool) error {
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size() != DCDD_DISK_SIZE {
+		f.Close()
+		return fmt.Errorf("%s: %d bytes is not an Altair disk image of %d bytes", f.Name(), info.Size(), DCDD_DISK_SIZE)
+	}
+	if err := dc.Unmount(drive); err != nil {
+		f.Close()
+		return err
+	}
+	dc.drives[drive] = &dcddDrive{f: f, readOnly: readOnly, pos: DCDD_SECTOR_SIZE}
+	return nil
+}
+
+func (dc *DCDD) Unmount(drive int) error {
+	d := dc.drives[drive]
+	if d == nil {
+		return nil
+	}
+	dc.drives[drive] = nil
+	err := d.flush()
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close unmounts every drive.
+func (dc *DCDD) Close() error {
+	var first error
+	for i := range dc.drives {
+		if err := dc.Unmount(i); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Err returns the first error writing an image, which the 8080 software
+// has no way to see.
+func (dc *DCDD) Err() error {
+	return dc.err
+}
+
+func (dc *DCDD) drive() *dcddDrive {
+	if dc.selected < 0 {
+		return nil
+	}
+	return dc.drives[dc.selected]
+}
+
+func (dc *DCDD) flush(d *dcddDrive) {
+	if err := d.flush(); err != nil && dc.err == nil {
+		dc.err = err
+	}
+}
+
+func (dc *DCDD) In(port uint8) uint8 {
+	d := dc.drive()
+	if d == nil {
+		return 0xFF
+	}
+	switch port {
+	case DCDD_SELECT:
+		return ^dc.status(d)
+	case DCDD_CONTROL:
+		if !d.head {
+			return 0xFF
+		}
+		dc.flush(d)
+		d.sector = (d.sector + 1) % DCDD_SECTORS
+		d.pos, d.writing = 0, false
+		if _, err := d.f.ReadAt(d.buf[:], d.offset()); err != nil && dc.err == nil {
+			dc.err = err
+		}
+		// Bit 0 is clear at the start of the sector.
+		return 0xC0 | uint8(d.sector)<<1
+	default:
+		if !d.head || d.writing || d.pos >= DCDD_SECTOR_SIZE {
+			return 0
+		}
+		d.pos++
+		return d.buf[d.pos-1]
+	}
+}
+
+func (dc *DCDD) status(d *dcddDrive) uint8 {
+	// Bits 3 and 4 are unused and read as 0.
+	s := uint8(DCDD_MH | 0x18)
+	if d.head {
+		s |= DCDD_HS
+		if !d.writing && d.pos < DCDD_SECTOR_SIZE {
+			s |= DCDD_NRDA
+		}
+	}
+	if d.writing && d.pos < DCDD_SECTOR_SIZE {
+		s |= DCDD_ENWD
+	}
+	if d.track == 0 {
+		s |= DCDD_TRACK0
+	}
+	return s
+}
+
+func (dc *DCDD) Out(port uint8, val uint8) {
+	if port == DCDD_SELECT {
+		if d := dc.drive(); d != nil {
+			dc.flush(d)
+		}
+		dc.selected = -1
+		if val&0x80 == 0 {
+			dc.selected = int(val & 0x0F)
+		}
+		return
+	}
+	d := dc.drive()
+	if d == nil {
+		return
+	}
+	if port == DCDD_DATA {
+		if d.writing && d.pos < DCDD_SECTOR_SIZE {
+			d.buf[d.pos] = val
+			d.pos++
+			d.dirty = true
+		}
+		return
+	}
+	if val&(DCDD_STEP_IN|DCDD_STEP_OUT) != 0 {
+		dc.flush(d)
+		if val&DCDD_STEP_IN != 0 && d.track < DCDD_TRACKS-1 {
+			d.track++
+		}
+		if val&DCDD_STEP_OUT != 0 && d.track > 0 {
+			d.track--
+		}
+		d.pos, d.writing = DCDD_SECTOR_SIZE, false
+	}
+	if val&DCDD_LOAD != 0 {
+		d.head = true
+	}
+	if val&DCDD_UNLOAD != 0 {
+		dc.flush(d)
+		d.head = false
+	}
+	if val&DCDD_WRITE != 0 {
+		d.pos, d.writing = 0, true
+	}
+}
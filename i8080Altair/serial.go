@@ -0,0 +1,61 @@
+package i8080Altair
+
+import "github.com/is386/Go8080/i8080/devices"
+
+// SIO is an 88-SIO serial board. Its status port has active low flags:
+// bit 0 is clear when a character has been received and bit 7 is clear when
+// the transmitter is ready.
+type SIO struct {
+	term   *devices.Console
+	status uint8
+	data   uint8
+}
+
+func NewSIO(term *devices.Console, status uint8, data uint8) *SIO {
+	return &SIO{term: term, status: status, data: data}
+}
+
+func (s *SIO) In(port uint8) uint8 {
+	if port == s.data {
+		return s.term.Read()
+	}
+	if s.term.Ready() {
+		return 0x7E
+	}
+	return 0x7F
+}
+
+func (s *SIO) Out(port uint8, val uint8) {
+	if port == s.data {
+		s.term.Write(val)
+	}
+}
+
+// ACIA is a port of an 88-2SIO board, a Motorola 6850. Its status register
+// has bit 0 set when a character has been received and bit 1 set when the
+// transmitter is empty. Writes to the control register are ignored.
+type ACIA struct {
+	term    *devices.Console
+	control uint8
+	data    uint8
+}
+
+func NewACIA(term *devices.Console, control uint8, data uint8) *ACIA {
+	return &ACIA{term: term, control: control, data: data}
+}
+
+func (a *ACIA) In(port uint8) uint8 {
+	if port == a.data {
+		return a.term.Read()
+	}
+	if a.term.Ready() {
+		return 0x03
+	}
+	return 0x02
+}
+
+func (a *ACIA) Out(port uint8, val uint8) {
+	if port == a.data {
+		a.term.Write(val)
+	}
+}
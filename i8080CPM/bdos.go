@@ -0,0 +1,521 @@
+package i8080CPM
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	RECORD_SIZE    = 128
+	EXTENT_RECORDS = 128
+	MODULE_EXTENTS = 32
+)
+
+// bdos serves the call in C with its argument in DE or E, returning a byte
+// in A and L, or a word in HL and BA.
+func (m *CPMMachine) bdos() {
+	c := m.cpu
+	reg := c.GetRegisters()
+	de := c.GetDE()
+	ret := func(val uint16) {
+		c.SetHL(val)
+		reg.A, reg.B = uint8(val), uint8(val>>8)
+	}
+
+	switch reg.C {
+	case 0: // System reset
+		m.running = false
+	case 1: // Console input
+		ch := m.readChar()
+		if m.echo {
+			m.writeChar(ch)
+		}
+		ret(uint16(ch))
+	case 2, 4, 5: // Console, punch and list output
+		m.writeChar(reg.E)
+	case 3: // Reader input
+		ret(0x1A)
+	case 6: // Direct console I/O
+		switch reg.E {
+		case 0xFF:
+			if m.consoleStatus() == 0 {
+				ret(0)
+			} else {
+				ret(uint16(m.readChar()))
+			}
+		case 0xFE:
+			ret(uint16(m.consoleStatus()))
+		default:
+			m.writeChar(reg.E)
+		}
+	case 7: // Get IOBYTE
+		ret(uint16(c.Read(IOBYTE)))
+	case 8: // Set IOBYTE
+		c.Write(IOBYTE, reg.E)
+	case 9: // Print string
+		for addr := de; c.Read(addr) != '$'; addr++ {
+			m.writeChar(c.Read(addr))
+		}
+	case 10: // Read console buffer
+		m.readLine(de)
+	case 11: // Console status
+		ret(uint16(m.consoleStatus()))
+	case 12: // Version number
+		ret(0x0022)
+	case 13: // Reset disk system
+		m.dma, m.disk = TAIL, 0
+		c.Write(DRIVE, m.user<<4)
+		ret(0)
+	case 14: // Select disk
+		m.disk = reg.E & 0x0F
+		m.login |= 1 << m.disk
+		c.Write(DRIVE, m.user<<4|m.disk)
+		ret(0)
+	case 15: // Open file
+		ret(m.open(de))
+	case 16: // Close file
+		ret(m.close(de))
+	case 17: // Search for first
+		m.search = m.match(de)
+		ret(m.searchNext())
+	case 18: // Search for next
+		ret(m.searchNext())
+	case 19: // Delete file
+		ret(m.delete(de))
+	case 20: // Read sequential
+		ret(m.readSequential(de))
+	case 21: // Write sequential
+		ret(m.writeSequential(de))
+	case 22: // Make file
+		ret(m.make(de))
+	case 23: // Rename file
+		ret(m.rename(de))
+	case 24: // Return login vector
+		ret(m.login)
+	case 25: // Return current disk
+		ret(uint16(m.disk))
+	case 26: // Set DMA address
+		m.dma = de
+	case 27: // Get allocation vector address
+		ret(ALV)
+	case 28, 30: // Write protect disk, set file attributes
+		ret(0)
+	case 29: // Get read only vector
+		ret(0)
+	case 31: // Get disk parameter block address
+		ret(DPB)
+	case 32: // Get or set user code
+		if reg.E == 0xFF {
+			ret(uint16(m.user))
+		} else {
+			m.user = reg.E & 0x0F
+			c.Write(DRIVE, m.user<<4|m.disk)
+		}
+	case 33: // Read random
+		ret(m.readRandom(de))
+	case 34, 40: // Write random, write random with zero fill
+		ret(m.writeRandom(de))
+	case 35: // Compute file size
+		ret(m.fileSize(de))
+	case 36: // Set random record
+		m.setRandom(de, m.position(de))
+	default:
+		ret(0xFF)
+	}
+}
+
+// readLine serves function 10. The buffer holds its size, the number of
+// characters read, and the characters.
+func (m *CPMMachine) readLine(addr uint16) {
+	max := int(m.cpu.Read(addr))
+	var line []uint8
+	for m.running {
+		ch := m.readChar()
+		if ch == '\r' || !m.running {
+			break
+		}
+		if (ch == 0x08 || ch == 0x7F) && len(line) > 0 {
+			line = line[:len(line)-1]
+			continue
+		}
+		if len(line) < max {
+			line = append(line, ch)
+		}
+	}
+	if m.echo {
+		for _, ch := range append(line, '\r', '\n') {
+			m.writeChar(ch)
+		}
+	}
+	m.cpu.Write(addr+1, uint8(len(line)))
+	m.cpu.Load(addr+2, line)
+}
+
+// An FCB is laid out as the drive, an 8 character name, a 3 character type,
+// the extent, two reserved bytes s1 and s2, the record count of the extent,
+// 16 allocation bytes, the current record and a 3 byte random record.
+const (
+	FCB_EX = 12
+	FCB_S2 = 14
+	FCB_RC = 15
+	FCB_CR = 32
+	FCB_R0 = 33
+)
+
+// fcbName returns the NAME.TYP of an FCB, which may contain ? wildcards.
+func (m *CPMMachine) fcbName(fcb uint16) string {
+	field := func(addr uint16, n int) string {
+		var s []uint8
+		for i := 0; i < n; i++ {
+			s = append(s, m.cpu.Read(addr+uint16(i))&0x7F)
+		}
+		return strings.TrimRight(string(s), " ")
+	}
+	name, typ := field(fcb+1, 8), field(fcb+9, 3)
+	if typ == "" {
+		return name
+	}
+	return name + "." + typ
+}
+
+// parseName fills the name and type of an FCB from NAME.TYP, expanding *
+// to ? as the CCP does.
+func (m *CPMMachine) parseName(fcb uint16, name string) {
+	name = strings.ToUpper(name)
+	drive := uint8(0)
+	if len(name) > 1 && name[1] == ':' {
+		drive = name[0] - 'A' + 1
+		name = name[2:]
+	}
+	base, typ := name, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		base, typ = name[:i], name[i+1:]
+	}
+	pad := func(s string, n int) []uint8 {
+		field := []uint8(strings.Repeat(" ", n))
+		for i := 0; i < len(s) && i < n; i++ {
+			if s[i] == '*' {
+				for j := i; j < n; j++ {
+					field[j] = '?'
+				}
+				break
+			}
+			field[i] = s[i]
+		}
+		return field
+	}
+	m.cpu.Write(fcb, drive)
+	m.cpu.Load(fcb+1, pad(base, 8))
+	m.cpu.Load(fcb+9, pad(typ, 3))
+}
+
+// find returns the host file that matches a CP/M name, ignoring case.
+func (m *CPMMachine) find(name string) (string, bool) {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return "", false
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.EqualFold(f.Name(), name) {
+			return f.Name(), true
+		}
+	}
+	return "", false
+}
+
+func (m *CPMMachine) path(fcb uint16) (string, bool) {
+	host, ok := m.find(m.fcbName(fcb))
+	return filepath.Join(m.dir, host), ok
+}
+
+// match returns the host files matching an FCB that may have wildcards.
+func (m *CPMMachine) match(fcb uint16) []string {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return nil
+	}
+	pattern := make([]uint8, 11)
+	for i := range pattern {
+		pattern[i] = m.cpu.Read(fcb+1+uint16(i)) & 0x7F
+	}
+	if m.cpu.Read(fcb) == '?' {
+		pattern = []uint8("???????????")
+	}
+	var matches []string
+	for _, f := range files {
+		name, ok := cpmName(f.Name())
+		if f.IsDir() || !ok {
+			continue
+		}
+		matched := true
+		for i := range pattern {
+			if pattern[i] != '?' && pattern[i] != name[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, f.Name())
+		}
+	}
+	sort.Slice(matches, func(i int, j int) bool { return strings.ToUpper(matches[i]) < strings.ToUpper(matches[j]) })
+	return matches
+}
+
+// cpmName returns a host file name as the 11 characters of an FCB, or false
+// when it is not a valid CP/M name. Path separators are never valid, so a
+// valid name always stays in the directory.
+func cpmName(host string) ([]uint8, bool) {
+	host = strings.ToUpper(host)
+	base, typ := host, ""
+	if i := strings.LastIndex(host, "."); i >= 0 {
+		base, typ = host[:i], host[i+1:]
+	}
+	if base == "" || len(base) > 8 || len(typ) > 3 || strings.ContainsAny(base+typ, ".,:;=*?<>[]/\\ ") {
+		return nil, false
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] < 0x20 || host[i] >= 0x7F {
+			return nil, false
+		}
+	}
+	return []uint8(base + strings.Repeat(" ", 8-len(base)) + typ + strings.Repeat(" ", 3-len(typ))), true
+}
+
+// searchNext writes the next directory entry of a search to the DMA buffer
+// and returns its index there, or FFH when there are no more.
+func (m *CPMMachine) searchNext() uint16 {
+	if len(m.search) == 0 {
+		return 0xFF
+	}
+	host := m.search[0]
+	m.search = m.search[1:]
+	name, _ := cpmName(host)
+	records := m.records(filepath.Join(m.dir, host))
+	entry := make([]uint8, 32)
+	entry[0] = m.user
+	copy(entry[1:], name)
+	last := 0
+	if records > 0 {
+		last = (records - 1) / EXTENT_RECORDS
+	}
+	entry[FCB_EX] = uint8(last % MODULE_EXTENTS)
+	entry[FCB_S2] = uint8(last / MODULE_EXTENTS)
+	entry[FCB_RC] = uint8(records - last*EXTENT_RECORDS)
+	m.cpu.Load(m.dma, entry)
+	return 0
+}
+
+func (m *CPMMachine) records(path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int((info.Size() + RECORD_SIZE - 1) / RECORD_SIZE)
+}
+
+// position returns the sequential record of an FCB from its current record,
+// extent and module.
+func (m *CPMMachine) position(fcb uint16) int {
+	s2, ex, cr := int(m.cpu.Read(fcb+FCB_S2)&0x3F), int(m.cpu.Read(fcb+FCB_EX)&0x1F), int(m.cpu.Read(fcb+FCB_CR)&0x7F)
+	return (s2*MODULE_EXTENTS+ex)*EXTENT_RECORDS + cr
+}
+
+// seek sets the current record, extent and module of an FCB, and the
+// record count of the extent.
+func (m *CPMMachine) seek(fcb uint16, record int, path string) {
+	extent := record / EXTENT_RECORDS
+	m.cpu.Write(fcb+FCB_CR, uint8(record%EXTENT_RECORDS))
+	m.cpu.Write(fcb+FCB_EX, uint8(extent%MODULE_EXTENTS))
+	m.cpu.Write(fcb+FCB_S2, uint8(extent/MODULE_EXTENTS))
+	rc := m.records(path) - extent*EXTENT_RECORDS
+	if rc < 0 {
+		rc = 0
+	} else if rc > EXTENT_RECORDS {
+		rc = EXTENT_RECORDS
+	}
+	m.cpu.Write(fcb+FCB_RC, uint8(rc))
+}
+
+func (m *CPMMachine) random(fcb uint16) int {
+	return int(m.cpu.Read(fcb+FCB_R0)) | int(m.cpu.Read(fcb+FCB_R0+1))<<8 | int(m.cpu.Read(fcb+FCB_R0+2))<<16
+}
+
+func (m *CPMMachine) setRandom(fcb uint16, record int) {
+	m.cpu.Load(fcb+FCB_R0, []uint8{uint8(record), uint8(record >> 8), uint8(record >> 16)})
+}
+
+func (m *CPMMachine) open(fcb uint16) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		return 0xFF
+	}
+	extent := int(m.cpu.Read(fcb+FCB_S2)&0x3F)*MODULE_EXTENTS + int(m.cpu.Read(fcb+FCB_EX)&0x1F)
+	cr := m.cpu.Read(fcb + FCB_CR)
+	m.seek(fcb, extent*EXTENT_RECORDS, path)
+	m.cpu.Write(fcb+FCB_CR, cr)
+	return 0
+}
+
+func (m *CPMMachine) close(fcb uint16) uint16 {
+	if _, ok := m.path(fcb); !ok {
+		return 0xFF
+	}
+	return 0
+}
+
+func (m *CPMMachine) make(fcb uint16) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		name := m.fcbName(fcb)
+		if _, valid := cpmName(name); !valid {
+			return 0xFF
+		}
+		path = filepath.Join(m.dir, name)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return 0xFF
+	}
+	f.Close()
+	m.seek(fcb, 0, path)
+	return 0
+}
+
+func (m *CPMMachine) delete(fcb uint16) uint16 {
+	matches := m.match(fcb)
+	for _, host := range matches {
+		os.Remove(filepath.Join(m.dir, host))
+	}
+	if len(matches) == 0 {
+		return 0xFF
+	}
+	return 0
+}
+
+// rename renames the file of an FCB to the name in its second half.
+func (m *CPMMachine) rename(fcb uint16) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		return 0xFF
+	}
+	to := m.fcbName(fcb + 16)
+	if _, valid := cpmName(to); !valid {
+		return 0xFF
+	}
+	if _, exists := m.find(to); exists {
+		return 0xFF
+	}
+	if os.Rename(path, filepath.Join(m.dir, to)) != nil {
+		return 0xFF
+	}
+	return 0
+}
+
+// readRecord reads a record into the DMA buffer, padding a short last
+// record with ^Z. It returns 1 past the end of the file.
+func (m *CPMMachine) readRecord(fcb uint16, record int) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		return 0xFF
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0xFF
+	}
+	defer f.Close()
+	buf := make([]uint8, RECORD_SIZE)
+	n, err := f.ReadAt(buf, int64(record)*RECORD_SIZE)
+	if n == 0 {
+		if err == io.EOF {
+			return 1
+		}
+		return 0xFF
+	}
+	for i := n; i < RECORD_SIZE; i++ {
+		buf[i] = 0x1A
+	}
+	m.cpu.Load(m.dma, buf)
+	return 0
+}
+
+func (m *CPMMachine) writeRecord(fcb uint16, record int) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		return 0xFF
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0xFF
+	}
+	defer f.Close()
+	buf := make([]uint8, RECORD_SIZE)
+	for i := range buf {
+		buf[i] = m.cpu.Read(m.dma + uint16(i))
+	}
+	if _, err := f.WriteAt(buf, int64(record)*RECORD_SIZE); err != nil {
+		return 2
+	}
+	return 0
+}
+
+func (m *CPMMachine) readSequential(fcb uint16) uint16 {
+	record := m.position(fcb)
+	result := m.readRecord(fcb, record)
+	if result == 0 {
+		path, _ := m.path(fcb)
+		m.seek(fcb, record+1, path)
+	}
+	return result
+}
+
+func (m *CPMMachine) writeSequential(fcb uint16) uint16 {
+	record := m.position(fcb)
+	result := m.writeRecord(fcb, record)
+	if result == 0 {
+		path, _ := m.path(fcb)
+		m.seek(fcb, record+1, path)
+	}
+	return result
+}
+
+// readRandom and writeRandom also move the sequential position to the
+// random record, without advancing it.
+func (m *CPMMachine) readRandom(fcb uint16) uint16 {
+	if m.cpu.Read(fcb+FCB_R0+2) != 0 {
+		return 6
+	}
+	record := m.random(fcb)
+	result := m.readRecord(fcb, record)
+	if result == 0 || result == 1 {
+		path, _ := m.path(fcb)
+		m.seek(fcb, record, path)
+	}
+	return result
+}
+
+func (m *CPMMachine) writeRandom(fcb uint16) uint16 {
+	if m.cpu.Read(fcb+FCB_R0+2) != 0 {
+		return 6
+	}
+	record := m.random(fcb)
+	result := m.writeRecord(fcb, record)
+	if result == 0 {
+		path, _ := m.path(fcb)
+		m.seek(fcb, record, path)
+	}
+	return result
+}
+
+func (m *CPMMachine) fileSize(fcb uint16) uint16 {
+	path, ok := m.path(fcb)
+	if !ok {
+		return 0xFF
+	}
+	m.setRandom(fcb, m.records(path))
+	return 0
+}
@@ -0,0 +1,257 @@
+package i8080CPM
+
+import (
+	"fmt"
+
+	"github.com/is386/Go8080/i8080/asm"
+)
+
+// BIOS_SOURCE is the BIOS of DiskMachine, after the skeleton CBIOS of the
+// CP/M 2.2 Alteration Guide. It is assembled at boot with the addresses of
+// the system and the ports defined in front of it.
+const BIOS_SOURCE = `
+NSECTS	EQU	(BIOS-CCP)/128	; sectors of CCP and BDOS on the system tracks
+IOBYTE	EQU	3
+CDISK	EQU	4
+
+	ORG	BIOS
+	JMP	BOOT
+WBOOTE:	JMP	WBOOT
+	JMP	CONST
+	JMP	CONIN
+	JMP	CONOUT
+	JMP	LIST
+	JMP	PUNCH
+	JMP	READER
+	JMP	HOME
+	JMP	SELDSK
+	JMP	SETTRK
+	JMP	SETSEC
+	JMP	SETDMA
+	JMP	READ
+	JMP	WRITE
+	JMP	LISTST
+	JMP	SECTRAN
+
+BOOT:	LXI	SP,80H
+	XRA	A
+	STA	IOBYTE
+	STA	CDISK
+	JMP	LOAD
+
+; WBOOT reloads the CCP and BDOS from the system tracks of drive A,
+; starting at track 0 sector 2.
+WBOOT:	LXI	SP,80H
+LOAD:	MVI	C,0
+	CALL	SELDSK
+	MOV	A,H
+	ORA	L
+	JZ	FAIL
+	CALL	HOME
+	MVI	B,NSECTS
+	MVI	C,0
+	MVI	D,2
+	LXI	H,CCP
+LOAD1:	PUSH	B
+	PUSH	D
+	PUSH	H
+	MOV	C,D
+	CALL	SETSEC
+	POP	B
+	PUSH	B
+	CALL	SETDMA
+	CALL	READ
+	ORA	A
+	JNZ	FAIL
+	POP	H
+	LXI	D,128
+	DAD	D
+	POP	D
+	POP	B
+	DCR	B
+	JZ	GOCPM
+	INR	D
+	MOV	A,D
+	CPI	SECTORS+1
+	JC	LOAD1
+	MVI	D,1
+	INR	C
+	PUSH	B
+	PUSH	D
+	PUSH	H
+	CALL	SETTRK
+	POP	H
+	POP	D
+	POP	B
+	JMP	LOAD1
+
+GOCPM:	MVI	A,0C3H
+	STA	0
+	LXI	H,WBOOTE
+	SHLD	1
+	STA	5
+	LXI	H,BDOS
+	SHLD	6
+	LXI	B,80H
+	CALL	SETDMA
+	LDA	CDISK
+	MOV	C,A
+	JMP	CCP
+
+FAIL:	LXI	H,BOOTERR
+FAIL1:	MOV	C,M
+	MOV	A,C
+	ORA	A
+	JZ	FAIL2
+	CALL	CONOUT
+	INX	H
+	JMP	FAIL1
+FAIL2:	HLT
+
+BOOTERR: DB	13,10,'BOOT ERROR',13,10,0
+
+CONST:	IN	CONSTAT
+	RET
+
+CONIN:	IN	CONDATA
+	ANI	7FH
+	RET
+
+CONOUT:	MOV	A,C
+	OUT	CONDATA
+	RET
+
+LISTST:	MVI	A,0FFH
+LIST:
+PUNCH:	RET
+
+READER:	MVI	A,1AH
+	RET
+
+HOME:	MVI	C,0
+SETTRK:	MOV	A,C
+	OUT	DSKTRK
+	RET
+
+SETSEC:	MOV	A,C
+	OUT	DSKSEC
+	RET
+
+SETDMA:	MOV	A,C
+	OUT	DMALO
+	MOV	A,B
+	OUT	DMAHI
+	RET
+
+; SELDSK returns the DPH of drive C in HL, or 0 when it holds no disk,
+; in which case the previous drive stays selected.
+SELDSK:	LXI	H,0
+	MOV	A,C
+	CPI	NDISKS
+	RNC
+	OUT	DSKSEL
+	IN	DSKSEL
+	ORA	A
+	JNZ	NODISK
+	MOV	A,C
+	STA	CURDSK
+	MOV	L,C
+	DAD	H
+	DAD	H
+	DAD	H
+	DAD	H
+	LXI	D,DPBASE
+	DAD	D
+	RET
+NODISK:	LDA	CURDSK
+	OUT	DSKSEL
+	RET
+
+CURDSK:	DB	0
+
+READ:	MVI	A,RDCMD
+	JMP	XFER
+WRITE:	MVI	A,WRCMD
+XFER:	OUT	DSKCMD
+	IN	DSKCMD
+	RET
+
+SECTRAN: MOV	A,D
+	ORA	E
+	JZ	NOXLT
+	XCHG
+	DAD	B
+	MOV	L,M
+	MVI	H,0
+	RET
+NOXLT:	INX	B
+	MOV	H,B
+	MOV	L,C
+	RET
+
+DPH	MACRO	N
+	DW	XLT,0,0,0,DIRBUF,DPB,CSV&N,ALV&N
+	ENDM
+
+DPBASE:	DPH	0
+	DPH	1
+	DPH	2
+	DPH	3
+
+XLT:	DB	1,7,13,19,25,5,11,17,23,3,9,15,21
+	DB	2,8,14,20,26,6,12,18,24,4,10,16,22
+
+DPB:	DW	SECTORS		; sectors per track
+	DB	3,7,0		; 1K blocks
+	DW	242		; blocks - 1
+	DW	63		; directory entries - 1
+	DB	0C0H,0
+	DW	16		; directory check size
+	DW	2		; reserved tracks
+
+DIRBUF:	DS	128
+ALV0:	DS	31
+ALV1:	DS	31
+ALV2:	DS	31
+ALV3:	DS	31
+CSV0:	DS	16
+CSV1:	DS	16
+CSV2:	DS	16
+CSV3:	DS	16
+BEND:
+	END
+`
+
+// assembleBIOS assembles BIOS_SOURCE for a CCP at ccp, with the BDOS and BIOS
+// where MOVCPM puts them.
+func assembleBIOS(ccp uint16) (*asm.Program, error) {
+	if uint32(ccp)+uint32(SYSTEM_SIZE) > 0xFFFF {
+		return nil, fmt.Errorf("the BIOS does not fit below 64K with the CCP at %04X", ccp)
+	}
+	header := fmt.Sprintf(`
+CCP	EQU	%d
+BDOS	EQU	%d
+BIOS	EQU	%d
+SECTORS	EQU	%d
+NDISKS	EQU	%d
+CONSTAT	EQU	%d
+CONDATA	EQU	%d
+DSKSEL	EQU	%d
+DSKTRK	EQU	%d
+DSKSEC	EQU	%d
+DMALO	EQU	%d
+DMAHI	EQU	%d
+DSKCMD	EQU	%d
+RDCMD	EQU	%d
+WRCMD	EQU	%d
+`, ccp, ccp+0x806, ccp+SYSTEM_SIZE, SECTORS, DRIVES, CONSOLE_STATUS, CONSOLE_DATA,
+		DISK_SELECT, DISK_TRACK, DISK_SECTOR, DISK_DMA_LOW, DISK_DMA_HIGH, DISK_COMMAND, DISK_READ, DISK_WRITE)
+	prog, err := asm.Assemble("bios.asm", []uint8(header+BIOS_SOURCE))
+	if err != nil {
+		return nil, err
+	}
+	if end := prog.Symbols["BEND"]; end < ccp+SYSTEM_SIZE && end != 0 {
+		return nil, fmt.Errorf("the BIOS does not fit below 64K with the CCP at %04X", ccp)
+	}
+	return prog, nil
+}
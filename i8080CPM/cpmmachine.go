@@ -0,0 +1,224 @@
+package i8080CPM
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+// The memory map of a 64K CP/M 2.2 system. The BDOS entry and the BIOS jump
+// table hold RETs and are trapped before they execute.
+var (
+	TPA        = uint16(0x0100)
+	BDOS       = uint16(0xE400)
+	BDOS_ENTRY = BDOS + 6
+	BIOS       = uint16(0xF200)
+	DPB        = BIOS + 0x80
+	ALV        = BIOS + 0x90
+	IOBYTE     = uint16(0x0003)
+	DRIVE      = uint16(0x0004)
+	FCB1       = uint16(0x005C)
+	FCB2       = uint16(0x006C)
+	TAIL       = uint16(0x0080)
+
+	// BIOS_ENTRIES is the number of entries in the BIOS jump table, from
+	// BOOT to SECTRAN.
+	BIOS_ENTRIES = 17
+
+	// DPB_DATA describes an 8" single density disk, for programs that ask
+	// for the disk parameters.
+	DPB_DATA = []uint8{26, 0, 3, 7, 0, 242, 0, 63, 0, 0xC0, 0, 16, 0, 2, 0}
+)
+
+// CPMMachine runs CP/M .COM programs by trapping calls to the BDOS and BIOS
+// and serving them from Go, with files kept in a host directory.
+type CPMMachine struct {
+	cpu     *i8080.CPU
+	dir     string
+	con     *devices.Console
+	echo    bool
+	running bool
+	dma     uint16
+	disk    uint8
+	user    uint8
+	login   uint16
+	search  []string
+}
+
+func NewCPMMachine(dir string, in io.Reader, out io.Writer) *CPMMachine {
+	m := &CPMMachine{dir: dir, con: devices.NewConsole(in, out), echo: true, running: true, dma: TAIL, login: 1}
+	m.cpu = i8080.NewCPU(TPA, i8080.NewFlatMemory(0, 64*1024), m)
+	m.cpu.SetSP(BDOS)
+	m.cpu.Write(0x0000, 0xC3)
+	m.write16(0x0001, BIOS+3)
+	m.cpu.Write(0x0005, 0xC3)
+	m.write16(0x0006, BDOS_ENTRY)
+	m.cpu.Write(BDOS_ENTRY, 0xC9)
+	for i := 0; i < BIOS_ENTRIES; i++ {
+		m.cpu.Write(BIOS+uint16(i*3), 0xC9)
+	}
+	m.cpu.Load(DPB, DPB_DATA)
+	return m
+}
+
+// SetEcho controls whether console input is echoed, as CP/M does. It should
+// be off when the host terminal already echoes.
+func (m *CPMMachine) SetEcho(echo bool) {
+	m.echo = echo
+}
+
+// LoadFile loads a .COM program at TPA and sets up the command tail and the
+// default FCBs from args, as the CCP does.
+func (m *CPMMachine) LoadFile(filename string, args []string) error {
+	program, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if len(program) > int(BDOS-TPA) {
+		return fmt.Errorf("%s does not fit in the TPA", filename)
+	}
+	m.Load(program, args)
+	return nil
+}
+
+func (m *CPMMachine) Load(program []uint8, args []string) {
+	m.cpu.Load(TPA, program)
+	tail := ""
+	if len(args) > 0 {
+		tail = " " + strings.ToUpper(strings.Join(args, " "))
+	}
+	if len(tail) > 127 {
+		tail = tail[:127]
+	}
+	m.cpu.Write(TAIL, uint8(len(tail)))
+	m.cpu.Load(TAIL+1, []uint8(tail))
+	for i, fcb := range []uint16{FCB1, FCB2} {
+		name := ""
+		if i < len(args) {
+			name = args[i]
+		}
+		m.cpu.Load(fcb, make([]uint8, 16))
+		m.parseName(fcb, name)
+	}
+}
+
+// ResolveProgram finds a program either as a host path or as a .COM file in
+// the directory.
+func (m *CPMMachine) ResolveProgram(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	if !strings.Contains(name, ".") {
+		name += ".COM"
+	}
+	if host, ok := m.find(name); ok {
+		return filepath.Join(m.dir, host), nil
+	}
+	return "", fmt.Errorf("%s: no such program", name)
+}
+
+func (m *CPMMachine) GetCPU() *i8080.CPU {
+	return m.cpu
+}
+
+func (m *CPMMachine) Run() {
+	for m.Step() {
+	}
+}
+
+// Step executes one instruction, or serves a BDOS or BIOS call, and reports
+// whether the program is still running.
+func (m *CPMMachine) Step() bool {
+	pc := m.cpu.GetPC()
+	if pc == BDOS_ENTRY {
+		m.bdos()
+		m.ret()
+	} else if pc >= BIOS && pc < BIOS+uint16(BIOS_ENTRIES*3) && (pc-BIOS)%3 == 0 {
+		m.bios(int(pc-BIOS) / 3)
+		m.ret()
+	} else {
+		m.cpu.Execute()
+	}
+	if m.cpu.IsHalted() || m.con.Starved() {
+		m.running = false
+	}
+	return m.running
+}
+
+func (m *CPMMachine) Debugger() *debugger.Debugger {
+	return debugger.New(m.cpu, m.Step)
+}
+
+func (m *CPMMachine) ret() {
+	sp := m.cpu.GetSP()
+	m.cpu.SetPC(m.read16(sp))
+	m.cpu.SetSP(sp + 2)
+}
+
+func (m *CPMMachine) bios(fn int) {
+	reg := m.cpu.GetRegisters()
+	switch fn {
+	case 0, 1: // BOOT, WBOOT
+		m.running = false
+	case 2: // CONST
+		reg.A = m.consoleStatus()
+	case 3: // CONIN
+		reg.A = m.readChar()
+	case 4, 5, 6: // CONOUT, LIST, PUNCH
+		m.writeChar(reg.C)
+	case 7: // READER
+		reg.A = 0x1A
+	case 9: // SELDSK
+		m.cpu.SetHL(0)
+	case 13, 14: // READ, WRITE
+		reg.A = 1
+	case 15: // LISTST
+		reg.A = 0xFF
+	case 16: // SECTRAN
+		m.cpu.SetHL(m.cpu.GetBC())
+	}
+}
+
+func (m *CPMMachine) In(port uint8) uint8 {
+	return 0
+}
+
+func (m *CPMMachine) Out(port uint8, val uint8) {}
+
+func (m *CPMMachine) read16(addr uint16) uint16 {
+	return uint16(m.cpu.Read(addr)) | uint16(m.cpu.Read(addr+1))<<8
+}
+
+func (m *CPMMachine) write16(addr uint16, val uint16) {
+	m.cpu.Write(addr, uint8(val))
+	m.cpu.Write(addr+1, uint8(val>>8))
+}
+
+func (m *CPMMachine) writeChar(c uint8) {
+	m.con.Write(c)
+}
+
+// readChar reads a console character. At the end of the input the program
+// is stopped, since it would wait forever.
+func (m *CPMMachine) readChar() uint8 {
+	c, ok := m.con.Wait()
+	if !ok {
+		m.running = false
+		return 0x1A
+	}
+	return c
+}
+
+func (m *CPMMachine) consoleStatus() uint8 {
+	if m.con.Ready() {
+		return 0xFF
+	}
+	return 0
+}
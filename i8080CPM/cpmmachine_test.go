@@ -0,0 +1,334 @@
+package i8080CPM
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080/asm"
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+// PROGRAM is run as "TEST OUT.TXT *.TXT". It writes two records to OUT.TXT,
+// reads them back sequentially and at random, lists *.TXT, renames OUT.TXT
+// to NEW.TXT, deletes OLD.TXT and echoes a line of input.
+const PROGRAM = `
+BDOS	EQU	5
+FCB1	EQU	5CH
+FCB2	EQU	6CH
+BUF	EQU	80H
+
+	ORG	100H
+	LXI	D,HELLO
+	MVI	C,9
+	CALL	BDOS
+
+	; Keep the second name, which the FCB1 data overwrites.
+	LXI	H,FCB2
+	LXI	D,PAT
+	MVI	B,12
+	CALL	COPY
+
+	LXI	D,FCB1
+	MVI	C,22
+	CALL	BDOS
+	MVI	A,'A'
+	CALL	FILL
+	LXI	D,FCB1
+	MVI	C,21
+	CALL	BDOS
+	MVI	A,'B'
+	CALL	FILL
+	LXI	D,FCB1
+	MVI	C,21
+	CALL	BDOS
+	LXI	D,FCB1
+	MVI	C,16
+	CALL	BDOS
+
+	XRA	A
+	STA	FCB1+12
+	STA	FCB1+32
+	LXI	D,FCB1
+	MVI	C,15
+	CALL	BDOS
+	LXI	D,FCB1
+	MVI	C,20
+	CALL	BDOS
+	LDA	BUF
+	CALL	PUTC
+	LXI	D,FCB1
+	MVI	C,20
+	CALL	BDOS
+	LDA	BUF+127
+	CALL	PUTC
+	LXI	D,FCB1
+	MVI	C,20
+	CALL	BDOS
+	ADI	'0'
+	CALL	PUTC
+
+	MVI	A,1
+	STA	FCB1+33
+	LXI	D,FCB1
+	MVI	C,33
+	CALL	BDOS
+	LDA	BUF
+	CALL	PUTC
+	LXI	D,FCB1
+	MVI	C,35
+	CALL	BDOS
+	LDA	FCB1+33
+	ADI	'0'
+	CALL	PUTC
+	CALL	CRLF
+
+	LXI	D,PAT
+	MVI	C,17
+LIST:	CALL	BDOS
+	CPI	0FFH
+	JZ	LISTED
+	LXI	H,BUF+1
+	MVI	B,11
+NAME:	MOV	A,M
+	PUSH	H
+	PUSH	B
+	CALL	PUTC
+	POP	B
+	POP	H
+	INX	H
+	DCR	B
+	JNZ	NAME
+	CALL	CRLF
+	MVI	C,18
+	JMP	LIST
+
+LISTED:	LXI	H,FCB1
+	LXI	D,FCB1+16
+	MVI	B,12
+	CALL	COPY
+	LXI	H,NEWNAME
+	LXI	D,FCB1+17
+	MVI	B,11
+	CALL	COPY
+	LXI	D,FCB1
+	MVI	C,23
+	CALL	BDOS
+	LXI	D,OLD
+	MVI	C,19
+	CALL	BDOS
+
+	LXI	D,LINE
+	MVI	C,10
+	CALL	BDOS
+	LDA	LINE+1
+	ADI	'0'
+	CALL	PUTC
+	LDA	LINE+2
+	CALL	PUTC
+	MVI	C,0
+	JMP	BDOS
+
+; FILL fills the DMA buffer with A.
+FILL:	LXI	H,BUF
+	MVI	B,128
+FILL1:	MOV	M,A
+	INX	H
+	DCR	B
+	JNZ	FILL1
+	RET
+
+; COPY copies B bytes from HL to DE.
+COPY:	MOV	A,M
+	STAX	D
+	INX	H
+	INX	D
+	DCR	B
+	JNZ	COPY
+	RET
+
+PUTC:	MOV	E,A
+	MVI	C,2
+	JMP	BDOS
+
+CRLF:	MVI	A,13
+	CALL	PUTC
+	MVI	A,10
+	JMP	PUTC
+
+HELLO:	DB	'HELLO',13,10,'$'
+NEWNAME: DB	'NEW     TXT'
+OLD:	DB	0,'OLD     TXT'
+	DS	24
+PAT:	DS	36
+LINE:	DB	10,0
+	DS	10
+	END
+`
+
+func TestCPMMachine(t *testing.T) {
+	prog, err := asm.Assemble("test.asm", []uint8(PROGRAM))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, image := prog.Binary()
+
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "old.txt"), []uint8("old"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var out bytes.Buffer
+	m := NewCPMMachine(dir, strings.NewReader("hi\n"), &out)
+	m.Load(image, []string{"out.txt", "*.txt"})
+	m.Run()
+
+	want := "HELLO\r\nAB1B2\r\nOLD     TXT\r\nOUT     TXT\r\nhi\r\n2h"
+	if out.String() != want {
+		t.Errorf("[output] expected: %q, actual: %q", want, out.String())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "NEW.TXT"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(data, append(bytes.Repeat([]uint8("A"), 128), bytes.Repeat([]uint8("B"), 128)...)) {
+		t.Errorf("[NEW.TXT] unexpected contents %q", data)
+	}
+	for _, name := range []string{"old.txt", "OUT.TXT"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("[%s] expected the file to be gone", name)
+		}
+	}
+}
+
+func TestConsoleStatus(t *testing.T) {
+	// Wait for a key with the console status before reading it.
+	src := `
+	ORG	100H
+WAIT:	MVI	C,11
+	CALL	5
+	ORA	A
+	JZ	WAIT
+	MVI	C,1
+	CALL	5
+	MVI	C,0
+	JMP	5
+	END
+`
+	prog, err := asm.Assemble("status.asm", []uint8(src))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, image := prog.Binary()
+	// Without input the program is stopped once it has polled for long
+	// enough.
+	for _, in := range []string{"x", ""} {
+		var out bytes.Buffer
+		m := NewCPMMachine(".", strings.NewReader(in), &out)
+		m.Load(image, nil)
+		running := true
+		for i := 0; i < 100*devices.EOF_POLLS && running; i++ {
+			running = m.Step()
+		}
+		if running || out.String() != in {
+			t.Errorf("[status %q] expected: %q and stopped, actual: %q, running %v", in, in, out.String(), running)
+		}
+	}
+}
+
+func TestBadNames(t *testing.T) {
+	parent, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "dir")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "OLD.TXT"), nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	// The name ".", typed "/EV", would be ../EV on the host.
+	m := NewCPMMachine(dir, strings.NewReader(""), ioutil.Discard)
+	m.cpu.Load(FCB1, []uint8("\x00.       /EV"))
+	if r := m.make(FCB1); r != 0xFF {
+		t.Errorf("[make] expected: FF, actual: %02X", r)
+	}
+	m.parseName(FCB1, "old.txt")
+	m.cpu.Load(FCB1+16, []uint8("\x00.       /EV"))
+	if r := m.rename(FCB1); r != 0xFF {
+		t.Errorf("[rename] expected: FF, actual: %02X", r)
+	}
+	m.cpu.Load(FCB1+16, []uint8("\x00NEW\x01    TXT"))
+	if r := m.rename(FCB1); r != 0xFF {
+		t.Errorf("[rename control] expected: FF, actual: %02X", r)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "EV")); !os.IsNotExist(err) {
+		t.Errorf("[escape] expected no file outside the directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "OLD.TXT")); err != nil {
+		t.Errorf("[rename] expected OLD.TXT to be kept: %v", err)
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "DATA.BIN"), make([]uint8, 200), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m := NewCPMMachine(dir, strings.NewReader(""), ioutil.Discard)
+	tests := []struct {
+		name   string
+		result uint16
+		size   int
+	}{
+		{"data.bin", 0, 2},
+		{"missing.bin", 0xFF, 0},
+	}
+	for _, tt := range tests {
+		m.parseName(FCB1, tt.name)
+		m.setRandom(FCB1, 0)
+		if r := m.fileSize(FCB1); r != tt.result {
+			t.Errorf("[%s] expected: %02X, actual: %02X", tt.name, tt.result, r)
+		}
+		if size := m.random(FCB1); size != tt.size {
+			t.Errorf("[%s size] expected: %d, actual: %d", tt.name, tt.size, size)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	m := NewCPMMachine(".", strings.NewReader(""), ioutil.Discard)
+	tests := []struct {
+		name string
+		fcb  string
+	}{
+		{"test.com", "\x00TEST    COM"},
+		{"b:a*.?", "\x02A????????  "},
+		{"readme", "\x00README     "},
+	}
+	for _, tt := range tests {
+		m.parseName(FCB1, tt.name)
+		fcb := make([]uint8, 12)
+		for i := range fcb {
+			fcb[i] = m.cpu.Read(FCB1 + uint16(i))
+		}
+		if string(fcb) != tt.fcb {
+			t.Errorf("[%s] expected: %q, actual: %q", tt.name, tt.fcb, fcb)
+		}
+	}
+}
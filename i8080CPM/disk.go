@@ -0,0 +1,200 @@
+package i8080CPM
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+// The geometry of an IBM 3740 8" single sided, single density disk.
+const (
+	TRACKS      = 77
+	SECTORS     = 26
+	SECTOR_SIZE = 128
+	DISK_SIZE   = TRACKS * SECTORS * SECTOR_SIZE
+)
+
+// Disk is a disk image whose sectors are stored in order, track by track,
+// starting with sector 1. Writes go straight to the image file.
+type Disk struct {
+	f        *os.File
+	readOnly bool
+}
+
+// OpenDisk opens an image for reading and writing, or only for reading when
+// the file is read only.
+func OpenDisk(filename string) (*Disk, error) {
+	d := &Disk{}
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if os.IsPermission(err) {
+		f, err = os.Open(filename)
+		d.readOnly = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() != DISK_SIZE {
+		f.Close()
+		return nil, fmt.Errorf("%s: %d bytes is not an IBM 3740 image of %d bytes", filename, info.Size(), DISK_SIZE)
+	}
+	d.f = f
+	return d, nil
+}
+
+func (d *Disk) offset(track uint8, sector uint8) (int64, error) {
+	if track >= TRACKS || sector < 1 || sector > SECTORS {
+		return 0, fmt.Errorf("no track %d sector %d", track, sector)
+	}
+	return (int64(track)*SECTORS + int64(sector) - 1) * SECTOR_SIZE, nil
+}
+
+func (d *Disk) ReadSector(track uint8, sector uint8, buf []uint8) error {
+	off, err := d.offset(track, sector)
+	if err != nil {
+		return err
+	}
+	_, err = d.f.ReadAt(buf[:SECTOR_SIZE], off)
+	return err
+}
+
+func (d *Disk) WriteSector(track uint8, sector uint8, buf []uint8) error {
+	if d.readOnly {
+		return fmt.Errorf("%s is read only", d.f.Name())
+	}
+	off, err := d.offset(track, sector)
+	if err != nil {
+		return err
+	}
+	_, err = d.f.WriteAt(buf[:SECTOR_SIZE], off)
+	return err
+}
+
+func (d *Disk) Close() error {
+	return d.f.Close()
+}
+
+// The ports of DiskController. A drive is selected by writing its number to
+// DISK_SELECT, which then reads 0 when a disk is inserted. A command written
+// to DISK_COMMAND transfers a sector between the DMA address and the disk,
+// and DISK_COMMAND then reads 0 on success.
+var (
+	DISK_SELECT   = uint8(0x0A)
+	DISK_TRACK    = uint8(0x0B)
+	DISK_SECTOR   = uint8(0x0C)
+	DISK_DMA_LOW  = uint8(0x0D)
+	DISK_DMA_HIGH = uint8(0x0E)
+	DISK_COMMAND  = uint8(0x0F)
+
+	DISK_READ  = uint8(0)
+	DISK_WRITE = uint8(1)
+
+	DRIVES = 4
+)
+
+// DiskController is a DMA floppy controller with up to DRIVES drives.
+type DiskController struct {
+	mem    i8080.Memory
+	drives []*Disk
+	drive  uint8
+	track  uint8
+	sector uint8
+	dma    uint16
+	status uint8
+}
+
+// NewDiskController returns a controller that transfers sectors to and from
+// mem, which is usually the CPU.
+func NewDiskController(mem i8080.Memory) *DiskController {
+	return &DiskController{mem: mem, drives: make([]*Disk, DRIVES)}
+}
+
+// Insert puts d in a drive, replacing and returning the disk that was there.
+func (dc *DiskController) Insert(drive int, d *Disk) *Disk {
+	old := dc.drives[drive]
+	dc.drives[drive] = d
+	return old
+}
+
+func (dc *DiskController) Close() error {
+	var first error
+	for i, d := range dc.drives {
+		if d != nil {
+			if err := d.Close(); err != nil && first == nil {
+				first = err
+			}
+			dc.drives[i] = nil
+		}
+	}
+	return first
+}
+
+func (dc *DiskController) In(port uint8) uint8 {
+	switch port {
+	case DISK_SELECT:
+		if dc.disk() == nil {
+			return 0xFF
+		}
+		return 0
+	case DISK_COMMAND:
+		return dc.status
+	}
+	return 0xFF
+}
+
+func (dc *DiskController) Out(port uint8, val uint8) {
+	switch port {
+	case DISK_SELECT:
+		dc.drive = val
+	case DISK_TRACK:
+		dc.track = val
+	case DISK_SECTOR:
+		dc.sector = val
+	case DISK_DMA_LOW:
+		dc.dma = dc.dma&0xFF00 | uint16(val)
+	case DISK_DMA_HIGH:
+		dc.dma = dc.dma&0x00FF | uint16(val)<<8
+	case DISK_COMMAND:
+		dc.status = 1
+		if err := dc.transfer(val); err == nil {
+			dc.status = 0
+		}
+	}
+}
+
+func (dc *DiskController) disk() *Disk {
+	if int(dc.drive) >= len(dc.drives) {
+		return nil
+	}
+	return dc.drives[dc.drive]
+}
+
+func (dc *DiskController) transfer(cmd uint8) error {
+	d := dc.disk()
+	if d == nil {
+		return fmt.Errorf("no disk in drive %d", dc.drive)
+	}
+	buf := make([]uint8, SECTOR_SIZE)
+	switch cmd {
+	case DISK_READ:
+		if err := d.ReadSector(dc.track, dc.sector, buf); err != nil {
+			return err
+		}
+		for i, b := range buf {
+			dc.mem.Write(dc.dma+uint16(i), b)
+		}
+	case DISK_WRITE:
+		for i := range buf {
+			buf[i] = dc.mem.Read(dc.dma + uint16(i))
+		}
+		return d.WriteSector(dc.track, dc.sector, buf)
+	default:
+		return fmt.Errorf("unknown command %d", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package i8080CPM
+
+import (
+	"io"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+// The console ports of DiskMachine. CONSOLE_STATUS reads FFH when input is
+// waiting, and reading CONSOLE_DATA waits for a character.
+var (
+	CONSOLE_STATUS = uint8(0x00)
+	CONSOLE_DATA   = uint8(0x01)
+
+	// CCP is where the CCP runs in a 64K system, as built by MOVCPM 64, and
+	// is where Boot usually puts it. The BDOS follows it and the BIOS starts
+	// SYSTEM_SIZE bytes after it.
+	CCP         = uint16(0xE400)
+	SYSTEM_SIZE = uint16(0x1600)
+)
+
+// DiskMachine boots CP/M 2.2 from IBM 3740 disk images. The CCP and BDOS
+// are loaded from the system tracks of drive A by a BIOS of our own, which
+// drives a DiskController and a console on I/O ports.
+type DiskMachine struct {
+	cpu     *i8080.CPU
+	disks   *DiskController
+	con     *devices.Console
+	running bool
+}
+
+func NewDiskMachine(in io.Reader, out io.Writer) *DiskMachine {
+	m := &DiskMachine{con: devices.NewConsole(in, out), running: true}
+	ports := i8080.NewPorts()
+	m.cpu = i8080.NewCPU(0, i8080.NewFlatMemory(0, 64*1024), ports)
+	m.disks = NewDiskController(m.cpu)
+	ports.Attach(m, CONSOLE_STATUS, CONSOLE_DATA)
+	ports.Attach(m.disks, DISK_SELECT, DISK_TRACK, DISK_SECTOR, DISK_DMA_LOW, DISK_DMA_HIGH, DISK_COMMAND)
+	return m
+}
+
+// Mount opens a disk image in drive 0 to 3, A to D.
+func (m *DiskMachine) Mount(drive int, filename string) error {
+	d, err := OpenDisk(filename)
+	if err != nil {
+		return err
+	}
+	if old := m.disks.Insert(drive, d); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Boot loads the BIOS for a system whose CCP is at ccp, such as CCP for one
+// built for 64K, and starts it at its cold boot entry, which loads the rest
+// of the system from drive A.
+func (m *DiskMachine) Boot(ccp uint16) error {
+	prog, err := assembleBIOS(ccp)
+	if err != nil {
+		return err
+	}
+	prog.LoadInto(m.cpu)
+	m.cpu.Reset(ccp + SYSTEM_SIZE)
+	m.running = true
+	return nil
+}
+
+// Close closes the disk images.
+func (m *DiskMachine) Close() error {
+	return m.disks.Close()
+}
+
+func (m *DiskMachine) GetCPU() *i8080.CPU {
+	return m.cpu
+}
+
+func (m *DiskMachine) Run() {
+	for m.Step() {
+	}
+}
+
+// Step executes one instruction and reports whether the machine is still
+// running. It stops on HLT and at the end of the console input, once the
+// system reads it or keeps polling for more.
+func (m *DiskMachine) Step() bool {
+	m.cpu.Execute()
+	if m.cpu.IsHalted() || m.con.Starved() {
+		m.running = false
+	}
+	return m.running
+}
+
+func (m *DiskMachine) Debugger() *debugger.Debugger {
+	return debugger.New(m.cpu, m.Step)
+}
+
+func (m *DiskMachine) In(port uint8) uint8 {
+	if port == CONSOLE_STATUS {
+		if m.con.Ready() {
+			return 0xFF
+		}
+		return 0
+	}
+	c, ok := m.con.Wait()
+	if !ok {
+		m.running = false
+		return 0x1A
+	}
+	return c
+}
+
+func (m *DiskMachine) Out(port uint8, val uint8) {
+	if port == CONSOLE_DATA {
+		m.con.Write(val)
+	}
+}
@@ -0,0 +1,555 @@
+package i8080CPM
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/is386/Go8080/i8080/asm"
+	"github.com/is386/Go8080/i8080/devices"
+)
+
+// SYSTEM stands in for the CCP on the system tracks. It prints the last byte
+// loaded from them, echoes a character, writes a sector to drive B, prints
+// the first byte of track 2 of drive A and whether drive C is missing, and
+// reads the sector again from drive A, which stays selected. It then warm
+// boots, and halts when started again.
+const SYSTEM = `
+CONIN	EQU	BIOS+9
+CONOUT	EQU	BIOS+12
+SELDSK	EQU	BIOS+27
+SETTRK	EQU	BIOS+30
+SETSEC	EQU	BIOS+33
+SETDMA	EQU	BIOS+36
+READ	EQU	BIOS+39
+WRITE	EQU	BIOS+42
+SECTRAN	EQU	BIOS+48
+BUF	EQU	80H
+DONE	EQU	100H
+
+	ORG	CCP
+	LXI	SP,BUF
+	LDA	BIOS-1
+	CALL	PUTC
+	LDA	DONE
+	ORA	A
+	JZ	FIRST
+	MVI	A,'!'
+	CALL	PUTC
+	HLT
+
+FIRST:	INR	A
+	STA	DONE
+	CALL	CONIN
+	CALL	PUTC
+
+	MVI	C,1
+	CALL	SELDSK
+	MOV	E,M
+	INX	H
+	MOV	D,M
+	LXI	B,0
+	CALL	SECTRAN
+	PUSH	H
+	MVI	C,2
+	CALL	SETTRK
+	POP	B
+	PUSH	B
+	CALL	SETSEC
+	LXI	H,BUF
+	MVI	B,128
+FILL:	MVI	M,'W'
+	INX	H
+	DCR	B
+	JNZ	FILL
+	LXI	B,BUF
+	CALL	SETDMA
+	CALL	WRITE
+	ORA	A
+	JNZ	FAIL
+
+	MVI	C,0
+	CALL	SELDSK
+	MVI	C,2
+	CALL	SETTRK
+	POP	B
+	CALL	SETSEC
+	CALL	READ
+	ORA	A
+	JNZ	FAIL
+	LDA	BUF
+	CALL	PUTC
+
+	MVI	C,2
+	CALL	SELDSK
+	MOV	A,H
+	ORA	L
+	ADI	'0'
+	CALL	PUTC
+	XRA	A
+	STA	BUF
+	CALL	READ
+	ORA	A
+	JNZ	FAIL
+	LDA	BUF
+	CALL	PUTC
+	JMP	0
+
+FAIL:	MVI	A,'?'
+	CALL	PUTC
+	HLT
+
+PUTC:	MOV	C,A
+	JMP	CONOUT
+	END
+`
+
+func TestDiskMachine(t *testing.T) {
+	header := fmt.Sprintf("CCP EQU %d\nBIOS EQU %d\n", CCP, CCP+SYSTEM_SIZE)
+	prog, err := asm.Assemble("system.asm", []uint8(header+SYSTEM))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, system := prog.Binary()
+
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	track2 := 2 * SECTORS * SECTOR_SIZE
+	a := bytes.Repeat([]uint8{0xE5}, DISK_SIZE)
+	copy(a[SECTOR_SIZE:], system)
+	a[SECTOR_SIZE+int(SYSTEM_SIZE)-1] = 'Z'
+	a[track2] = 'R'
+	images := []string{filepath.Join(dir, "a.dsk"), filepath.Join(dir, "b.dsk")}
+	for i, image := range [][]uint8{a, bytes.Repeat([]uint8{0xE5}, DISK_SIZE)} {
+		if err := ioutil.WriteFile(images[i], image, 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	m := NewDiskMachine(strings.NewReader("x"), &out)
+	for i, image := range images {
+		if err := m.Mount(i, image); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	if err := m.Boot(CCP); err != nil {
+		t.Fatalf("%v", err)
+	}
+	m.Run()
+	if err := m.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if want := "ZxR0RZ!"; out.String() != want {
+		t.Errorf("[output] expected: %q, actual: %q", want, out.String())
+	}
+	b, err := ioutil.ReadFile(images[1])
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Equal(b[track2:track2+SECTOR_SIZE], bytes.Repeat([]uint8("W"), SECTOR_SIZE)) {
+		t.Errorf("[b.dsk] track 2 sector 1 was not written back")
+	}
+}
+
+func TestDiskMachineNoSystem(t *testing.T) {
+	var out bytes.Buffer
+	m := NewDiskMachine(strings.NewReader(""), &out)
+	if err := m.Boot(CCP); err != nil {
+		t.Fatalf("%v", err)
+	}
+	m.Run()
+	if want := "\r\nBOOT ERROR\r\n"; out.String() != want {
+		t.Errorf("[output] expected: %q, actual: %q", want, out.String())
+	}
+}
+
+func TestDiskMachineStarved(t *testing.T) {
+	// The system waits for a key with CONST, which never comes.
+	src := fmt.Sprintf("CCP EQU %d\nBIOS EQU %d\n", CCP, CCP+SYSTEM_SIZE) + `
+	ORG	CCP
+WAIT:	CALL	BIOS+6
+	ORA	A
+	JZ	WAIT
+	HLT
+	END
+`
+	prog, err := asm.Assemble("wait.asm", []uint8(src))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, system := prog.Binary()
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	a := bytes.Repeat([]uint8{0xE5}, DISK_SIZE)
+	copy(a[SECTOR_SIZE:], system)
+	image := filepath.Join(dir, "a.dsk")
+	if err := ioutil.WriteFile(image, a, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	m := NewDiskMachine(strings.NewReader(""), ioutil.Discard)
+	defer m.Close()
+	if err := m.Mount(0, image); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := m.Boot(CCP); err != nil {
+		t.Fatalf("%v", err)
+	}
+	running := true
+	for i := 0; i < 100*devices.EOF_POLLS && running; i++ {
+		running = m.Step()
+	}
+	if running || m.GetCPU().IsHalted() {
+		t.Errorf("[starved] expected: stopped waiting, actual: running %v, halted %v", running, m.GetCPU().IsHalted())
+	}
+}
+
+// LOGIN stands in for the CCP and does what the BDOS does to log in drive A
+// and open a file: it takes the XLT, DPB, directory buffer and ALV from the
+// DPH, reads the directory records through SECTRAN, marks the blocks of
+// every entry in the ALV and prints the first record of HELLO.TXT.
+const LOGIN = `
+CONOUT	EQU	BIOS+12
+SELDSK	EQU	BIOS+27
+SETTRK	EQU	BIOS+30
+SETSEC	EQU	BIOS+33
+SETDMA	EQU	BIOS+36
+READ	EQU	BIOS+39
+SECTRAN	EQU	BIOS+48
+BUF	EQU	80H
+
+	ORG	CCP
+	LXI	SP,BUF
+	MVI	C,0
+	CALL	SELDSK
+	SHLD	DPH
+	CALL	WORD
+	SHLD	XLT
+	LXI	D,8
+	CALL	FIELD
+	SHLD	DIRBUF
+	LXI	D,10
+	CALL	FIELD
+	SHLD	DPB
+	LXI	D,14
+	CALL	FIELD
+	SHLD	ALV
+
+	LHLD	DPB
+	CALL	WORD
+	SHLD	SPT
+	LHLD	DPB
+	INX	H
+	INX	H
+	MOV	A,M
+	STA	BSH
+	LHLD	DPB
+	LXI	D,7
+	DAD	D
+	CALL	WORD
+	INX	H
+	MVI	B,2		; (DRM+1)/4 directory records
+QUART:	ORA	A
+	MOV	A,H
+	RAR
+	MOV	H,A
+	MOV	A,L
+	RAR
+	MOV	L,A
+	DCR	B
+	JNZ	QUART
+	SHLD	RECS
+	LHLD	DPB
+	LXI	D,9		; AL0 and AL1 reserve the directory blocks
+	DAD	D
+	MOV	A,M
+	INX	H
+	MOV	B,M
+	LHLD	ALV
+	MOV	M,A
+	INX	H
+	MOV	M,B
+	LHLD	DPB
+	LXI	D,13
+	DAD	D
+	CALL	WORD
+	SHLD	OFF
+
+	LXI	H,0
+	SHLD	REC
+DIRLP:	LHLD	REC
+	XCHG
+	CALL	SEEK
+	LHLD	DIRBUF
+	MOV	B,H
+	MOV	C,L
+	CALL	SETDMA
+	CALL	READ
+	ORA	A
+	JNZ	FAIL
+	LHLD	DIRBUF
+	MVI	B,4
+ENTLP:	PUSH	B
+	PUSH	H
+	MOV	A,M
+	CPI	0E5H
+	CNZ	ENTRY
+	POP	H
+	LXI	D,32
+	DAD	D
+	POP	B
+	DCR	B
+	JNZ	ENTLP
+	LHLD	REC
+	INX	H
+	SHLD	REC
+	XCHG
+	LHLD	RECS
+	MOV	A,E
+	CMP	L
+	JNZ	DIRLP
+	MOV	A,D
+	CMP	H
+	JNZ	DIRLP
+
+	LDA	FOUND
+	ORA	A
+	JZ	FAIL
+	LDA	BLOCK
+	MOV	L,A
+	MVI	H,0
+	LDA	BSH
+	MOV	B,A
+BLKSEC:	DAD	H
+	DCR	B
+	JNZ	BLKSEC
+	XCHG
+	CALL	SEEK
+	LXI	B,BUF
+	CALL	SETDMA
+	CALL	READ
+	ORA	A
+	JNZ	FAIL
+	LXI	H,BUF
+PRINT:	MOV	A,M
+	CPI	'$'
+	JZ	DONE
+	PUSH	H
+	MOV	C,A
+	CALL	CONOUT
+	POP	H
+	INX	H
+	JMP	PRINT
+FAIL:	MVI	C,'?'
+	CALL	CONOUT
+DONE:	HLT
+
+; FIELD returns the word at DE in the DPH.
+FIELD:	LHLD	DPH
+	DAD	D
+; WORD returns the word at HL.
+WORD:	MOV	E,M
+	INX	H
+	MOV	D,M
+	XCHG
+	RET
+
+; SEEK sets the track and sector of logical sector DE of the data area.
+SEEK:	PUSH	D
+	LHLD	OFF
+	SHLD	TRK
+	LHLD	SPT
+	XCHG
+	POP	H
+SEEK1:	MOV	A,L
+	SUB	E
+	MOV	C,A
+	MOV	A,H
+	SBB	D
+	JC	SEEK2
+	MOV	H,A
+	MOV	L,C
+	PUSH	H
+	LHLD	TRK
+	INX	H
+	SHLD	TRK
+	POP	H
+	JMP	SEEK1
+SEEK2:	PUSH	H
+	LHLD	TRK
+	MOV	B,H
+	MOV	C,L
+	CALL	SETTRK
+	POP	B
+	LHLD	XLT
+	XCHG
+	CALL	SECTRAN
+	MOV	B,H
+	MOV	C,L
+	JMP	SETSEC
+
+; ENTRY marks the blocks of the directory entry at HL in the ALV, and
+; keeps the first block of HELLO.TXT.
+ENTRY:	PUSH	H
+	INX	H
+	LXI	D,NAME
+	MVI	B,11
+CMPLP:	LDAX	D
+	CMP	M
+	JNZ	MARK
+	INX	H
+	INX	D
+	DCR	B
+	JNZ	CMPLP
+	POP	H
+	PUSH	H
+	LXI	D,16
+	DAD	D
+	MOV	A,M
+	STA	BLOCK
+	MVI	A,1
+	STA	FOUND
+MARK:	POP	H
+	LXI	D,16
+	DAD	D
+	MVI	B,16
+MARK1:	MOV	A,M
+	ORA	A
+	CNZ	SETBIT
+	INX	H
+	DCR	B
+	JNZ	MARK1
+	RET
+
+; SETBIT sets the bit of block A in the ALV.
+SETBIT:	PUSH	H
+	PUSH	B
+	MOV	B,A
+	ANI	7
+	MOV	C,A
+	MVI	A,80H
+SETBIT1: DCR	C
+	JM	SETBIT2
+	RRC
+	JMP	SETBIT1
+SETBIT2: MOV	C,A
+	MOV	A,B
+	RRC
+	RRC
+	RRC
+	ANI	1FH
+	MOV	E,A
+	MVI	D,0
+	LHLD	ALV
+	DAD	D
+	MOV	A,M
+	ORA	C
+	MOV	M,A
+	POP	B
+	POP	H
+	RET
+
+NAME:	DB	'HELLO   TXT'
+FOUND:	DB	0
+BLOCK:	DB	0
+DPH:	DW	0
+XLT:	DW	0
+DIRBUF:	DW	0
+DPB:	DW	0
+ALV:	DW	0
+SPT:	DW	0
+BSH:	DB	0
+RECS:	DW	0
+OFF:	DW	0
+REC:	DW	0
+TRK:	DW	0
+	END
+`
+
+// TestDirectory boots a system built for 48K and logs in a directory that
+// is laid out the way CP/M writes it, with the sectors of the data tracks
+// skewed by 6.
+func TestDirectory(t *testing.T) {
+	ccp := uint16(0xA400)
+	header := fmt.Sprintf("CCP EQU %d\nBIOS EQU %d\n", ccp, ccp+SYSTEM_SIZE)
+	prog, err := asm.Assemble("login.asm", []uint8(header+LOGIN))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	_, system := prog.Binary()
+
+	var skew [SECTORS]int
+	used := make([]bool, SECTORS)
+	for i, s := 0, 0; i < SECTORS; i++ {
+		for used[s] {
+			s = (s + 1) % SECTORS
+		}
+		skew[i], used[s] = s, true
+		s = (s + 6) % SECTORS
+	}
+	image := bytes.Repeat([]uint8{0xE5}, DISK_SIZE)
+	// record returns logical record n of the data area, after the two
+	// system tracks.
+	record := func(n int) []uint8 {
+		off := ((2+n/SECTORS)*SECTORS + skew[n%SECTORS]) * SECTOR_SIZE
+		return image[off : off+SECTOR_SIZE]
+	}
+	copy(image[SECTOR_SIZE:], system)
+	entry := func(rec int, i int, name string, blocks ...uint8) {
+		e := record(rec)[i*32 : i*32+32]
+		for j := range e {
+			e[j] = 0
+		}
+		copy(e[1:], name)
+		e[15] = uint8(len(blocks) * 8)
+		copy(e[16:], blocks)
+	}
+	entry(0, 1, "OTHER   DAT", 3, 4)
+	entry(5, 2, "HELLO   TXT", 2)
+	copy(record(2*8), "HELLO FROM A FILE$")
+
+	dir, err := ioutil.TempDir("", "cpm")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.dsk")
+	if err := ioutil.WriteFile(filename, image, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var out bytes.Buffer
+	m := NewDiskMachine(strings.NewReader(""), &out)
+	defer m.Close()
+	if err := m.Mount(0, filename); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := m.Boot(ccp); err != nil {
+		t.Fatalf("%v", err)
+	}
+	m.Run()
+	if want := "HELLO FROM A FILE"; out.String() != want {
+		t.Errorf("[file] expected: %q, actual: %q", want, out.String())
+	}
+	bios, err := assembleBIOS(ccp)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	// Blocks 0 and 1 hold the directory.
+	alv := bios.Symbols["ALV0"]
+	if a := m.GetCPU().Read(alv); a != 0xF8 {
+		t.Errorf("[ALV] expected: F8, actual: %02X", a)
+	}
+}
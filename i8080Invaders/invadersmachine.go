@@ -1,9 +1,13 @@
 package i8080Invaders
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
 	"os"
 
 	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -15,15 +19,25 @@ var (
 type InvadersMachine struct {
 	cpu                             *i8080.CPU
 	screen                          *Screen
+	romHash                         [sha256.Size]uint8
+	half                            uint8
 	port1, port2                    uint8
 	shiftMsb, shiftLsb, shiftOffset uint8
 }
 
 func NewInvadersMachine() *InvadersMachine {
 	im := &InvadersMachine{screen: NewScreen()}
-	cpu := i8080.NewCPU(0x0, 0x2000, 0x4000, im.PortIn, im.PortOut)
-	cpu.LoadRom(FILE)
-	im.cpu = cpu
+	rom, err := ioutil.ReadFile(FILE)
+	if err != nil {
+		panic(err)
+	}
+	im.romHash = sha256.Sum256(rom)
+	ram := i8080.NewRAM(0x2000)
+	bus := i8080.NewBus()
+	bus.Map(0x0000, 0x2000, i8080.ROM(rom))
+	bus.Map(0x2000, 0x2000, ram)
+	bus.Map(0x4000, 0xC000, ram)
+	im.cpu = i8080.NewCPU(0x0, bus, im)
 	return im
 }
 
@@ -44,6 +58,10 @@ func (im *InvadersMachine) pollSDL() bool {
 		case *sdl.KeyboardEvent:
 			switch e.Type {
 			case sdl.KEYDOWN:
+				if slot, ok := SLOTS[e.Keysym.Sym]; ok {
+					im.hotkey(slot, e.Keysym.Mod&sdl.KMOD_SHIFT != 0)
+					continue
+				}
 				im.keyDown(e.Keysym.Sym)
 			case sdl.KEYUP:
 				im.keyUp(e.Keysym.Sym)
@@ -54,24 +72,54 @@ func (im *InvadersMachine) pollSDL() bool {
 }
 
 func (im *InvadersMachine) runCPU() {
-	for im.cpu.GetCycles() < CPS/2 {
-		im.cpu.Execute()
+	for !im.Step() {
 	}
-	if im.cpu.IsInterrupted() {
-		im.cpu.Interrupt(0x8)
+	im.screen.Draw(im)
+	im.screen.Update()
+}
+
+// Debugger attaches a debugger that keeps the screen and keyboard running
+// while the game is continued, and stops the machine when the window closes.
+func (im *InvadersMachine) Debugger() *debugger.Debugger {
+	return debugger.New(im.cpu, func() bool {
+		if !im.Step() {
+			return true
+		}
+		im.screen.Draw(im)
+		im.screen.Update()
+		return im.pollSDL()
+	})
+}
+
+// Step executes one instruction, raising the mid-screen and vblank
+// interrupts as their cycles come up, and reports whether a frame ended.
+func (im *InvadersMachine) Step() bool {
+	im.cpu.Execute()
+	if im.half == 0 && im.cpu.GetCycles() >= CPS/2 {
+		im.cpu.Interrupt(i8080.RST(1))
+		im.half = 1
+	} else if im.half == 1 && im.cpu.GetCycles() >= CPS {
+		im.cpu.Interrupt(i8080.RST(2))
+		im.cpu.SubtractCycles(int(CPS))
+		im.half = 0
+		return true
 	}
-	for im.cpu.GetCycles() < CPS {
-		im.cpu.Execute()
+	return false
+}
+
+func (im *InvadersMachine) hotkey(slot int, save bool) {
+	var err error
+	if save {
+		err = im.SaveSlot(slot)
+	} else {
+		err = im.LoadSlot(slot)
 	}
-	if im.cpu.IsInterrupted() {
-		im.cpu.Interrupt(0x10)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slot %d: %v\n", slot, err)
 	}
-	im.cpu.SubtractCycles(int(CPS))
-	im.screen.Draw(im)
-	im.screen.Update()
 }
 
-func (im *InvadersMachine) PortIn(port uint8) {
+func (im *InvadersMachine) In(port uint8) uint8 {
 	val := uint8(0xFF)
 	switch port {
 	case 0:
@@ -86,19 +134,16 @@ func (im *InvadersMachine) PortIn(port uint8) {
 	case 6:
 		val = 0
 	}
-	reg := im.cpu.GetRegisters()
-	reg.A = val
+	return val
 }
 
-func (im *InvadersMachine) PortOut(port uint8) {
-	reg := im.cpu.GetRegisters()
-	a := reg.A
+func (im *InvadersMachine) Out(port uint8, val uint8) {
 	switch port {
 	case 2:
-		im.shiftOffset = a & 7
+		im.shiftOffset = val & 7
 	case 4:
 		im.shiftLsb = im.shiftMsb
-		im.shiftMsb = a
+		im.shiftMsb = val
 	case 6:
 		break
 	}
@@ -0,0 +1,62 @@
+package i8080Invaders
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/is386/Go8080/i8080Invaders/snapshot"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	SAVES = "i8080Invaders/saves"
+	SLOTS = map[sdl.Keycode]int{
+		sdl.K_F1: 1, sdl.K_F2: 2, sdl.K_F3: 3, sdl.K_F4: 4,
+		sdl.K_F5: 5, sdl.K_F6: 6, sdl.K_F7: 7, sdl.K_F8: 8,
+	}
+)
+
+func (im *InvadersMachine) SaveState(w io.Writer) error {
+	return snapshot.Save(w, snapshot.Machine{
+		RomHash: im.romHash, Half: im.half, Port1: im.port1, Port2: im.port2,
+		ShiftMsb: im.shiftMsb, ShiftLsb: im.shiftLsb, ShiftOffset: im.shiftOffset,
+	}, im.cpu)
+}
+
+func (im *InvadersMachine) LoadState(r io.Reader) error {
+	m, err := snapshot.Load(r, im.romHash, im.cpu)
+	if err != nil {
+		return err
+	}
+	im.half = m.Half
+	im.port1, im.port2 = m.Port1, m.Port2
+	im.shiftMsb, im.shiftLsb, im.shiftOffset = m.ShiftMsb, m.ShiftLsb, m.ShiftOffset
+	return nil
+}
+
+func slotFile(slot int) string {
+	return filepath.Join(SAVES, fmt.Sprintf("slot%d.sav", slot))
+}
+
+func (im *InvadersMachine) SaveSlot(slot int) error {
+	if err := os.MkdirAll(SAVES, 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := im.SaveState(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(slotFile(slot), buf.Bytes(), 0644)
+}
+
+func (im *InvadersMachine) LoadSlot(slot int) error {
+	data, err := ioutil.ReadFile(slotFile(slot))
+	if err != nil {
+		return err
+	}
+	return im.LoadState(bytes.NewReader(data))
+}
@@ -86,7 +86,7 @@ func (s *Screen) Draw(im *InvadersMachine) {
 	for i := 0; i < (HEIGHT * WIDTH / 8); i++ {
 		y0 := i * 8 / HEIGHT
 		x0 := (i * 8) % HEIGHT
-		curByte := im.cpu.GetMemory()[0x2400+i]
+		curByte := im.cpu.Read(uint16(0x2400 + i))
 
 		for bit := uint8(0); bit < 8; bit++ {
 			x := int32(x0 + int(bit))
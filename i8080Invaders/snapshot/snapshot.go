@@ -0,0 +1,90 @@
+// Package snapshot encodes Space Invaders save states. It is kept apart
+// from the machine, which needs SDL, so that it can be tested anywhere.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+// A snapshot is the machine header below followed by a CPU save state:
+//
+//	magic    [4]byte  "SINV"
+//	version  uint16   VERSION at the time of saving
+//	romHash  [32]byte SHA-256 of the ROM the snapshot was made with
+//	half     uint8    0 before the mid-screen interrupt, 1 before vblank
+//	port1, port2, shiftMsb, shiftLsb, shiftOffset  uint8
+//	checksum uint32   CRC-32 (IEEE) of the header fields above
+const (
+	MAGIC   = "SINV"
+	VERSION = 1
+)
+
+var (
+	ErrMagic    = errors.New("invaders: not a snapshot")
+	ErrVersion  = errors.New("invaders: unsupported snapshot version")
+	ErrChecksum = errors.New("invaders: snapshot checksum mismatch")
+	ErrRom      = errors.New("invaders: snapshot was made with a different ROM")
+)
+
+// Machine is the state of the cabinet outside of the CPU.
+type Machine struct {
+	RomHash                         [32]uint8
+	Half                            uint8
+	Port1, Port2                    uint8
+	ShiftMsb, ShiftLsb, ShiftOffset uint8
+}
+
+type header struct {
+	Magic   [4]byte
+	Version uint16
+	Machine
+}
+
+func Save(w io.Writer, m Machine, cpu *i8080.CPU) error {
+	hdr := header{Version: VERSION, Machine: m}
+	copy(hdr.Magic[:], MAGIC)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return cpu.SaveState(w)
+}
+
+// Load restores the CPU from a snapshot made with the ROM that hashes to
+// romHash, and returns the rest of the machine.
+func Load(r io.Reader, romHash [32]uint8, cpu *i8080.CPU) (Machine, error) {
+	raw := make([]uint8, binary.Size(&header{}))
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return Machine{}, err
+	}
+	var sum uint32
+	if err := binary.Read(r, binary.LittleEndian, &sum); err != nil {
+		return Machine{}, err
+	}
+	var hdr header
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, &hdr)
+	if string(hdr.Magic[:]) != MAGIC {
+		return Machine{}, ErrMagic
+	}
+	if hdr.Version < 1 || hdr.Version > VERSION {
+		return Machine{}, ErrVersion
+	}
+	if sum != crc32.ChecksumIEEE(raw) {
+		return Machine{}, ErrChecksum
+	}
+	if hdr.RomHash != romHash {
+		return Machine{}, ErrRom
+	}
+	if err := cpu.LoadState(r); err != nil {
+		return Machine{}, err
+	}
+	return hdr.Machine, nil
+}
@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+func save(t *testing.T, m Machine) []uint8 {
+	cpu := i8080.NewCPU(0x0, nil, nil)
+	cpu.Load(0x2000, []uint8{0x12, 0x34})
+	cpu.SetPC(0x1A5C)
+	cpu.SetHL(0xBEEF)
+	var buf bytes.Buffer
+	if err := Save(&buf, m, cpu); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := Machine{RomHash: [32]uint8{1, 2, 3}, Half: 1, Port1: 0x21, Port2: 0x40, ShiftMsb: 0xAB, ShiftLsb: 0xCD, ShiftOffset: 3}
+	data := save(t, m)
+
+	cpu := i8080.NewCPU(0x0, nil, nil)
+	loaded, err := Load(bytes.NewReader(data), m.RomHash, cpu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != m {
+		t.Errorf("[machine] expected: %+v, actual: %+v", m, loaded)
+	}
+	if cpu.GetPC() != 0x1A5C || cpu.GetHL() != 0xBEEF {
+		t.Errorf("[registers] expected: 1A5C BEEF, actual: %04X %04X", cpu.GetPC(), cpu.GetHL())
+	}
+	if cpu.Read(0x2000) != 0x12 || cpu.Read(0x2001) != 0x34 {
+		t.Errorf("[memory] expected: 12 34, actual: %02X %02X", cpu.Read(0x2000), cpu.Read(0x2001))
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	hash := [32]uint8{1, 2, 3}
+	// The header is the magic, version, ROM hash, six machine bytes and the
+	// checksum.
+	tests := []struct {
+		name    string
+		corrupt func([]uint8) []uint8
+		hash    [32]uint8
+		err     error
+	}{
+		{"magic", func(d []uint8) []uint8 { d[0] = 'X'; return d }, hash, ErrMagic},
+		{"version", func(d []uint8) []uint8 { d[4] = 9; return d }, hash, ErrVersion},
+		{"checksum", func(d []uint8) []uint8 { d[40] ^= 0xFF; return d }, hash, ErrChecksum},
+		{"rom", func(d []uint8) []uint8 { return d }, [32]uint8{4}, ErrRom},
+		{"truncated", func(d []uint8) []uint8 { return d[:20] }, hash, io.ErrUnexpectedEOF},
+		{"cpu", func(d []uint8) []uint8 { d[48] = 'X'; return d }, hash, i8080.ErrStateMagic},
+	}
+	for _, tt := range tests {
+		data := tt.corrupt(save(t, Machine{RomHash: hash}))
+		cpu := i8080.NewCPU(0x0, nil, nil)
+		if _, err := Load(bytes.NewReader(data), tt.hash, cpu); err != tt.err {
+			t.Errorf("[%s] expected: %v, actual: %v", tt.name, tt.err, err)
+		}
+		if cpu.GetPC() != 0 {
+			t.Errorf("[%s] expected the CPU to be untouched, actual: PC %04X", tt.name, cpu.GetPC())
+		}
+	}
+}
@@ -2,8 +2,12 @@ package i8080Test
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/debugger"
+	"github.com/is386/Go8080/i8080/trace"
 )
 
 type TestMachine struct {
@@ -11,12 +15,14 @@ type TestMachine struct {
 	cycles     int
 	instrCount int
 	running    bool
-	showDebug  bool
+	tracer     *trace.Tracer
+	blocks     *i8080.BlockCache
+	out        io.Writer
 }
 
 func NewTestMachine(filename string, showDebug bool) *TestMachine {
-	tm := TestMachine{showDebug: showDebug, running: true}
-	cpu := i8080.NewCPU(0x100, 0, 64*1024, tm.portIn, tm.portOut)
+	tm := TestMachine{running: true, out: os.Stdout}
+	cpu := i8080.NewCPU(0x100, i8080.NewFlatMemory(0, 64*1024), &tm)
 	cpu.LoadRom(filename)
 	cpu.Write(0x0, 0xD3)
 	cpu.Write(0x1, 0x0)
@@ -24,54 +30,94 @@ func NewTestMachine(filename string, showDebug bool) *TestMachine {
 	cpu.Write(0x6, 0x01)
 	cpu.Write(0x7, 0xC9)
 	tm.cpu = cpu
+	if showDebug {
+		tm.SetTracer(trace.New(os.Stdout, trace.SUPERZAZU))
+	}
 	return &tm
 }
 
+// SetTracer logs every instruction to t, which Run flushes when the ROM
+// finishes.
+func (tm *TestMachine) SetTracer(t *trace.Tracer) {
+	tm.tracer = t
+	t.Attach(tm.cpu)
+}
+
+// SetOutput sends the ROM's console output to w instead of stdout.
+func (tm *TestMachine) SetOutput(w io.Writer) {
+	tm.out = w
+}
+
+// UseBlockCache runs the ROM with the block cache instead of CPU.Execute.
+func (tm *TestMachine) UseBlockCache() {
+	tm.blocks = i8080.NewBlockCache(tm.cpu)
+}
+
 func (tm *TestMachine) Run() {
-	for tm.running {
-		if tm.showDebug {
-			tm.printState()
+	if tm.blocks != nil {
+		for tm.running {
+			tm.blocks.Run(1 << 20)
+			if tm.cpu.IsHalted() {
+				tm.running = false
+			}
+			tm.cycles = tm.cpu.GetCycles()
+		}
+	} else {
+		for tm.Step() {
 		}
+	}
+	if tm.tracer != nil {
+		tm.tracer.Flush()
+	}
+	fmt.Fprint(tm.out, "\n\n")
+}
+
+// Step executes one instruction and reports whether the ROM is still running.
+func (tm *TestMachine) Step() bool {
+	if tm.blocks != nil {
+		tm.blocks.Execute()
+	} else {
 		tm.cpu.Execute()
-		tm.instrCount++
+	}
+	tm.instrCount++
+	if tm.cpu.IsHalted() {
+		tm.running = false
 	}
 	tm.cycles = tm.cpu.GetCycles()
-	fmt.Print("\n\n")
+	return tm.running
 }
 
-func (tm *TestMachine) portIn(port uint8) {
+func (tm *TestMachine) GetCPU() *i8080.CPU {
+	return tm.cpu
 }
 
-func (tm *TestMachine) portOut(port uint8) {
+func (tm *TestMachine) Debugger() *debugger.Debugger {
+	return debugger.New(tm.cpu, tm.Step)
+}
+
+// In leaves A as it was, since the test ROMs have no input devices.
+func (tm *TestMachine) In(port uint8) uint8 {
+	return tm.cpu.GetRegisters().A
+}
+
+func (tm *TestMachine) Out(port uint8, val uint8) {
 	if port == 0 {
 		tm.running = false
+		if tm.blocks != nil {
+			tm.blocks.Stop()
+		}
 	} else if port == 1 {
 		reg := tm.cpu.GetRegisters()
 		if reg.C == 9 {
 			offset := tm.cpu.GetDE()
-			mem := tm.cpu.GetMemory()
-			str := mem[offset]
+			str := tm.cpu.Read(offset)
 			for str != '$' {
-				fmt.Printf("%c", str)
+				fmt.Fprintf(tm.out, "%c", str)
 				offset += 1
-				str = mem[offset]
+				str = tm.cpu.Read(offset)
 			}
 		} else if reg.C == 2 {
-			fmt.Printf("%c", reg.E)
+			fmt.Fprintf(tm.out, "%c", reg.E)
 		}
 	}
 }
-
-func (tm *TestMachine) printState() {
-	mem := tm.cpu.GetMemory()
-	pc := tm.cpu.GetPC()
-	sp := tm.cpu.GetSP()
-	cyc := tm.cpu.GetCycles()
-	af := tm.cpu.GetAF()
-	bc := tm.cpu.GetBC()
-	de := tm.cpu.GetDE()
-	hl := tm.cpu.GetHL()
-
-	fmt.Printf("\nPC: %04X, AF: %04X, BC: %04X, DE: %04X, HL: %04X, SP: %04X, CYC: %04d	(%02X %02X %02X %02X)",
-		pc, af, bc, de, hl, sp, cyc, mem[pc], mem[pc+1], mem[pc+2], mem[pc+3])
-}
@@ -1,7 +1,17 @@
 package i8080Test
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/is386/Go8080/i8080"
+	"github.com/is386/Go8080/i8080/trace"
 )
 
 var (
@@ -10,40 +20,195 @@ var (
 	CPUTEST  = "roms/CPUTEST.COM"
 	i8080EXM = "roms/8080EXM.COM"
 	DEBUG    = false
+
+	TRACE        = flag.String("trace-dir", "", "directory to write a trace of each ROM to")
+	TRACE_FORMAT = flag.String("trace-format", "superzazu", "trace format: superzazu, doctor or verbose")
+	TRACE_GZIP   = flag.Bool("trace-gzip", false, "gzip the traces")
+	TRACE_COUNT  = flag.Uint64("trace-count", 0, "trace at most this many instructions of each ROM")
+	TRACE_WRITES = flag.Bool("trace-writes", false, "add the memory written by each instruction to the traces")
+
+	VECTORS = flag.String("vectors", "vectors", "directory of the single-step vectors, one XX.json per opcode")
 )
 
-func TestTST8080(t *testing.T) {
-	cycles := 4924
-	tm := NewTestMachine(TST8080, DEBUG)
-	tm.Run()
-	if tm.cycles != cycles {
-		t.Errorf("[cycles] expected: %d, actual: %d", cycles, tm.cycles)
+func newTestMachine(t *testing.T, rom string, blocks bool) *TestMachine {
+	tm := NewTestMachine(rom, DEBUG)
+	if blocks {
+		tm.UseBlockCache()
+	}
+	if *TRACE == "" {
+		return tm
 	}
+	format, err := trace.ParseFormat(*TRACE_FORMAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimSuffix(filepath.Base(rom), ".COM")
+	if blocks {
+		name += "-blocks"
+	}
+	name += ".log"
+	if *TRACE_GZIP {
+		name += ".gz"
+	}
+	tracer, err := trace.Create(filepath.Join(*TRACE, name), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.SetCount(0, *TRACE_COUNT)
+	tracer.SetWrites(*TRACE_WRITES)
+	tm.SetTracer(tracer)
+	t.Cleanup(func() {
+		if err := tracer.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return tm
 }
 
-func Test8080PRE(t *testing.T) {
-	cycles := 7817
-	tm := NewTestMachine(i8080PRE, DEBUG)
-	tm.Run()
-	if tm.cycles != cycles {
-		t.Errorf("[cycles] expected: %d, actual: %d", cycles, tm.cycles)
+// parseOutput returns the errors a ROM reported, naming the instruction
+// group of a failed 8080EXM CRC, or that success was never printed.
+func parseOutput(output string, success string) []string {
+	var errors []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.Index(line, "ERROR ****"); i >= 0 {
+			var want, got string
+			fmt.Sscanf(line[i:], "ERROR **** crc expected:%s found:%s", &want, &got)
+			group := strings.TrimRight(line[:i], ". ")
+			errors = append(errors, fmt.Sprintf("[%s] crc expected: %s, actual: %s", group, want, got))
+		} else if strings.Contains(line, "ERROR") || strings.Contains(line, "FAILED") {
+			errors = append(errors, fmt.Sprintf("[output] %s", line))
+		}
 	}
+	if !strings.Contains(output, success) {
+		errors = append(errors, fmt.Sprintf("[output] expected: %q, actual: %q", success, output))
+	}
+	return errors
 }
 
-func TestCPUTEST(t *testing.T) {
-	cycles := 255653383
-	tm := NewTestMachine(CPUTEST, DEBUG)
-	tm.Run()
-	if tm.cycles != cycles {
-		t.Errorf("[cycles] expected: %d, actual: %d", cycles, tm.cycles)
+// testROM runs a ROM with CPU.Execute and with the block cache in parallel.
+// Both must print success without errors and take the same cycles.
+func testROM(t *testing.T, rom string, cycles int, success string) {
+	t.Parallel()
+	for _, blocks := range []bool{false, true} {
+		blocks := blocks
+		name := "execute"
+		if blocks {
+			name = "blocks"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			tm := newTestMachine(t, rom, blocks)
+			tm.SetOutput(&out)
+			tm.Run()
+			t.Log(out.String())
+			for _, err := range parseOutput(out.String(), success) {
+				t.Error(err)
+			}
+			if tm.cycles != cycles {
+				t.Errorf("[cycles] expected: %d, actual: %d", cycles, tm.cycles)
+			}
+		})
 	}
 }
 
+func TestTST8080(t *testing.T) {
+	testROM(t, TST8080, 4924, "CPU IS OPERATIONAL")
+}
+
+func Test8080PRE(t *testing.T) {
+	testROM(t, i8080PRE, 7817, "8080 Preliminary tests complete")
+}
+
+func TestCPUTEST(t *testing.T) {
+	testROM(t, CPUTEST, 255653383, "CPU TESTS OK")
+}
+
 func Test8080EXM(t *testing.T) {
-	cycles := 23803381171
-	tm := NewTestMachine(i8080EXM, DEBUG)
+	testROM(t, i8080EXM, 23803381171, "Tests complete")
+}
+
+func TestParseOutput(t *testing.T) {
+	output := "8080 instruction exerciser\r\n" +
+		"dad <b,d,h,sp>................  PASS! crc is:14474ba6\r\n" +
+		"aluop nn......................  ERROR **** crc expected:9e922f9e found:12345678\r\n" +
+		"Tests complete\r\n"
+	errors := parseOutput(output, "Tests complete")
+	expected := "[aluop nn] crc expected: 9e922f9e, actual: 12345678"
+	if len(errors) != 1 || errors[0] != expected {
+		t.Errorf("[exm] expected: %q, actual: %q", expected, errors)
+	}
+
+	errors = parseOutput(" CPU HAS FAILED!    ERROR EXIT=07\r\n", "CPU IS OPERATIONAL")
+	if len(errors) != 2 {
+		t.Errorf("[tst8080] expected: 2 errors, actual: %q", errors)
+	}
+}
+
+func TestPortIn(t *testing.T) {
+	// MVI A,42H; IN 10H; OUT 0
+	rom := filepath.Join(t.TempDir(), "in.com")
+	if err := ioutil.WriteFile(rom, []uint8{0x3E, 0x42, 0xDB, 0x10, 0xD3, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTestMachine(rom, false)
+	tm.SetOutput(ioutil.Discard)
 	tm.Run()
-	if tm.cycles != cycles {
-		t.Errorf("[cycles] expected: %d, actual: %d", cycles, tm.cycles)
+	if a := tm.GetCPU().GetRegisters().A; a != 0x42 {
+		t.Errorf("[in] expected: %02X, actual: %02X", 0x42, a)
+	}
+}
+
+// benchmarkROM runs a ROM b.N times and reports the emulated clock speed.
+func benchmarkROM(b *testing.B, rom string, blocks bool) {
+	cycles := 0
+	var elapsed time.Duration
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tm := NewTestMachine(rom, false)
+		tm.SetOutput(ioutil.Discard)
+		if blocks {
+			tm.UseBlockCache()
+		}
+		b.StartTimer()
+		start := time.Now()
+		tm.Run()
+		elapsed += time.Since(start)
+		cycles += tm.cycles
+	}
+	b.ReportMetric(float64(cycles)/elapsed.Seconds()/1e6, "MHz")
+}
+
+func Benchmark8080PRE(b *testing.B) {
+	benchmarkROM(b, i8080PRE, false)
+}
+
+func Benchmark8080PREBlockCache(b *testing.B) {
+	benchmarkROM(b, i8080PRE, true)
+}
+
+func BenchmarkCPUTEST(b *testing.B) {
+	benchmarkROM(b, CPUTEST, false)
+}
+
+func BenchmarkCPUTESTBlockCache(b *testing.B) {
+	benchmarkROM(b, CPUTEST, true)
+}
+
+func TestVectors(t *testing.T) {
+	t.Parallel()
+	for opcode := range i8080.INSTRUCTIONS {
+		name := filepath.Join(*VECTORS, fmt.Sprintf("%02x.json", opcode))
+		vectors, err := LoadVectors(name)
+		if err != nil {
+			t.Errorf("[%02x] %v", opcode, err)
+			continue
+		}
+		for _, v := range vectors {
+			for _, diff := range RunVector(v) {
+				t.Errorf("[%s] %s", v.Name, diff)
+			}
+		}
 	}
 }
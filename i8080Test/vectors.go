@@ -0,0 +1,270 @@
+package i8080Test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/is386/Go8080/i8080"
+)
+
+// A vector file holds the single-step vectors of one opcode, one JSON object
+// per line inside an array:
+//
+//	{"name":"3a 0000","initial":{...},"final":{...},"cycles":13,
+//	 "bus":[[256,58,"r"],...],"ports":[[16,0,"r"]]}
+//
+// The initial and final states have pc, sp, a, b, c, d, e, f, h and l, and
+// ram as [addr, value] pairs. Initial ram holds every byte the instruction
+// reads, and final ram its value afterwards. bus is every memory access in
+// order and ports every IN and OUT.
+type VectorState struct {
+	PC  uint16      `json:"pc"`
+	SP  uint16      `json:"sp"`
+	A   uint8       `json:"a"`
+	B   uint8       `json:"b"`
+	C   uint8       `json:"c"`
+	D   uint8       `json:"d"`
+	E   uint8       `json:"e"`
+	F   uint8       `json:"f"`
+	H   uint8       `json:"h"`
+	L   uint8       `json:"l"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+type Access struct {
+	Addr uint16
+	Val  uint8
+	Kind string
+}
+
+func (a Access) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{a.Addr, a.Val, a.Kind})
+}
+
+func (a *Access) UnmarshalJSON(data []byte) error {
+	var fields []interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid access %s", data)
+	}
+	addr, ok1 := fields[0].(float64)
+	val, ok2 := fields[1].(float64)
+	kind, ok3 := fields[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("invalid access %s", data)
+	}
+	a.Addr, a.Val, a.Kind = uint16(addr), uint8(val), kind
+	return nil
+}
+
+type Vector struct {
+	Name    string      `json:"name"`
+	Initial VectorState `json:"initial"`
+	Final   VectorState `json:"final"`
+	Cycles  int         `json:"cycles"`
+	Bus     []Access    `json:"bus"`
+	Ports   []Access    `json:"ports,omitempty"`
+}
+
+// vectorMachine is memory and I/O that records every access. Reads of bytes
+// that were never set come from rng and are kept in initial, or are 0 when
+// rng is nil.
+type vectorMachine struct {
+	ram     map[uint16]uint8
+	initial map[uint16]uint8
+	rng     *rand.Rand
+	bus     []Access
+	ports   []Access
+	in      []uint8
+}
+
+func newVectorMachine(rng *rand.Rand) *vectorMachine {
+	return &vectorMachine{ram: map[uint16]uint8{}, initial: map[uint16]uint8{}, rng: rng}
+}
+
+func (m *vectorMachine) set(addr uint16, val uint8) {
+	m.ram[addr] = val
+	m.initial[addr] = val
+}
+
+func (m *vectorMachine) Read(addr uint16) uint8 {
+	val, ok := m.ram[addr]
+	if !ok && m.rng != nil {
+		val = uint8(m.rng.Intn(256))
+		m.set(addr, val)
+	}
+	m.bus = append(m.bus, Access{addr, val, "r"})
+	return val
+}
+
+func (m *vectorMachine) Write(addr uint16, val uint8) {
+	m.ram[addr] = val
+	m.bus = append(m.bus, Access{addr, val, "w"})
+}
+
+func (m *vectorMachine) In(port uint8) uint8 {
+	var val uint8
+	if m.rng != nil {
+		val = uint8(m.rng.Intn(256))
+	} else if len(m.in) > 0 {
+		val, m.in = m.in[0], m.in[1:]
+	}
+	m.ports = append(m.ports, Access{uint16(port), val, "r"})
+	return val
+}
+
+func (m *vectorMachine) Out(port uint8, val uint8) {
+	m.ports = append(m.ports, Access{uint16(port), val, "w"})
+}
+
+func ramList(ram map[uint16]uint8) [][2]uint16 {
+	list := [][2]uint16{}
+	for addr, val := range ram {
+		list = append(list, [2]uint16{addr, uint16(val)})
+	}
+	sort.Slice(list, func(i int, j int) bool { return list[i][0] < list[j][0] })
+	return list
+}
+
+func captureState(cpu *i8080.CPU, ram [][2]uint16) VectorState {
+	af, bc, de, hl := cpu.GetAF(), cpu.GetBC(), cpu.GetDE(), cpu.GetHL()
+	return VectorState{
+		PC: cpu.GetPC(), SP: cpu.GetSP(),
+		A: uint8(af >> 8), F: uint8(af), B: uint8(bc >> 8), C: uint8(bc),
+		D: uint8(de >> 8), E: uint8(de), H: uint8(hl >> 8), L: uint8(hl),
+		RAM: ram,
+	}
+}
+
+func setState(cpu *i8080.CPU, s VectorState) {
+	cpu.SetAF(uint16(s.A)<<8 | uint16(s.F))
+	cpu.SetBC(uint16(s.B)<<8 | uint16(s.C))
+	cpu.SetDE(uint16(s.D)<<8 | uint16(s.E))
+	cpu.SetHL(uint16(s.H)<<8 | uint16(s.L))
+	cpu.SetPC(s.PC)
+	cpu.SetSP(s.SP)
+}
+
+// GenerateVectors executes opcode n times from random states and records
+// what the core does, for use as regression vectors.
+func GenerateVectors(opcode uint8, n int, rng *rand.Rand) []Vector {
+	vectors := make([]Vector, n)
+	for i := range vectors {
+		m := newVectorMachine(rng)
+		cpu := i8080.NewCPU(0, m, m)
+		state := VectorState{
+			PC: uint16(rng.Intn(0x10000)), SP: uint16(rng.Intn(0x10000)),
+			A: uint8(rng.Intn(256)), F: uint8(rng.Intn(256)),
+			B: uint8(rng.Intn(256)), C: uint8(rng.Intn(256)), D: uint8(rng.Intn(256)),
+			E: uint8(rng.Intn(256)), H: uint8(rng.Intn(256)), L: uint8(rng.Intn(256)),
+		}
+		setState(cpu, state)
+		m.set(state.PC, opcode)
+		cpu.Execute()
+
+		v := &vectors[i]
+		v.Name = fmt.Sprintf("%02x %04d", opcode, i)
+		v.Final = captureState(cpu, ramList(m.ram))
+		setState(cpu, state)
+		v.Initial = captureState(cpu, ramList(m.initial))
+		v.Cycles = cpu.GetCycles()
+		v.Bus, v.Ports = m.bus, m.ports
+	}
+	return vectors
+}
+
+// RunVector executes the instruction of v and describes every difference
+// from its final state.
+func RunVector(v Vector) []string {
+	m := newVectorMachine(nil)
+	for _, p := range v.Initial.RAM {
+		m.set(p[0], uint8(p[1]))
+	}
+	for _, p := range v.Ports {
+		if p.Kind == "r" {
+			m.in = append(m.in, p.Val)
+		}
+	}
+	cpu := i8080.NewCPU(0, m, m)
+	setState(cpu, v.Initial)
+	cpu.Execute()
+
+	var diffs []string
+	got, want := captureState(cpu, nil), v.Final
+	regs := []struct {
+		name      string
+		got, want uint16
+	}{
+		{"pc", got.PC, want.PC}, {"sp", got.SP, want.SP},
+		{"a", uint16(got.A), uint16(want.A)}, {"f", uint16(got.F), uint16(want.F)},
+		{"b", uint16(got.B), uint16(want.B)}, {"c", uint16(got.C), uint16(want.C)},
+		{"d", uint16(got.D), uint16(want.D)}, {"e", uint16(got.E), uint16(want.E)},
+		{"h", uint16(got.H), uint16(want.H)}, {"l", uint16(got.L), uint16(want.L)},
+	}
+	for _, r := range regs {
+		if r.got != r.want {
+			diffs = append(diffs, fmt.Sprintf("%s expected: %04X, actual: %04X", r.name, r.want, r.got))
+		}
+	}
+	for _, p := range want.RAM {
+		if val := m.ram[p[0]]; uint16(val) != p[1] {
+			diffs = append(diffs, fmt.Sprintf("ram %04X expected: %02X, actual: %02X", p[0], p[1], val))
+		}
+	}
+	if v.Cycles != cpu.GetCycles() {
+		diffs = append(diffs, fmt.Sprintf("cycles expected: %d, actual: %d", v.Cycles, cpu.GetCycles()))
+	}
+	if a, b := accesses(v.Bus), accesses(m.bus); a != b {
+		diffs = append(diffs, fmt.Sprintf("bus expected: %s, actual: %s", a, b))
+	}
+	if a, b := accesses(v.Ports), accesses(m.ports); a != b {
+		diffs = append(diffs, fmt.Sprintf("ports expected: %s, actual: %s", a, b))
+	}
+	return diffs
+}
+
+func accesses(list []Access) string {
+	s := make([]string, len(list))
+	for i, a := range list {
+		s[i] = fmt.Sprintf("%s %04X=%02X", a.Kind, a.Addr, a.Val)
+	}
+	return strings.Join(s, ", ")
+}
+
+func LoadVectors(filename string) ([]Vector, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var vectors []Vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return vectors, nil
+}
+
+// SaveVectors writes vectors with one per line, so that regenerated files
+// diff well.
+func SaveVectors(filename string, vectors []Vector) error {
+	var sb strings.Builder
+	sb.WriteString("[\n")
+	for i, v := range vectors {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		sb.Write(line)
+		if i < len(vectors)-1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("]\n")
+	return ioutil.WriteFile(filename, []byte(sb.String()), 0644)
+}
@@ -1,14 +1,44 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/is386/Go8080/i8080/gdbstub"
 	"github.com/is386/Go8080/i8080Invaders"
 )
 
 var (
 	DEBUG = false
+	GDB   = ""
 )
 
 func main() {
+	flag.BoolVar(&DEBUG, "debug", DEBUG, "start in the debugger")
+	flag.StringVar(&GDB, "gdb", GDB, "wait for GDB on a TCP address such as localhost:1234")
+	flag.Parse()
+
 	im := i8080Invaders.NewInvadersMachine()
-	im.Run()
+	if GDB != "" {
+		if err := gdbstub.New(im.Debugger()).ListenAndServe(GDB); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if !DEBUG {
+		im.Run()
+		return
+	}
+	d := im.Debugger()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			d.Pause()
+		}
+	}()
+	d.REPL(os.Stdin, os.Stdout)
 }